@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// MapToStruct 把字符串 map 绑定到结构体指针上，key 取字段的 json 标签名，没有标签时使用字段名
+// 字符串按字段类型转换：
+//
+//	time.Time      按 `time:"<layout>"` 标签解析，默认 RFC3339
+//	time.Duration  按 time.ParseDuration 解析，例如 "1m30s"
+//	其余基础类型    按 strconv 解析
+//
+// map 中没有的字段保持原值
+func MapToStruct(m map[string]string, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("map to struct: expected non-nil struct pointer, got %T", out)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := field.Name
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+			key = name
+		}
+		s, ok := m[key]
+		if !ok {
+			continue
+		}
+		if err := setFromString(rv.Field(i), field, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFromString 把字符串转换为字段的类型后赋值，错误信息中带上字段名和期望的格式
+func setFromString(v reflect.Value, field reflect.StructField, s string) error {
+	switch v.Type() {
+	case timeType:
+		layout := field.Tag.Get("time")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return fmt.Errorf("field %s: parse %q with layout %q: %w", field.Name, s, layout, err)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("field %s: parse %q as duration (e.g. 1m30s): %w", field.Name, s, err)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	cv, err := convertString(s, v.Type())
+	if err != nil {
+		return fmt.Errorf("field %s: %w", field.Name, err)
+	}
+	v.Set(cv)
+	return nil
+}
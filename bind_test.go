@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type leaseConfig struct {
+	Name      string        `json:"name"`
+	StartAt   time.Time     `json:"start_at"`
+	Day       time.Time     `json:"day" time:"2006-01-02"`
+	TTL       time.Duration `json:"ttl"`
+	Retries   int
+	Untouched string
+}
+
+func TestMapToStructTimeFields(t *testing.T) {
+	cfg := leaseConfig{Untouched: "keep"}
+	err := MapToStruct(map[string]string{
+		"name":     "order",
+		"start_at": "2024-05-01T08:30:00Z",
+		"day":      "2024-05-02",
+		"ttl":      "1m30s",
+		"Retries":  "3",
+	}, &cfg)
+	if err != nil {
+		t.Fatalf("MapToStruct failed: %v", err)
+	}
+	if !cfg.StartAt.Equal(time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("StartAt = %v", cfg.StartAt)
+	}
+	if !cfg.Day.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Day = %v", cfg.Day)
+	}
+	if cfg.TTL != 90*time.Second || cfg.Retries != 3 || cfg.Name != "order" || cfg.Untouched != "keep" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
+
+func TestMapToStructTimeError(t *testing.T) {
+	var cfg leaseConfig
+	err := MapToStruct(map[string]string{"day": "05/02/2024"}, &cfg)
+	if err == nil || !strings.Contains(err.Error(), "Day") || !strings.Contains(err.Error(), "2006-01-02") {
+		t.Errorf("Expected error naming the field and layout, got %v", err)
+	}
+}
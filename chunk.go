@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Chunk 把切片按 size 拆分为多个子切片，最后一块可能不足 size
+// 每个元素都是原元素类型的切片（例如 []int），与原切片共享底层数组
+func Chunk(slice interface{}, size int) ([]interface{}, error) {
+	if size <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+	v := reflect.ValueOf(slice)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("chunk: expected slice, got %T", slice)
+	}
+	chunks := make([]interface{}, 0, (v.Len()+size-1)/size)
+	for start := 0; start < v.Len(); start += size {
+		end := start + size
+		if end > v.Len() {
+			end = v.Len()
+		}
+		// 使用三下标切片限制容量，避免对子切片 append 时覆盖下一块
+		chunks = append(chunks, v.Slice3(start, end, end).Interface())
+	}
+	return chunks, nil
+}
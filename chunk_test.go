@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	chunks, err := Chunk([]int{0, 1, 2, 3, 4}, 2)
+	if err != nil {
+		t.Fatalf("Chunk failed: %v", err)
+	}
+	want := []interface{}{[]int{0, 1}, []int{2, 3}, []int{4}}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("Chunk = %v, want %v", chunks, want)
+	}
+}
+
+func TestChunkInvalidInput(t *testing.T) {
+	if _, err := Chunk("not a slice", 2); err == nil {
+		t.Errorf("Expected error for non-slice input")
+	}
+	if _, err := Chunk([]int{1}, 0); err == nil {
+		t.Errorf("Expected error for non-positive size")
+	}
+}
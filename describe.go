@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// describeMaxDepth 限制嵌套结构体的展开层数，避免深层或自引用结构输出失控
+const describeMaxDepth = 3
+
+// FieldDesc 描述结构体中的一个导出字段，用于通用的对象查看器
+type FieldDesc struct {
+	Name     string // 字段路径，嵌套字段用 . 连接，例如 Addr.Host
+	Kind     reflect.Kind
+	TypeName string
+	Value    string
+	Tag      map[string]string
+}
+
+// Describe 通过反射列出结构体（或结构体指针）的全部导出字段
+// 嵌套结构体最多展开 describeMaxDepth 层，超出的部分只输出类型不再展开
+// 非结构体输入返回 nil
+func Describe(v interface{}) []FieldDesc {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var descs []FieldDesc
+	describeStruct(rv, "", 1, &descs)
+	return descs
+}
+
+func describeStruct(rv reflect.Value, prefix string, depth int, descs *[]FieldDesc) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		desc := FieldDesc{
+			Name:     prefix + field.Name,
+			Kind:     fv.Kind(),
+			TypeName: field.Type.String(),
+			Tag:      parseStructTag(field.Tag),
+		}
+		// 指针为 nil 时输出 "nil"，否则解引用后按指向的值处理
+		elem := fv
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				desc.Value = "nil"
+				*descs = append(*descs, desc)
+				continue
+			}
+			elem = elem.Elem()
+		}
+		switch elem.Kind() {
+		case reflect.Struct:
+			desc.Value = "{...}"
+			*descs = append(*descs, desc)
+			if depth < describeMaxDepth {
+				describeStruct(elem, desc.Name+".", depth+1, descs)
+			}
+			continue
+		case reflect.Slice, reflect.Array:
+			desc.Value = fmt.Sprintf("len=%d elem=%s", elem.Len(), elem.Type().Elem().Kind())
+		case reflect.Map:
+			desc.Value = fmt.Sprintf("len=%d", elem.Len())
+		default:
+			desc.Value = fmt.Sprint(elem.Interface())
+		}
+		*descs = append(*descs, desc)
+	}
+}
+
+// parseStructTag 把 `json:"name" etcd:"key"` 形式的标签解析为 map
+// reflect.StructTag 只支持按 key 查询，不支持枚举，所以这里按标签约定的格式逐个解析
+func parseStructTag(tag reflect.StructTag) map[string]string {
+	tags := make(map[string]string)
+	s := string(tag)
+	for s != "" {
+		// 跳过 key 之间的空格
+		i := 0
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		s = s[i:]
+		if s == "" {
+			break
+		}
+		// key 到冒号为止，后面必须紧跟带引号的 value
+		i = 0
+		for i < len(s) && s[i] > ' ' && s[i] != ':' && s[i] != '"' {
+			i++
+		}
+		if i == 0 || i+1 >= len(s) || s[i] != ':' || s[i+1] != '"' {
+			break
+		}
+		key := s[:i]
+		s = s[i+1:]
+		i = 1
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		value, err := strconv.Unquote(s[:i+1])
+		if err != nil {
+			break
+		}
+		tags[key] = value
+		s = s[i+1:]
+	}
+	return tags
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDescribePerson(t *testing.T) {
+	descs := Describe(&Person{Name: "Alice", Age: 30})
+	want := []FieldDesc{
+		{Name: "Name", Kind: reflect.String, TypeName: "string", Value: "Alice", Tag: map[string]string{"json": "name"}},
+		{Name: "Age", Kind: reflect.Int, TypeName: "int", Value: "30", Tag: map[string]string{"json": "age"}},
+	}
+	if !reflect.DeepEqual(descs, want) {
+		t.Errorf("Describe(Person) = %+v, want %+v", descs, want)
+	}
+}
+
+type describeNode struct {
+	Owner *Person
+	Next  *describeNode
+	Tags  []string
+	note  string
+}
+
+func TestDescribeNested(t *testing.T) {
+	n := &describeNode{Owner: &Person{Name: "Bob"}, Tags: []string{"a", "b"}, note: "hidden"}
+	n.Next = n // 自引用，展开层数受 describeMaxDepth 限制
+	got := make(map[string]FieldDesc)
+	for _, d := range Describe(n) {
+		got[d.Name] = d
+	}
+	if got["Owner.Name"].Value != "Bob" {
+		t.Errorf("Owner.Name = %q, want Bob", got["Owner.Name"].Value)
+	}
+	if got["Tags"].Value != "len=2 elem=string" {
+		t.Errorf("Tags = %q, want len=2 elem=string", got["Tags"].Value)
+	}
+	if _, ok := got["note"]; ok {
+		t.Errorf("Unexported field should not be described")
+	}
+	if _, ok := got["Next.Next.Next.Tags"]; ok {
+		t.Errorf("Nesting should stop at depth %d", describeMaxDepth)
+	}
+	if _, ok := got["Next.Next.Tags"]; !ok {
+		t.Errorf("Expected Next.Next.Tags within depth limit")
+	}
+	if Describe(42) != nil {
+		t.Errorf("Expected nil for non-struct input")
+	}
+}
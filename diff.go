@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FieldChange 描述两个值之间的一处差异
+// Path 是字段路径：嵌套字段用 . 连接，切片元素用 [i]，map 元素用 [key]，例如 Endpoints[1].Port
+// 切片变长或变短时多出的元素一侧为 nil，map 中新增或删除的 key 同理
+type FieldChange struct {
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+// DiffStructs 逐字段比较两个同类型的结构体（或结构体指针），返回全部差异
+// 嵌套结构体递归比较，切片和 map 按元素比较，time.Time 按 Equal 比较；未导出字段忽略
+// 两个参数的具体类型不同时返回错误
+func DiffStructs(old, new interface{}) ([]FieldChange, error) {
+	vo, vn := reflect.ValueOf(old), reflect.ValueOf(new)
+	if !vo.IsValid() || !vn.IsValid() || vo.Type() != vn.Type() {
+		return nil, fmt.Errorf("diff structs: type mismatch: %T vs %T", old, new)
+	}
+	for vo.Kind() == reflect.Ptr {
+		if vo.IsNil() || vn.IsNil() {
+			return nil, fmt.Errorf("diff structs: nil pointer %T", old)
+		}
+		vo, vn = vo.Elem(), vn.Elem()
+	}
+	if vo.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("diff structs: expected struct, got %T", old)
+	}
+	var changes []FieldChange
+	diffValues(vo, vn, "", &changes)
+	return changes, nil
+}
+
+func diffValues(a, b reflect.Value, path string, changes *[]FieldChange) {
+	switch {
+	case a.Type() == timeType:
+		if !a.Interface().(time.Time).Equal(b.Interface().(time.Time)) {
+			*changes = append(*changes, FieldChange{path, a.Interface(), b.Interface()})
+		}
+		return
+	case a.Kind() == reflect.Struct:
+		rt := a.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			if field := rt.Field(i); field.IsExported() {
+				diffValues(a.Field(i), b.Field(i), joinPath(path, field.Name), changes)
+			}
+		}
+		return
+	case a.Kind() == reflect.Ptr:
+		if !a.IsNil() && !b.IsNil() {
+			diffValues(a.Elem(), b.Elem(), path, changes)
+			return
+		}
+	case a.Kind() == reflect.Slice || a.Kind() == reflect.Array:
+		for i := 0; i < a.Len() || i < b.Len(); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= a.Len():
+				*changes = append(*changes, FieldChange{elemPath, nil, b.Index(i).Interface()})
+			case i >= b.Len():
+				*changes = append(*changes, FieldChange{elemPath, a.Index(i).Interface(), nil})
+			default:
+				diffValues(a.Index(i), b.Index(i), elemPath, changes)
+			}
+		}
+		return
+	case a.Kind() == reflect.Map:
+		keys := a.MapKeys()
+		for _, k := range b.MapKeys() {
+			if !a.MapIndex(k).IsValid() {
+				keys = append(keys, k)
+			}
+		}
+		// map 遍历顺序随机，按 key 的字符串形式排序让结果稳定
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			elemPath := fmt.Sprintf("%s[%v]", path, k)
+			va, vb := a.MapIndex(k), b.MapIndex(k)
+			switch {
+			case !va.IsValid():
+				*changes = append(*changes, FieldChange{elemPath, nil, vb.Interface()})
+			case !vb.IsValid():
+				*changes = append(*changes, FieldChange{elemPath, va.Interface(), nil})
+			default:
+				diffValues(va, vb, elemPath, changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		*changes = append(*changes, FieldChange{path, a.Interface(), b.Interface()})
+	}
+}
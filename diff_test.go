@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type diffEndpoint struct {
+	Host string
+	Port int
+}
+
+type diffConfig struct {
+	Name      string
+	Weight    float64
+	Primary   diffEndpoint
+	Endpoints []diffEndpoint
+	Labels    map[string]string
+	hidden    int
+}
+
+func TestDiffStructsScalar(t *testing.T) {
+	old := diffConfig{Name: "order", Weight: 1, hidden: 1}
+	changes, err := DiffStructs(old, diffConfig{Name: "order", Weight: 2, hidden: 2})
+	if err != nil {
+		t.Fatalf("DiffStructs failed: %v", err)
+	}
+	want := []FieldChange{{"Weight", 1.0, 2.0}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("DiffStructs = %+v, want %+v", changes, want)
+	}
+	if changes, _ := DiffStructs(&old, &old); len(changes) != 0 {
+		t.Errorf("Expected no changes for identical values, got %+v", changes)
+	}
+}
+
+func TestDiffStructsNested(t *testing.T) {
+	old := diffConfig{
+		Primary: diffEndpoint{Host: "10.0.0.1", Port: 8080},
+		Labels:  map[string]string{"zone": "a", "tier": "web"},
+	}
+	new := diffConfig{
+		Primary: diffEndpoint{Host: "10.0.0.1", Port: 9090},
+		Labels:  map[string]string{"zone": "b", "canary": "true"},
+	}
+	changes, err := DiffStructs(&old, &new)
+	if err != nil {
+		t.Fatalf("DiffStructs failed: %v", err)
+	}
+	want := []FieldChange{
+		{"Primary.Port", 8080, 9090},
+		{"Labels[canary]", nil, "true"},
+		{"Labels[tier]", "web", nil},
+		{"Labels[zone]", "a", "b"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("DiffStructs = %+v, want %+v", changes, want)
+	}
+}
+
+func TestDiffStructsSliceLength(t *testing.T) {
+	old := diffConfig{Endpoints: []diffEndpoint{{"10.0.0.1", 80}, {"10.0.0.2", 80}}}
+	new := diffConfig{Endpoints: []diffEndpoint{{"10.0.0.1", 81}, {"10.0.0.2", 80}, {"10.0.0.3", 80}}}
+	changes, err := DiffStructs(old, new)
+	if err != nil {
+		t.Fatalf("DiffStructs failed: %v", err)
+	}
+	want := []FieldChange{
+		{"Endpoints[0].Port", 80, 81},
+		{"Endpoints[2]", nil, diffEndpoint{"10.0.0.3", 80}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("DiffStructs = %+v, want %+v", changes, want)
+	}
+
+	changes, _ = DiffStructs(new, old)
+	if len(changes) != 2 || changes[1].Path != "Endpoints[2]" || changes[1].New != nil {
+		t.Errorf("Expected removed element to be reported with nil New, got %+v", changes)
+	}
+}
+
+func TestDiffStructsTypeMismatch(t *testing.T) {
+	if _, err := DiffStructs(diffConfig{}, &diffConfig{}); err == nil {
+		t.Errorf("Expected error for struct vs pointer")
+	}
+	if _, err := DiffStructs(diffConfig{}, diffEndpoint{}); err == nil {
+		t.Errorf("Expected error for different struct types")
+	}
+	if _, err := DiffStructs(1, 2); err == nil {
+		t.Errorf("Expected error for non-struct input")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// EqualApprox 深度比较两个同类型的值，float32/float64 在 epsilon 误差内视为相等，其余类型精确比较
+// 用于断言计算出的权重、延迟等浮点结果，两个值类型不同时返回错误
+func EqualApprox(a, b interface{}, epsilon float64) (bool, error) {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return va.IsValid() == vb.IsValid(), nil
+	}
+	if va.Type() != vb.Type() {
+		return false, fmt.Errorf("type mismatch: %s vs %s", va.Type(), vb.Type())
+	}
+	return equalApprox(va, vb, epsilon), nil
+}
+
+func equalApprox(a, b reflect.Value, epsilon float64) bool {
+	switch a.Kind() {
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return false
+		}
+		return x == y || math.Abs(x-y) <= epsilon
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		// 接口中的动态类型可能不同
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return equalApprox(a.Elem(), b.Elem(), epsilon)
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equalApprox(a.Field(i), b.Field(i), epsilon) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() {
+			return false
+		}
+		fallthrough
+	case reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalApprox(a.Index(i), b.Index(i), epsilon) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			vb := b.MapIndex(iter.Key())
+			if !vb.IsValid() || !equalApprox(iter.Value(), vb, epsilon) {
+				return false
+			}
+		}
+		return true
+	// 以下按种类读取底层值比较，未导出字段也不能调用 Interface
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	default:
+		// chan、func 等只在指向同一对象时相等
+		return a.Pointer() == b.Pointer()
+	}
+}
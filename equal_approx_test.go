@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+type latencyStat struct {
+	Name    string
+	Weight  float64
+	Latency []float32
+	meta    map[string]float64
+}
+
+func TestEqualApprox(t *testing.T) {
+	a := latencyStat{Name: "order", Weight: 0.3, Latency: []float32{1.5, 2.5}, meta: map[string]float64{"p99": 10}}
+	near := latencyStat{Name: "order", Weight: 0.1 + 0.2, Latency: []float32{1.5000001, 2.5}, meta: map[string]float64{"p99": 10.0000001}}
+	far := latencyStat{Name: "order", Weight: 0.31, Latency: []float32{1.5, 2.5}, meta: map[string]float64{"p99": 10}}
+
+	if ok, err := EqualApprox(a, near, 1e-6); err != nil || !ok {
+		t.Errorf("EqualApprox(a, near) = %v, %v, want true", ok, err)
+	}
+	if ok, err := EqualApprox(a, far, 1e-6); err != nil || ok {
+		t.Errorf("EqualApprox(a, far) = %v, %v, want false", ok, err)
+	}
+	diffName := near
+	diffName.Name = "user"
+	if ok, _ := EqualApprox(a, diffName, 1e-6); ok {
+		t.Errorf("Non-float fields must be compared exactly")
+	}
+}
+
+func TestEqualApproxTypeMismatch(t *testing.T) {
+	if _, err := EqualApprox(1.0, float32(1.0), 0.1); err == nil {
+		t.Errorf("Expected error for mismatched types")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DefaultBarrierTTL 是 EtcdBarrier 每个参与者租约的秒数，参与者崩溃后最多这么久才从计数中扣除
+const DefaultBarrierTTL = 10
+
+// EtcdBarrier 是基于 etcd 的分布式屏障：参与者到齐之前 Wait 阻塞，到齐后全部放行
+//
+// 每个参与者 Enter 时在 key 下写入绑定自己租约的 key（{key}/{leaseID}），key 的数量就是到达的人数。
+// 参与者在屏障完成前崩溃时租约过期、key 被删除，人数随之减少，等待者继续等待新的参与者补齐。
+// 一个 EtcdBarrier 代表一个参与者，每个参与者各自创建自己的 EtcdBarrier
+type EtcdBarrier struct {
+	client       *clientv3.Client
+	key          string
+	participants int
+
+	leaseID         clientv3.LeaseID
+	cancelKeepAlive context.CancelFunc
+}
+
+// NewEtcdBarrier 创建 key 上需要 participants 个参与者到齐的屏障，同一个 key 的全部参与者必须使用相同的人数
+func NewEtcdBarrier(client *clientv3.Client, key string, participants int) (*EtcdBarrier, error) {
+	key = strings.TrimRight(key, "/")
+	if key == "" {
+		return nil, errors.New("barrier key cannot be empty")
+	}
+	if len(key) > maxLockKeyBytes {
+		return nil, fmt.Errorf("barrier key too long: %d bytes, max %d", len(key), maxLockKeyBytes)
+	}
+	if participants <= 0 {
+		return nil, errors.New("barrier participants must be positive")
+	}
+	return &EtcdBarrier{client: client, key: key, participants: participants}, nil
+}
+
+// Key 返回屏障在 etcd 中的 key，参与者的 key 都在它下面
+func (b *EtcdBarrier) Key() string {
+	return b.key
+}
+
+// Enter 登记到达屏障，登记的 key 绑定在自己的租约上并持续续约，直到 Leave
+func (b *EtcdBarrier) Enter(ctx context.Context) error {
+	if b.cancelKeepAlive != nil {
+		return errors.New("already entered the barrier")
+	}
+	leaseID, cancel, err := grantWithKeepAlive(ctx, b.client, DefaultBarrierTTL)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	if _, err := b.client.Put(ctx, fmt.Sprintf("%s/%x", b.key, leaseID), fmt.Sprintf("%s-%d", host, os.Getpid()), clientv3.WithLease(leaseID)); err != nil {
+		cancel()
+		b.client.Revoke(context.Background(), leaseID)
+		return err
+	}
+	b.leaseID, b.cancelKeepAlive = leaseID, cancel
+	return nil
+}
+
+// Wait 阻塞直到到达的参与者达到设定人数或 ctx 结束
+// 人数在某一时刻达到过就算完成，之后有参与者离开也不会让 Wait 重新阻塞
+func (b *EtcdBarrier) Wait(ctx context.Context) error {
+	resp, err := b.client.Get(ctx, b.key+"/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	arrived := resp.Count
+	if arrived >= int64(b.participants) {
+		return nil
+	}
+	// 从计数之后的 revision 开始按事件增减人数，避免错过计数和 Watch 之间的变化
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wresp := range b.client.Watch(watchCtx, b.key+"/", clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1)) {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			switch {
+			case ev.IsCreate():
+				arrived++
+			case ev.Type == clientv3.EventTypeDelete:
+				// 参与者崩溃（租约过期）或离开
+				arrived--
+			}
+			if arrived >= int64(b.participants) {
+				return nil
+			}
+		}
+	}
+	// Watch 通道只会因为 ctx 结束而关闭
+	return ctx.Err()
+}
+
+// Leave 撤销租约，删除自己登记的 key
+func (b *EtcdBarrier) Leave(ctx context.Context) error {
+	if b.cancelKeepAlive == nil {
+		return errors.New("not in the barrier")
+	}
+	b.cancelKeepAlive()
+	b.cancelKeepAlive = nil
+	_, err := b.client.Revoke(ctx, b.leaseID)
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEtcdBarrier 三个参与者的屏障：中途崩溃的参与者不计入人数，第三个参与者到达后全部放行
+func TestEtcdBarrier(t *testing.T) {
+	client := newTestEtcdClient(t)
+	const key = "/barriers/test/rollout"
+	newParticipant := func() *EtcdBarrier {
+		b, err := NewEtcdBarrier(client, key, 3)
+		if err != nil {
+			t.Fatalf("Failed to create barrier: %v", err)
+		}
+		if err := b.Enter(context.Background()); err != nil {
+			t.Fatalf("Failed to enter barrier: %v", err)
+		}
+		return b
+	}
+	first := newParticipant()
+	defer first.Leave(context.Background())
+	crashed := newParticipant()
+
+	released := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := first.Wait(context.Background()); err != nil {
+			t.Errorf("Wait failed: %v", err)
+		}
+		close(released)
+	}()
+
+	// 模拟崩溃：停止续约、租约被撤销，人数回到 1
+	crashed.cancelKeepAlive()
+	if _, err := client.Revoke(context.Background(), crashed.leaseID); err != nil {
+		t.Fatalf("Failed to revoke crashed participant's lease: %v", err)
+	}
+	second := newParticipant()
+	defer second.Leave(context.Background())
+	select {
+	case <-released:
+		t.Fatalf("Barrier released with only two live participants")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	third := newParticipant()
+	defer third.Leave(context.Background())
+	select {
+	case <-released:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Barrier not released after the third participant arrived")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := third.Wait(ctx); err != nil {
+		t.Errorf("Wait after completion = %v, want nil", err)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// maxLockKeyBytes 限制锁 key 的长度
+// etcd 本身只限制单个请求的大小，但 key 会出现在每一个 revision 中，过长的 key 没有意义
+const maxLockKeyBytes = 1024
+
+// LockOption 配置 EtcdDistributedLock
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	// 锁 key 的命名空间前缀，例如 /locks/order，最终 key 为 /locks/order/{name}
+	prefix string
+	// 记录获取次数和等待时间，为 nil 时不统计
+	heatmap *LockHeatmap
+	// 不启动自动续约，锁在 ttl 后确定性地过期
+	noAutoRenew bool
+	// 写入锁 value 的持有者标识，默认 hostname-pid
+	identity string
+	// 按请求顺序排队获得锁，见 WithFIFO
+	fifo bool
+}
+
+// WithLockPrefix 把锁放到指定前缀下，不同子系统使用不同前缀可以避免同名锁互相争用
+func WithLockPrefix(prefix string) LockOption {
+	return func(o *lockOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithNoAutoRenew 获取锁后不续约租约，锁会在 ttl 秒后过期，Expired 随之关闭
+// 仅用于测试租约过期、fencing 等行为，或确定在 ttl 内完成的短任务；正常使用时不要开启
+func WithNoAutoRenew() LockOption {
+	return func(o *lockOptions) {
+		o.noAutoRenew = true
+	}
+}
+
+// WithLockIdentity 设置写入锁 value 的持有者标识，抢锁失败的一方可以通过 LockOrInspect 看到它
+func WithLockIdentity(identity string) LockOption {
+	return func(o *lockOptions) {
+		o.identity = identity
+	}
+}
+
+// WithFIFO 让锁按请求顺序公平地分配，行为与 concurrency.Mutex 相同：
+// 每个竞争者在锁 key 下写入自己的排队 key（{key}/{leaseID}），CreateRevision 最小的持有锁，
+// 其余竞争者只监听排在自己前面的那一个 key，释放时只唤醒下一个，不会出现后来者插队
+// 同名锁的全部竞争者必须使用同一种模式，FIFO 模式和默认模式之间互不排斥
+func WithFIFO() LockOption {
+	return func(o *lockOptions) {
+		o.fifo = true
+	}
+}
+
+// HolderInfo 是锁当前持有者的信息
+type HolderInfo struct {
+	Identity   string    `json:"identity"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	// FencingToken 是锁 key 的 CreateRevision，与 Lock 返回的 token 相同
+	FencingToken uint64 `json:"-"`
+}
+
+// decodeHolder 解析锁 value；旧版本写入的 value 不是 JSON，原样作为 Identity
+func decodeHolder(kv *mvccpb.KeyValue) HolderInfo {
+	var h HolderInfo
+	if err := json.Unmarshal(kv.Value, &h); err != nil {
+		h = HolderInfo{Identity: string(kv.Value)}
+	}
+	h.FencingToken = uint64(kv.CreateRevision)
+	return h
+}
+
+// EtcdDistributedLock 是基于 etcd 原生 API 的分布式锁：
+// 事务判断 key 不存在（CreateRevision == 0）时写入带租约的 key 获得锁，否则监听 key 的删除事件后重试
+// 开启 WithFIFO 时改为按请求顺序排队
+type EtcdDistributedLock struct {
+	client *clientv3.Client
+	// 等待锁和监听过期使用的 Watcher，默认就是 client，测试中可以替换成假实现
+	watcher clientv3.Watcher
+	key     string
+	ttl     int64
+	opts    lockOptions
+
+	// 本次持有锁写入的 key：默认模式下就是 key，FIFO 模式下是自己的排队 key
+	heldKey         string
+	leaseID         clientv3.LeaseID
+	cancelKeepAlive context.CancelFunc
+	// 持有期间锁 key 被删除（租约过期）时关闭
+	expired     chan struct{}
+	cancelWatch context.CancelFunc
+	// 持有期间续约中断或锁 key 被删除时关闭，见 Done
+	lost *lockLoss
+}
+
+// lockLoss 是一次持有的丢锁通知，续约 goroutine 和过期监听都可能触发，只关闭一次
+type lockLoss struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newLockLoss() *lockLoss {
+	return &lockLoss{ch: make(chan struct{})}
+}
+
+func (l *lockLoss) signal() {
+	l.once.Do(func() { close(l.ch) })
+}
+
+// NewEtcdDistributedLock 创建名为 name 的分布式锁，ttl 为锁租约的秒数
+func NewEtcdDistributedLock(client *clientv3.Client, name string, ttl int64, opts ...LockOption) (*EtcdDistributedLock, error) {
+	o := lockOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.identity == "" {
+		host, _ := os.Hostname()
+		o.identity = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	key, err := lockKey(o.prefix, name)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, errors.New("lock ttl must be positive")
+	}
+	return &EtcdDistributedLock{
+		client:  client,
+		watcher: client,
+		key:     key,
+		ttl:     ttl,
+		opts:    o,
+	}, nil
+}
+
+// lockKey 把前缀和锁名拼接为最终的 key，并检查 key 是否合法
+func lockKey(prefix, name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", errors.New("lock name cannot be empty")
+	}
+	key := name
+	if prefix = strings.TrimRight(prefix, "/"); prefix != "" {
+		key = prefix + "/" + name
+	}
+	if len(key) > maxLockKeyBytes {
+		return "", fmt.Errorf("lock key too long: %d bytes, max %d", len(key), maxLockKeyBytes)
+	}
+	return key, nil
+}
+
+// Key 返回锁在 etcd 中的完整 key，FIFO 模式下是排队 key 的前缀
+func (l *EtcdDistributedLock) Key() string {
+	return l.key
+}
+
+// Lock 阻塞直到获得锁或 ctx 结束，返回本次持有的 fencing token
+//
+// 租约过期、GC 停顿或网络分区时，旧持有者可能还以为自己持有锁，
+// fencing token 用来让被保护的资源识别这种情况。token 是锁 key 的 CreateRevision：
+// 每次获得锁都会重新创建 key，所以同一个 key 上先后获得的 token 严格递增。
+// 持有者访问资源时带上 token，资源端记录见过的最大 token，并在同一个原子操作中
+// 拒绝小于它的请求（例如数据库中 UPDATE ... WHERE fencing_token <= ?）
+func (l *EtcdDistributedLock) Lock(ctx context.Context) (uint64, error) {
+	start := time.Now()
+	// 申请租约并启动自动续约，进程崩溃后租约过期，锁自动释放
+	leaseResp, err := l.client.Grant(ctx, l.ttl)
+	if err != nil {
+		return 0, err
+	}
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	loss := newLockLoss()
+	if !l.opts.noAutoRenew {
+		keepAliveCh, err := l.client.KeepAlive(keepAliveCtx, leaseResp.ID)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		// 必须持续消费续约响应，否则通道写满后续约会阻塞
+		// 续约通道在 Unlock 之外关闭说明租约已经丢失（过期、被撤销或网络分区），锁随时可能被别人获得
+		go func() {
+			for range keepAliveCh {
+			}
+			if keepAliveCtx.Err() == nil {
+				loss.signal()
+			}
+		}()
+	}
+	if l.opts.fifo {
+		return l.lockFIFO(ctx, start, leaseResp.ID, cancel, loss)
+	}
+
+	for {
+		holder, _ := json.Marshal(HolderInfo{Identity: l.opts.identity, AcquiredAt: time.Now()})
+		txnResp, err := l.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(l.key), "=", 0)).
+			Then(clientv3.OpPut(l.key, string(holder), clientv3.WithLease(leaseResp.ID))).
+			Commit()
+		if err != nil {
+			l.abort(cancel, leaseResp.ID)
+			return 0, err
+		}
+		if txnResp.Succeeded {
+			// key 在本次事务中创建，CreateRevision 就是事务的 revision
+			l.acquired(l.key, leaseResp.ID, cancel, loss, txnResp.Header.Revision, start)
+			return uint64(txnResp.Header.Revision), nil
+		}
+		// 锁被其他人持有，从事务之后的 revision 开始监听，避免错过事务和 Watch 之间发生的删除
+		if err := l.waitDelete(ctx, l.key, txnResp.Header.Revision+1); err != nil {
+			l.abort(cancel, leaseResp.ID)
+			return 0, err
+		}
+	}
+}
+
+// lockFIFO 写入自己的排队 key，等到它成为前缀下 CreateRevision 最小的 key
+// 排队 key 的 CreateRevision 同样严格递增，直接作为 fencing token
+func (l *EtcdDistributedLock) lockFIFO(ctx context.Context, start time.Time, leaseID clientv3.LeaseID, cancel context.CancelFunc, loss *lockLoss) (uint64, error) {
+	myKey := fmt.Sprintf("%s/%x", l.key, leaseID)
+	holder, _ := json.Marshal(HolderInfo{Identity: l.opts.identity, AcquiredAt: time.Now()})
+	putResp, err := l.client.Put(ctx, myKey, string(holder), clientv3.WithLease(leaseID))
+	if err != nil {
+		l.abort(cancel, leaseID)
+		return 0, err
+	}
+	myRev := putResp.Header.Revision
+	for {
+		// 排在自己前面的最后一个 key，没有时说明自己排在最前面
+		opts := append(clientv3.WithLastCreate(), clientv3.WithMaxCreateRev(myRev-1))
+		resp, err := l.client.Get(ctx, l.key+"/", opts...)
+		if err != nil {
+			l.abort(cancel, leaseID)
+			return 0, err
+		}
+		if len(resp.Kvs) == 0 {
+			// 持有期间从当前 revision 之后监听自己的 key
+			l.acquired(myKey, leaseID, cancel, loss, resp.Header.Revision, start)
+			return uint64(myRev), nil
+		}
+		// 前一个 key 删除后再检查一次：它可能只是排队中途放弃，前面还有别人
+		if err := l.waitDelete(ctx, string(resp.Kvs[0].Key), resp.Header.Revision+1); err != nil {
+			l.abort(cancel, leaseID)
+			return 0, err
+		}
+	}
+}
+
+// acquired 记录本次持有的 key 和租约，开始监听过期，rev 是确认获得锁时的 revision
+func (l *EtcdDistributedLock) acquired(key string, leaseID clientv3.LeaseID, cancel context.CancelFunc, loss *lockLoss, rev int64, start time.Time) {
+	l.heldKey = key
+	l.leaseID = leaseID
+	l.cancelKeepAlive = cancel
+	l.lost = loss
+	l.watchExpiry(rev + 1)
+	if l.opts.heatmap != nil {
+		l.opts.heatmap.record(l.key, time.Since(start))
+	}
+}
+
+// LockOrInspect 在 timeout 内尝试获得锁，超时后读取并返回当前持有者的信息
+// 获得锁时 acquired 为 true；超时时 acquired 为 false、err 为 nil，
+// 若读取时锁恰好已被释放，holder 为零值。ctx 本身结束时返回 ctx 的错误
+func (l *EtcdDistributedLock) LockOrInspect(ctx context.Context, timeout time.Duration) (acquired bool, holder HolderInfo, err error) {
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err = l.Lock(lockCtx)
+	if err == nil {
+		return true, HolderInfo{}, nil
+	}
+	if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+		return false, HolderInfo{}, err
+	}
+	var resp *clientv3.GetResponse
+	if l.opts.fifo {
+		// FIFO 模式下持有者是排在最前面的 key
+		resp, err = l.client.Get(ctx, l.key+"/", clientv3.WithFirstCreate()...)
+	} else {
+		resp, err = l.client.Get(ctx, l.key)
+	}
+	if err != nil {
+		return false, HolderInfo{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return false, HolderInfo{}, nil
+	}
+	return false, decodeHolder(resp.Kvs[0]), nil
+}
+
+// waitDelete 从 rev 开始监听 key，直到它被删除
+// rev 已被压缩时删除事件可能已经丢失，重新读取 key：key 不存在或是在 rev 之后重新创建的，
+// 说明原来的 key 已被删除；否则从读取时的 revision 之后继续监听
+func (l *EtcdDistributedLock) waitDelete(ctx context.Context, key string, rev int64) error {
+	for {
+		compacted := false
+		watchCtx, cancel := context.WithCancel(ctx)
+		for resp := range l.watcher.Watch(watchCtx, key, clientv3.WithRev(rev)) {
+			if resp.CompactRevision != 0 {
+				compacted = true
+				break
+			}
+			if err := resp.Err(); err != nil {
+				cancel()
+				return err
+			}
+			for _, ev := range resp.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					cancel()
+					return nil
+				}
+			}
+		}
+		cancel()
+		if !compacted {
+			// Watch 通道只会因为 ctx 结束而关闭
+			return ctx.Err()
+		}
+		resp, err := l.client.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 || resp.Kvs[0].CreateRevision >= rev {
+			return nil
+		}
+		rev = resp.Header.Revision + 1
+	}
+}
+
+// watchExpiry 在持有锁期间监听锁 key，key 被删除（租约过期）时关闭 expired
+func (l *EtcdDistributedLock) watchExpiry(rev int64) {
+	expired := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	l.expired, l.cancelWatch = expired, cancel
+	key, loss := l.heldKey, l.lost
+	go func() {
+		if err := l.waitDelete(ctx, key, rev); err == nil {
+			close(expired)
+			loss.signal()
+		}
+	}()
+}
+
+// Expired 返回一个在本次持有期间锁被动丢失（租约过期、key 被删除）时关闭的通道
+// 必须在 Lock 成功之后调用；Unlock 主动释放锁不会关闭它
+func (l *EtcdDistributedLock) Expired() <-chan struct{} {
+	return l.expired
+}
+
+// Done 返回一个在本次持有期间续约中断或锁 key 被删除时关闭的通道，必须在 Lock 成功之后调用
+// 续约通道关闭说明租约已经丢失，持有者应当立即中止临界区，避免与新的持有者同时工作；
+// 相比 Expired，它不必等到 key 的删除事件到达。Unlock 主动释放锁不会关闭它
+func (l *EtcdDistributedLock) Done() <-chan struct{} {
+	return l.lost.ch
+}
+
+// abort 在获取锁失败时停止续约并撤销租约
+func (l *EtcdDistributedLock) abort(cancel context.CancelFunc, leaseID clientv3.LeaseID) {
+	cancel()
+	l.client.Revoke(context.Background(), leaseID)
+}
+
+// Unlock 删除锁 key 并撤销租约，等待者会收到删除事件并重新竞争
+func (l *EtcdDistributedLock) Unlock(ctx context.Context) error {
+	if l.cancelKeepAlive == nil {
+		return errors.New("lock is not held")
+	}
+	// 先停止过期监听，主动删除 key 不应被当作过期
+	l.cancelWatch()
+	l.cancelKeepAlive()
+	l.cancelKeepAlive = nil
+	// 只删除仍绑定在自己租约上的 key，租约过期后锁可能已经被别人获得
+	_, err := l.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(l.heldKey), "=", l.leaseID)).
+		Then(clientv3.OpDelete(l.heldKey)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	_, err = l.client.Revoke(ctx, l.leaseID)
+	return err
+}
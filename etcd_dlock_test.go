@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -84,147 +87,403 @@ func TestDistributedLock(t *testing.T) {
 }
 
 // TestDistributedLockNormal 演示如何使用 etcd 原生 API 手动实现分布式锁（底层实现方式）
-// 优点：完全掌控细节，理解分布式锁的实现原理
-// 缺点：代码复杂，需要手动处理租约、事务、监听等逻辑
+// 实现见 etcd_dlock.go 中的 EtcdDistributedLock：
+//   - 申请租约并启动自动续约，进程崩溃后租约过期，锁自动释放
+//   - 事务判断 CreateRevision == 0（key 不存在）时写入绑定租约的 key，获得锁
+//   - 否则 Watch 锁 key 的删除事件，删除后回到事务重新竞争
+//   - Unlock 删除 key 并撤销租约，唤醒等待者
 func TestDistributedLockNormal(t *testing.T) {
-	// ==================== 步骤 1: 创建 etcd 客户端 ====================
-	// 与 TestDistributedLock 相同，创建 etcd v3 客户端
+	client := newTestEtcdClient(t)
+	ctx := context.Background()
+
+	lock, err := NewEtcdDistributedLock(client, "my-distributed-lock-normal", 5)
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if _, err := lock.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	t.Logf("Lock acquired")
+
+	// 执行临界区代码，Sleep 模拟耗时操作
+	time.Sleep(100 * time.Millisecond)
+
+	if err := lock.Unlock(ctx); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	t.Logf("Lock released")
+}
+
+// TestDistributedLockContention 两个 goroutine 争用同一把锁，临界区不能交叠
+func TestDistributedLockContention(t *testing.T) {
+	client := newTestEtcdClient(t)
+	var (
+		inside  int32
+		overlap int32
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 2; i++ {
+		lock, err := NewEtcdDistributedLock(client, "contended", 5, WithLockPrefix("/locks/test"))
+		if err != nil {
+			t.Fatalf("Failed to create lock: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < 3; round++ {
+				if _, err := lock.Lock(context.Background()); err != nil {
+					t.Errorf("Failed to acquire lock: %v", err)
+					return
+				}
+				if atomic.AddInt32(&inside, 1) != 1 {
+					atomic.StoreInt32(&overlap, 1)
+				}
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&inside, -1)
+				if err := lock.Unlock(context.Background()); err != nil {
+					t.Errorf("Failed to release lock: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if overlap != 0 {
+		t.Errorf("Two holders were inside the critical section at the same time")
+	}
+}
+
+func newTestEtcdClient(t *testing.T) *clientv3.Client {
+	t.Helper()
 	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"}, // etcd 服务地址
-		DialTimeout: 3 * time.Second,            // 连接超时时间
+		Endpoints:   []string{"localhost:2379"},
+		DialTimeout: 3 * time.Second,
 	})
 	if err != nil {
 		t.Fatalf("Failed to connect to etcd: %v", err)
 	}
-	defer client.Close() // 函数结束时关闭客户端
+	t.Cleanup(func() { client.Close() })
+	return client
+}
 
-	// 创建一个背景上下文，用于后续所有 etcd 操作
-	// context.Background() 返回一个永不超时、永不取消的根上下文
-	ctx := context.Background()
+func TestDistributedLockPrefix(t *testing.T) {
+	client := newTestEtcdClient(t)
+	orderLock, err := NewEtcdDistributedLock(client, "cleanup", 5, WithLockPrefix("/locks/order"))
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	userLock, err := NewEtcdDistributedLock(client, "cleanup", 5, WithLockPrefix("/locks/user/"))
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if orderLock.Key() != "/locks/order/cleanup" || userLock.Key() != "/locks/user/cleanup" {
+		t.Fatalf("Unexpected lock keys %q and %q", orderLock.Key(), userLock.Key())
+	}
 
-	// ==================== 步骤 2: 创建租约并启动自动续约 ====================
-	// 租约（Lease）是 etcd 的核心机制，用于实现键的自动过期
-	// client.Grant() 向 etcd 申请一个新租约
-	leaseResp, err := client.Grant(
-		ctx, // 上下文
-		5,   // TTL（Time To Live）= 5秒，租约过期时间
-	)
+	// 同名但前缀不同的锁互不争用，第二把锁应立即获得
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := orderLock.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire order lock: %v", err)
+	}
+	defer orderLock.Unlock(context.Background())
+	if _, err := userLock.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire user lock while order lock is held: %v", err)
+	}
+	defer userLock.Unlock(context.Background())
+}
+
+func TestDistributedLockInvalidKey(t *testing.T) {
+	client := newTestEtcdClient(t)
+	if _, err := NewEtcdDistributedLock(client, "/", 5, WithLockPrefix("/locks/order")); err == nil {
+		t.Errorf("Expected error for empty lock name")
+	}
+	if _, err := NewEtcdDistributedLock(client, strings.Repeat("k", maxLockKeyBytes), 5, WithLockPrefix("/locks")); err == nil {
+		t.Errorf("Expected error for oversized lock key")
+	}
+}
+
+func TestDistributedLockNoAutoRenewExpires(t *testing.T) {
+	client := newTestEtcdClient(t)
+	lock, err := NewEtcdDistributedLock(client, "no-renew", 2, WithLockPrefix("/locks/test"), WithNoAutoRenew())
 	if err != nil {
-		t.Fatalf("Failed to create lease: %v", err)
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if _, err := lock.Lock(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	start := time.Now()
+	select {
+	case <-lock.Expired():
+		t.Logf("Lock expired after %v", time.Since(start))
+	case <-time.After(6 * time.Second):
+		t.Fatalf("Lock without auto renew did not expire")
+	}
+	// 过期后其他竞争者可以立即获得锁
+	other, _ := NewEtcdDistributedLock(client, "no-renew", 5, WithLockPrefix("/locks/test"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := other.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire expired lock: %v", err)
 	}
-	// leaseResp.ID 是 etcd 分配的租约 ID，后续操作需要用到
+	other.Unlock(context.Background())
+}
 
-	// 启动租约自动续约（KeepAlive）
-	// 为什么需要续约？
-	//   - 租约 TTL 只有 5 秒，如果不续约，5秒后租约过期，锁会自动释放
-	//   - KeepAlive 会每隔 TTL/3 (约1.6秒) 发送一次心跳，保持租约活跃
-	// client.KeepAlive() 启动后台续约，返回一个只读通道
-	keepAliveChan, err := client.KeepAlive(ctx, leaseResp.ID)
+func TestDistributedLockOrInspect(t *testing.T) {
+	client := newTestEtcdClient(t)
+	holder, err := NewEtcdDistributedLock(client, "inspect", 5, WithLockPrefix("/locks/test"), WithLockIdentity("worker-a"))
 	if err != nil {
-		t.Fatalf("Failed to keep lease alive: %v", err)
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	before := time.Now()
+	if _, err := holder.Lock(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
 	}
+	defer holder.Unlock(context.Background())
 
-	// 启动一个 goroutine（后台线程）消费续约响应
-	// 为什么需要消费？
-	//   - 如果不从 keepAliveChan 读取数据，通道会满，导致续约阻塞
-	//   - 即使不处理响应，也必须读取，保持通道流畅
-	go func() {
-		// range 循环持续读取通道中的续约响应
-		// 每次续约成功，etcd 会发送一个 LeaseKeepAliveResponse
-		for ka := range keepAliveChan {
-			// ka.ID: 租约 ID
-			// ka.TTL: 剩余生存时间（每次续约后重置为 5 秒）
-			t.Logf("Received lease keep-alive response: %v", ka.ID)
-		}
-		// 通道关闭时（租约撤销或客户端关闭），循环自动退出
-	}()
+	waiter, err := NewEtcdDistributedLock(client, "inspect", 5, WithLockPrefix("/locks/test"), WithLockIdentity("worker-b"))
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	acquired, info, err := waiter.LockOrInspect(context.Background(), 300*time.Millisecond)
+	if err != nil {
+		t.Fatalf("LockOrInspect failed: %v", err)
+	}
+	if acquired {
+		t.Fatalf("Expected lock to be held by worker-a")
+	}
+	if info.Identity != "worker-a" {
+		t.Errorf("Identity = %q, want worker-a", info.Identity)
+	}
+	if info.AcquiredAt.Before(before.Add(-time.Second)) || info.AcquiredAt.After(time.Now()) {
+		t.Errorf("Unexpected AcquiredAt %v", info.AcquiredAt)
+	}
+	if info.FencingToken == 0 {
+		t.Errorf("Expected positive fencing token, got %d", info.FencingToken)
+	}
+}
 
-	// ==================== 步骤 3: 尝试获取锁（使用事务保证原子性）====================
-getlock: // 定义一个标签，用于 goto 跳转重试
-	// 锁的唯一标识符（所有竞争者使用相同的 key）
-	lockKey := "my-distributed-lock-normal"
-
-	// 创建一个事务（Transaction）
-	// 事务保证操作的原子性：要么全部成功，要么全部失败
-	txn := client.Txn(ctx)
-
-	// 执行事务：If-Then-Else 结构
-	txnResp, err := txn.
-		// If 条件判断：检查 lockKey 是否存在
-		If(
-			// clientv3.Compare 创建一个比较条件
-			// CreateRevision: 键的创建版本号（首次创建时分配，删除后重建会改变）
-			// "=", 0: 等于 0 表示键不存在（从未创建或已被删除）
-			clientv3.Compare(clientv3.CreateRevision(lockKey), "=", 0),
-		).
-		// Then 分支：如果键不存在（条件为真），执行 Put 操作
-		Then(
-			// clientv3.OpPut 创建键值对
-			clientv3.OpPut(
-				lockKey,  // 键名
-				"locked", // 键值（可以存储锁的持有者信息，如机器 IP）
-				// clientv3.WithLease 将键绑定到租约
-				// 绑定后，租约过期时键会自动删除（实现锁的自动释放）
-				clientv3.WithLease(leaseResp.ID),
-			),
-		).
-		// Commit 提交事务并执行
-		Commit()
-
-	// 检查事务是否执行成功（网络错误、etcd 故障等）
+func TestDistributedLockFencingToken(t *testing.T) {
+	client := newTestEtcdClient(t)
+	lock, err := NewEtcdDistributedLock(client, "fencing", 5, WithLockPrefix("/locks/test"))
 	if err != nil {
-		t.Fatalf("Failed to acquire lock: %v", err)
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	var last uint64
+	for i := 0; i < 3; i++ {
+		token, err := lock.Lock(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to acquire lock: %v", err)
+		}
+		if token <= last {
+			t.Errorf("Fencing token %d not greater than previous %d", token, last)
+		}
+		resp, err := client.Get(context.Background(), lock.Key())
+		if err != nil || len(resp.Kvs) != 1 {
+			t.Fatalf("Failed to read lock key: %v", err)
+		}
+		if uint64(resp.Kvs[0].CreateRevision) != token {
+			t.Errorf("Fencing token %d != CreateRevision %d", token, resp.Kvs[0].CreateRevision)
+		}
+		last = token
+		if err := lock.Unlock(context.Background()); err != nil {
+			t.Fatalf("Failed to release lock: %v", err)
+		}
 	}
+}
 
-	// ==================== 步骤 4: 判断是否获取锁成功 ====================
-	// txnResp.Succeeded 表示 If 条件是否为真
-	if txnResp.Succeeded {
-		// 条件为真 -> 键不存在 -> Put 操作执行成功 -> 获取锁成功
-		t.Logf("Lock acquired")
-	} else {
-		// 条件为假 -> 键已存在 -> 锁被其他进程持有 -> 需要等待
-		t.Logf("Lock is already held, waiting...")
-
-		// ==================== 步骤 5: 监听锁的释放（Watch 机制）====================
-		// client.Watch() 监听指定 key 的变化事件
-		// 返回一个只读通道，当 key 发生变化时会收到通知
-		watchChan := client.Watch(ctx, lockKey)
-
-		// 循环读取监听事件
-		for w := range watchChan {
-			// w.Events: 事件列表（可能包含多个事件）
-			for _, ev := range w.Events {
-				// ev.Type: 事件类型
-				// clientv3.EventTypeDelete: 键被删除事件
-				if ev.Type == clientv3.EventTypeDelete {
-					// 锁被释放（键被删除），打印日志
-					t.Logf("Lock released, try to acquire again")
-					// goto getlock: 跳转到 getlock 标签，重新尝试获取锁
-					// 注意：此时租约仍然有效，续约仍在后台运行
-					goto getlock
-				}
-				// 如果是其他事件类型（如 Put），继续等待
+// TestDistributedLockFIFO 三个竞争者依次请求锁，获得锁的顺序与请求顺序一致
+func TestDistributedLockFIFO(t *testing.T) {
+	client := newTestEtcdClient(t)
+	const prefix = "/locks/test"
+	names := []string{"first", "second", "third"}
+	locks := make([]*EtcdDistributedLock, len(names))
+	for i, name := range names {
+		lock, err := NewEtcdDistributedLock(client, "fifo", 5, WithLockPrefix(prefix), WithFIFO(), WithLockIdentity(name))
+		if err != nil {
+			t.Fatalf("Failed to create lock: %v", err)
+		}
+		locks[i] = lock
+	}
+	// waitQueued 等到前缀下有 n 个排队 key，保证请求顺序确定
+	waitQueued := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			resp, err := client.Get(context.Background(), locks[0].Key()+"/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+			if err == nil && resp.Count == n {
+				return
 			}
+			time.Sleep(10 * time.Millisecond)
 		}
-		// 如果 Watch 通道关闭（etcd 连接断开、上下文取消），循环退出
+		t.Fatalf("Timed out waiting for %d queued contenders", n)
 	}
 
-	// ==================== 步骤 6: 执行临界区代码 ====================
-	// 获取锁成功后，执行业务逻辑
-	// Sleep 3 秒模拟耗时操作
-	time.Sleep(3 * time.Second)
+	var (
+		mu    sync.Mutex
+		order []string
+		last  uint64
+		wg    sync.WaitGroup
+	)
+	for i, lock := range locks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := lock.Lock(context.Background())
+			if err != nil {
+				t.Errorf("Failed to acquire lock: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, names[i])
+			if token <= last {
+				t.Errorf("Fencing token %d not greater than previous %d", token, last)
+			}
+			last = token
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			if err := lock.Unlock(context.Background()); err != nil {
+				t.Errorf("Failed to release lock: %v", err)
+			}
+		}()
+		waitQueued(int64(i + 1))
+	}
+	wg.Wait()
+	if strings.Join(order, ",") != strings.Join(names, ",") {
+		t.Errorf("Acquisition order %v, want %v", order, names)
+	}
+}
 
-	// ==================== 步骤 7: 释放锁 ====================
-	// 删除锁键，通知其他等待者
-	_, err = client.Delete(ctx, lockKey)
+func TestDistributedLockDone(t *testing.T) {
+	client := newTestEtcdClient(t)
+	lock, err := NewEtcdDistributedLock(client, "done", 5, WithLockPrefix("/locks/test"))
 	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if _, err := lock.Lock(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	select {
+	case <-lock.Done():
+		t.Fatalf("Done closed while the lock is held")
+	case <-time.After(200 * time.Millisecond):
+	}
+	// 撤销租约，续约通道随之关闭
+	if _, err := client.Revoke(context.Background(), lock.leaseID); err != nil {
+		t.Fatalf("Failed to revoke lock lease: %v", err)
+	}
+	select {
+	case <-lock.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Done not closed after keepalive died")
+	}
+	lock.Unlock(context.Background())
+
+	// 主动 Unlock 不关闭 Done
+	if _, err := lock.Lock(context.Background()); err != nil {
+		t.Fatalf("Failed to reacquire lock: %v", err)
+	}
+	done := lock.Done()
+	if err := lock.Unlock(context.Background()); err != nil {
 		t.Fatalf("Failed to release lock: %v", err)
 	}
-	// 删除成功后，监听该 key 的其他进程会收到 Delete 事件，触发重试
-	t.Logf("Lock released")
+	select {
+	case <-done:
+		t.Errorf("Done closed by Unlock")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
 
-	// 函数结束时：
-	//   1. defer client.Close() 关闭客户端
-	//   2. 租约的续约 goroutine 随着客户端关闭而停止
-	//   3. 租约最终过期（如果没有手动撤销）
+// compactingWatcher 包装真实的 Watcher，第一次 Watch 等 release 关闭后返回压缩错误，之后的 Watch 正常转发
+type compactingWatcher struct {
+	clientv3.Watcher
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (w *compactingWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	if w.calls.Add(1) > 1 {
+		return w.Watcher.Watch(ctx, key, opts...)
+	}
+	out := make(chan clientv3.WatchResponse, 1)
+	go func() {
+		defer close(out)
+		select {
+		case <-w.release:
+			out <- clientv3.WatchResponse{CompactRevision: clientv3.OpGet(key, opts...).Rev()}
+		case <-ctx.Done():
+		}
+	}()
+	return out
+}
+
+// TestDistributedLockWaitCompacted 等待锁时 Watch 遇到压缩错误，重新读取锁 key 后继续等待，不会失败或错过释放
+func TestDistributedLockWaitCompacted(t *testing.T) {
+	client := newTestEtcdClient(t)
+	newLock := func() *EtcdDistributedLock {
+		lock, err := NewEtcdDistributedLock(client, "compacted", 5, WithLockPrefix("/locks/test"))
+		if err != nil {
+			t.Fatalf("Failed to create lock: %v", err)
+		}
+		return lock
+	}
+	waitAcquired := func(acquired chan error) {
+		t.Helper()
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatalf("Waiter failed: %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Waiter not woken after the lock was released")
+		}
+	}
+
+	// 锁仍被持有：压缩后重新监听，释放时被唤醒
+	holder, waiter := newLock(), newLock()
+	if _, err := holder.Lock(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	w := &compactingWatcher{Watcher: client, release: make(chan struct{})}
+	waiter.watcher = w
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := waiter.Lock(context.Background())
+		acquired <- err
+	}()
+	close(w.release)
+	select {
+	case err := <-acquired:
+		t.Fatalf("Waiter returned while the lock is held: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if n := w.calls.Load(); n != 2 {
+		t.Fatalf("Expected the watch to be re-established after compaction, got %d watches", n)
+	}
+	if err := holder.Unlock(context.Background()); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	waitAcquired(acquired)
+	waiter.Unlock(context.Background())
+
+	// 删除事件落在被压缩的区间内：重新读取发现 key 已经不在，直接重试获得锁
+	holder, waiter = newLock(), newLock()
+	if _, err := holder.Lock(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	w = &compactingWatcher{Watcher: client, release: make(chan struct{})}
+	waiter.watcher = w
+	go func() {
+		_, err := waiter.Lock(context.Background())
+		acquired <- err
+	}()
+	for w.calls.Load() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := holder.Unlock(context.Background()); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	close(w.release)
+	waitAcquired(acquired)
+	waiter.Unlock(context.Background())
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// defaultElectionPrefix 是选举 key 的默认前缀，可以用 WithLockPrefix 覆盖
+const defaultElectionPrefix = "/election"
+
+// EtcdElection 是基于 EtcdDistributedLock 的 leader 选举，用于定时任务、调度器等只能有一个实例运行的场景
+// 选举使用 FIFO 模式的锁：候选者按 Campaign 的先后排队，持有锁的就是 leader，
+// 租约丢失（过期、被撤销、长时间断网）时 leader 身份随之失去，Leadership 会发出 false
+// 同一个 EtcdElection 的 Campaign 和 Resign 不能并发调用，多个候选者各自创建自己的 EtcdElection
+type EtcdElection struct {
+	lock *EtcdDistributedLock
+
+	// opMu 串行化 Campaign 和 Resign
+	opMu sync.Mutex
+	// 当前任期的丢锁监听，Resign 时停止
+	stopWatch context.CancelFunc
+
+	mu     sync.Mutex
+	leader bool
+	// term 在每次当选时递增，旧任期的丢锁监听不会影响新任期
+	term       uint64
+	leadership chan bool
+}
+
+// NewEtcdElection 创建名为 name 的选举，ttl 为候选者租约的秒数，leader 崩溃后最多 ttl 秒由下一个候选者接任
+// opts 与 EtcdDistributedLock 相同，默认前缀为 /election，WithLockIdentity 设置的标识就是 leader 的标识
+func NewEtcdElection(client *clientv3.Client, name string, ttl int64, opts ...LockOption) (*EtcdElection, error) {
+	opts = append([]LockOption{WithLockPrefix(defaultElectionPrefix)}, opts...)
+	lock, err := NewEtcdDistributedLock(client, name, ttl, append(opts, WithFIFO())...)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdElection{lock: lock, leadership: make(chan bool, 1)}, nil
+}
+
+// Campaign 阻塞直到成为 leader 或 ctx 结束，已经是 leader 时立即返回
+func (e *EtcdElection) Campaign(ctx context.Context) error {
+	e.opMu.Lock()
+	defer e.opMu.Unlock()
+	if e.IsLeader() {
+		return nil
+	}
+	// 上一个任期因租约丢失结束时，清理残留的监听和排队 key
+	e.release(ctx)
+	if _, err := e.lock.Lock(ctx); err != nil {
+		return err
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	e.stopWatch = cancel
+	lost := e.lock.Done()
+	term := e.setLeader(true)
+	go func() {
+		select {
+		case <-lost:
+			e.loseTerm(term)
+		case <-watchCtx.Done():
+		}
+	}()
+	return nil
+}
+
+// Resign 放弃 leader 身份，排在后面的候选者随即当选；不是 leader 时什么也不做
+func (e *EtcdElection) Resign(ctx context.Context) error {
+	e.opMu.Lock()
+	defer e.opMu.Unlock()
+	wasLeader := e.IsLeader()
+	err := e.release(ctx)
+	e.setLeader(false)
+	if !wasLeader {
+		// 租约已经丢失，撤销失败不是调用方的问题
+		return nil
+	}
+	return err
+}
+
+// release 停止当前任期的丢锁监听并释放锁，没有任期时什么也不做，调用方持有 opMu
+func (e *EtcdElection) release(ctx context.Context) error {
+	if e.stopWatch == nil {
+		return nil
+	}
+	e.stopWatch()
+	e.stopWatch = nil
+	return e.lock.Unlock(ctx)
+}
+
+// IsLeader 判断当前是否是 leader
+func (e *EtcdElection) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Leadership 返回 leader 身份变化的通知：当选时发出 true，Resign 或租约丢失时发出 false
+// 通道只保留最新的状态，消费慢时中间的变化会被合并；同一个 EtcdElection 的多次调用返回同一个通道
+func (e *EtcdElection) Leadership() <-chan bool {
+	return e.leadership
+}
+
+// setLeader 更新 leader 状态，状态变化时发出通知；当选时开始新任期并返回任期编号
+func (e *EtcdElection) setLeader(leader bool) uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.setLeaderLocked(leader)
+}
+
+func (e *EtcdElection) setLeaderLocked(leader bool) uint64 {
+	if leader == e.leader {
+		return e.term
+	}
+	e.leader = leader
+	if leader {
+		e.term++
+	}
+	select {
+	case <-e.leadership:
+	default:
+	}
+	e.leadership <- leader
+	return e.term
+}
+
+// loseTerm 在任期 term 的租约丢失时放弃 leader 身份，任期已经结束时什么也不做
+func (e *EtcdElection) loseTerm(term uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader && e.term == term {
+		e.setLeaderLocked(false)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func receiveLeadership(t *testing.T, e *EtcdElection, want bool) {
+	t.Helper()
+	select {
+	case got := <-e.Leadership():
+		if got != want {
+			t.Fatalf("Leadership sent %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for leadership %v", want)
+	}
+}
+
+// TestEtcdElection 两个候选者竞选，任何时刻只有一个是 leader，leader 放弃后另一个接任
+func TestEtcdElection(t *testing.T) {
+	const name = "election_test/scheduler"
+	first, err := NewEtcdElection(newTestEtcdClient(t), name, 5, WithLockIdentity("first"))
+	if err != nil {
+		t.Fatalf("Failed to create election: %v", err)
+	}
+	second, err := NewEtcdElection(newTestEtcdClient(t), name, 5, WithLockIdentity("second"))
+	if err != nil {
+		t.Fatalf("Failed to create election: %v", err)
+	}
+	if err := first.Campaign(context.Background()); err != nil {
+		t.Fatalf("First campaign failed: %v", err)
+	}
+	receiveLeadership(t, first, true)
+	if !first.IsLeader() {
+		t.Fatalf("Expected first candidate to be leader")
+	}
+	// 已经是 leader 时 Campaign 立即返回
+	if err := first.Campaign(context.Background()); err != nil {
+		t.Fatalf("Repeated campaign failed: %v", err)
+	}
+
+	elected := make(chan error, 1)
+	go func() { elected <- second.Campaign(context.Background()) }()
+	select {
+	case err := <-elected:
+		t.Fatalf("Second candidate elected while first is leader: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if second.IsLeader() {
+		t.Fatalf("Both candidates report leadership")
+	}
+
+	if err := first.Resign(context.Background()); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	receiveLeadership(t, first, false)
+	select {
+	case err := <-elected:
+		if err != nil {
+			t.Fatalf("Second campaign failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Second candidate not elected after resign")
+	}
+	receiveLeadership(t, second, true)
+	if first.IsLeader() || !second.IsLeader() {
+		t.Errorf("Expected only the second candidate to be leader, got first=%v second=%v", first.IsLeader(), second.IsLeader())
+	}
+	if err := second.Resign(context.Background()); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	// 不是 leader 时 Resign 什么也不做
+	if err := first.Resign(context.Background()); err != nil {
+		t.Errorf("Resign without leadership failed: %v", err)
+	}
+}
+
+// TestEtcdElectionLeaseExpiry leader 的租约过期后 Leadership 发出 false，之后可以重新竞选
+func TestEtcdElectionLeaseExpiry(t *testing.T) {
+	e, err := NewEtcdElection(newTestEtcdClient(t), "election_test/expiring", 1, WithNoAutoRenew())
+	if err != nil {
+		t.Fatalf("Failed to create election: %v", err)
+	}
+	if err := e.Campaign(context.Background()); err != nil {
+		t.Fatalf("Campaign failed: %v", err)
+	}
+	receiveLeadership(t, e, true)
+	receiveLeadership(t, e, false)
+	if e.IsLeader() {
+		t.Errorf("Expected leadership to be lost after lease expiry")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Campaign(ctx); err != nil {
+		t.Fatalf("Campaign after expiry failed: %v", err)
+	}
+	receiveLeadership(t, e, true)
+	e.Resign(context.Background())
+}
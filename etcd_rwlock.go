@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdRWLock 是基于 etcd 的分布式读写锁，同一时间允许多个读者或一个写者
+//
+// 读者在 {key}/readers/ 下写入绑定自己租约的 key（{key}/readers/{leaseID}），写者写入 {key}/writer。
+// 读者的事务在 writer 不存在时写入自己的 key，写者的事务在 writer 和全部读者 key 都不存在时写入 writer，
+// 判断和写入在同一个事务中完成，不会出现读者和写者同时持有；条件不满足时 Watch 等待相应的 key 被删除后重试。
+// 持有者崩溃时租约过期，key 自动删除。读者持续到来时写者可能一直等不到全部读者释放
+// 一个 EtcdRWLock 同一时间只持有一把锁（读或写），多个并发的持有者各自创建自己的 EtcdRWLock
+type EtcdRWLock struct {
+	client *clientv3.Client
+	key    string
+	ttl    int64
+
+	heldKey         string
+	leaseID         clientv3.LeaseID
+	cancelKeepAlive context.CancelFunc
+}
+
+// NewEtcdRWLock 创建 key 下的读写锁，ttl 为持有者租约的秒数，持有者崩溃后最多 ttl 秒锁被释放
+func NewEtcdRWLock(client *clientv3.Client, key string, ttl int64) (*EtcdRWLock, error) {
+	key = strings.TrimRight(key, "/")
+	if key == "" {
+		return nil, errors.New("rwlock key cannot be empty")
+	}
+	if len(key) > maxLockKeyBytes {
+		return nil, fmt.Errorf("rwlock key too long: %d bytes, max %d", len(key), maxLockKeyBytes)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("rwlock ttl must be positive")
+	}
+	return &EtcdRWLock{client: client, key: key, ttl: ttl}, nil
+}
+
+// Key 返回读写锁在 etcd 中的 key，读者和写者的 key 都在它下面
+func (l *EtcdRWLock) Key() string {
+	return l.key
+}
+
+func (l *EtcdRWLock) writerKey() string {
+	return l.key + "/writer"
+}
+
+func (l *EtcdRWLock) readersPrefix() string {
+	return l.key + "/readers/"
+}
+
+// RLock 阻塞直到获得读锁或 ctx 结束，没有写者时立即获得
+func (l *EtcdRWLock) RLock(ctx context.Context) error {
+	return l.acquire(ctx, func(leaseID clientv3.LeaseID, holder string) (string, bool, error) {
+		myKey := fmt.Sprintf("%s%x", l.readersPrefix(), leaseID)
+		resp, err := l.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(l.writerKey()), "=", 0)).
+			Then(clientv3.OpPut(myKey, holder, clientv3.WithLease(leaseID))).
+			Commit()
+		if err != nil {
+			return "", false, err
+		}
+		if resp.Succeeded {
+			return myKey, true, nil
+		}
+		// 写者持有锁，等它释放
+		return "", false, l.waitRelease(ctx, l.writerKey(), resp.Header.Revision+1)
+	})
+}
+
+// Lock 阻塞直到获得写锁或 ctx 结束，需要等当前的写者和全部读者都释放
+func (l *EtcdRWLock) Lock(ctx context.Context) error {
+	return l.acquire(ctx, func(leaseID clientv3.LeaseID, holder string) (string, bool, error) {
+		resp, err := l.client.Txn(ctx).
+			If(
+				clientv3.Compare(clientv3.CreateRevision(l.writerKey()), "=", 0),
+				// 范围比较：前缀下没有任何 key 时成立
+				clientv3.Compare(clientv3.CreateRevision(l.readersPrefix()), "=", 0).WithPrefix(),
+			).
+			Then(clientv3.OpPut(l.writerKey(), holder, clientv3.WithLease(leaseID))).
+			Commit()
+		if err != nil {
+			return "", false, err
+		}
+		if resp.Succeeded {
+			return l.writerKey(), true, nil
+		}
+		// 写者或读者持有锁，任意一个释放后重新检查
+		return "", false, l.waitRelease(ctx, l.key+"/", resp.Header.Revision+1, clientv3.WithPrefix())
+	})
+}
+
+// acquire 申请租约后反复调用 try，直到 try 写入了自己的 key 或返回错误；失败时撤销租约
+// try 返回写入的 key、是否获得锁，未获得时 try 负责等待到可以重试
+func (l *EtcdRWLock) acquire(ctx context.Context, try func(leaseID clientv3.LeaseID, holder string) (key string, ok bool, err error)) error {
+	if l.cancelKeepAlive != nil {
+		return errors.New("rwlock already held")
+	}
+	leaseID, cancel, err := grantWithKeepAlive(ctx, l.client, l.ttl)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s-%d", host, os.Getpid())
+	for {
+		key, ok, err := try(leaseID, holder)
+		if err != nil {
+			cancel()
+			l.client.Revoke(context.Background(), leaseID)
+			return err
+		}
+		if ok {
+			l.heldKey, l.leaseID, l.cancelKeepAlive = key, leaseID, cancel
+			return nil
+		}
+	}
+}
+
+// waitRelease 从 rev 开始监听 key（opts 可以加上 WithPrefix），直到有 key 被删除
+// revision 已被压缩时删除事件可能已经丢失，直接返回由调用方重新检查
+func (l *EtcdRWLock) waitRelease(ctx context.Context, key string, rev int64, opts ...clientv3.OpOption) error {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts = append(opts, clientv3.WithRev(rev), clientv3.WithFilterPut())
+	for resp := range l.client.Watch(watchCtx, key, opts...) {
+		if resp.CompactRevision != 0 {
+			return nil
+		}
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if len(resp.Events) > 0 {
+			return nil
+		}
+	}
+	// Watch 通道只会因为 ctx 结束而关闭
+	return ctx.Err()
+}
+
+// RUnlock 释放读锁
+func (l *EtcdRWLock) RUnlock(ctx context.Context) error {
+	if l.cancelKeepAlive == nil || !strings.HasPrefix(l.heldKey, l.readersPrefix()) {
+		return errors.New("rwlock read lock is not held")
+	}
+	return l.release(ctx)
+}
+
+// Unlock 释放写锁
+func (l *EtcdRWLock) Unlock(ctx context.Context) error {
+	if l.cancelKeepAlive == nil || l.heldKey != l.writerKey() {
+		return errors.New("rwlock write lock is not held")
+	}
+	return l.release(ctx)
+}
+
+// release 停止续约并撤销租约，etcd 随之删除持有的 key，等待者收到删除事件后重新竞争
+func (l *EtcdRWLock) release(ctx context.Context) error {
+	l.cancelKeepAlive()
+	l.cancelKeepAlive = nil
+	l.heldKey = ""
+	_, err := l.client.Revoke(ctx, l.leaseID)
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRWLock(t *testing.T, key string) *EtcdRWLock {
+	t.Helper()
+	l, err := NewEtcdRWLock(newTestEtcdClient(t), key, 5)
+	if err != nil {
+		t.Fatalf("Failed to create rwlock: %v", err)
+	}
+	return l
+}
+
+// TestEtcdRWLock 读者之间可以同时持有，写者和读者互斥
+func TestEtcdRWLock(t *testing.T) {
+	const key = "/rwlocks/test/config"
+	first, second := newTestRWLock(t, key), newTestRWLock(t, key)
+	if err := first.RLock(context.Background()); err != nil {
+		t.Fatalf("RLock failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := second.RLock(ctx); err != nil {
+		t.Fatalf("Second reader blocked while only readers hold the lock: %v", err)
+	}
+
+	// 读者持有时写者等待
+	writer := newTestRWLock(t, key)
+	locked := make(chan error, 1)
+	go func() { locked <- writer.Lock(context.Background()) }()
+	for _, r := range []*EtcdRWLock{first, second} {
+		select {
+		case err := <-locked:
+			t.Fatalf("Writer acquired the lock while readers hold it: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if err := r.RUnlock(context.Background()); err != nil {
+			t.Fatalf("RUnlock failed: %v", err)
+		}
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Writer not woken after readers released")
+	}
+
+	// 写者持有时读者等待
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := first.RLock(ctx); err == nil {
+		t.Fatalf("Reader acquired the lock while the writer holds it")
+	}
+	if err := first.RUnlock(context.Background()); err == nil {
+		t.Errorf("Expected RUnlock without a read lock to fail")
+	}
+	if err := writer.Unlock(context.Background()); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := first.RLock(context.Background()); err != nil {
+		t.Fatalf("RLock after writer released failed: %v", err)
+	}
+	if err := first.Unlock(context.Background()); err == nil {
+		t.Errorf("Expected Unlock with a read lock to fail")
+	}
+	first.RUnlock(context.Background())
+}
+
+// TestEtcdRWLockConcurrent 多个读者和写者并发竞争，写者持有时没有读者，也没有其他写者
+func TestEtcdRWLockConcurrent(t *testing.T) {
+	const key = "/rwlocks/test/concurrent"
+	var (
+		readers   int32
+		writers   int32
+		peakReads int32
+		wg        sync.WaitGroup
+	)
+	check := func() {
+		if w, r := atomic.LoadInt32(&writers), atomic.LoadInt32(&readers); w > 1 || (w == 1 && r > 0) {
+			t.Errorf("Mutual exclusion violated: %d writers, %d readers", w, r)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		l := newTestRWLock(t, key)
+		write := i%3 == 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				if write {
+					if err := l.Lock(context.Background()); err != nil {
+						t.Errorf("Lock failed: %v", err)
+						return
+					}
+					atomic.AddInt32(&writers, 1)
+					check()
+					time.Sleep(30 * time.Millisecond)
+					check()
+					atomic.AddInt32(&writers, -1)
+					if err := l.Unlock(context.Background()); err != nil {
+						t.Errorf("Unlock failed: %v", err)
+					}
+					continue
+				}
+				if err := l.RLock(context.Background()); err != nil {
+					t.Errorf("RLock failed: %v", err)
+					return
+				}
+				n := atomic.AddInt32(&readers, 1)
+				for {
+					p := atomic.LoadInt32(&peakReads)
+					if n <= p || atomic.CompareAndSwapInt32(&peakReads, p, n) {
+						break
+					}
+				}
+				check()
+				time.Sleep(30 * time.Millisecond)
+				check()
+				atomic.AddInt32(&readers, -1)
+				if err := l.RUnlock(context.Background()); err != nil {
+					t.Errorf("RUnlock failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	t.Logf("Peak concurrent readers: %d", peakReads)
+}
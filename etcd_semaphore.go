@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DefaultSemaphoreTTL 是 EtcdSemaphore 每个持有者租约的秒数，持有者崩溃后最多这么久名额被释放
+const DefaultSemaphoreTTL = 10
+
+// EtcdSemaphore 是基于 etcd 的分布式信号量，同一个 key 下最多 max 个持有者
+//
+// 每个竞争者在 key 下写入绑定自己租约的 key（{key}/{leaseID}），按 CreateRevision 排队，
+// 排在前 max 位的持有名额；其余竞争者 Watch 前缀，等前面有 key 被删除后重新计数。
+// 名次由 etcd 的 revision 决定，不会出现两个竞争者同时看到空位而超发名额；进程崩溃时租约过期，名额自动释放。
+// 一个 EtcdSemaphore 同一时间只持有一个名额，多个并发的持有者各自创建自己的 EtcdSemaphore
+type EtcdSemaphore struct {
+	client *clientv3.Client
+	key    string
+	max    int
+
+	heldKey         string
+	leaseID         clientv3.LeaseID
+	cancelKeepAlive context.CancelFunc
+}
+
+// NewEtcdSemaphore 创建 key 下最多 max 个持有者的信号量，同一个 key 的全部竞争者必须使用相同的 max
+func NewEtcdSemaphore(client *clientv3.Client, key string, max int) (*EtcdSemaphore, error) {
+	key = strings.TrimRight(key, "/")
+	if key == "" {
+		return nil, errors.New("semaphore key cannot be empty")
+	}
+	if len(key) > maxLockKeyBytes {
+		return nil, fmt.Errorf("semaphore key too long: %d bytes, max %d", len(key), maxLockKeyBytes)
+	}
+	if max <= 0 {
+		return nil, errors.New("semaphore max must be positive")
+	}
+	return &EtcdSemaphore{client: client, key: key, max: max}, nil
+}
+
+// Key 返回信号量在 etcd 中的 key，持有者的 key 都在它下面
+func (s *EtcdSemaphore) Key() string {
+	return s.key
+}
+
+// Acquire 阻塞直到获得一个名额或 ctx 结束，ctx 结束时撤销排队用的租约
+func (s *EtcdSemaphore) Acquire(ctx context.Context) error {
+	if s.cancelKeepAlive != nil {
+		return errors.New("semaphore slot already held")
+	}
+	leaseID, cancel, err := grantWithKeepAlive(ctx, s.client, DefaultSemaphoreTTL)
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		cancel()
+		s.client.Revoke(context.Background(), leaseID)
+		return err
+	}
+
+	host, _ := os.Hostname()
+	myKey := fmt.Sprintf("%s/%x", s.key, leaseID)
+	putResp, err := s.client.Put(ctx, myKey, fmt.Sprintf("%s-%d", host, os.Getpid()), clientv3.WithLease(leaseID))
+	if err != nil {
+		return abort(err)
+	}
+	myRev := putResp.Header.Revision
+	for {
+		// 统计排在自己前面的 key，少于 max 个时自己就在前 max 位
+		// etcd 返回的 Count 不受 WithMaxCreateRev 过滤，只能数返回的 key
+		resp, err := s.client.Get(ctx, s.key+"/", clientv3.WithPrefix(), clientv3.WithMaxCreateRev(myRev-1), clientv3.WithKeysOnly())
+		if err != nil {
+			return abort(err)
+		}
+		if len(resp.Kvs) < s.max {
+			s.heldKey, s.leaseID, s.cancelKeepAlive = myKey, leaseID, cancel
+			return nil
+		}
+		// 从这次计数之后的 revision 开始监听，避免错过计数和 Watch 之间发生的删除
+		if err := s.waitRelease(ctx, resp.Header.Revision+1); err != nil {
+			return abort(err)
+		}
+	}
+}
+
+// waitRelease 监听信号量前缀，直到有持有者的 key 被删除
+func (s *EtcdSemaphore) waitRelease(ctx context.Context, rev int64) error {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for resp := range s.client.Watch(watchCtx, s.key+"/", clientv3.WithPrefix(), clientv3.WithRev(rev), clientv3.WithFilterPut()) {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if len(resp.Events) > 0 {
+			return nil
+		}
+	}
+	// Watch 通道只会因为 ctx 结束而关闭
+	return ctx.Err()
+}
+
+// grantWithKeepAlive 申请 ttl 秒的租约并启动自动续约，cancel 停止续约
+func grantWithKeepAlive(ctx context.Context, client *clientv3.Client, ttl int64) (clientv3.LeaseID, context.CancelFunc, error) {
+	leaseResp, err := client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, nil, err
+	}
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	keepAliveCh, err := client.KeepAlive(keepAliveCtx, leaseResp.ID)
+	if err != nil {
+		cancel()
+		client.Revoke(context.Background(), leaseResp.ID)
+		return 0, nil, err
+	}
+	// 必须持续消费续约响应，否则通道写满后续约会阻塞
+	go func() {
+		for range keepAliveCh {
+		}
+	}()
+	return leaseResp.ID, cancel, nil
+}
+
+// Release 删除自己的 key 并撤销租约，把名额还给排队中的竞争者
+func (s *EtcdSemaphore) Release(ctx context.Context) error {
+	if s.cancelKeepAlive == nil {
+		return errors.New("semaphore slot is not held")
+	}
+	s.cancelKeepAlive()
+	s.cancelKeepAlive = nil
+	// 撤销租约时 etcd 删除绑定在它上面的 key，等待者随之收到删除事件
+	_, err := s.client.Revoke(ctx, s.leaseID)
+	return err
+}
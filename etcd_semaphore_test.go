@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// TestEtcdSemaphore 四个 goroutine 争用 max=2 的信号量，同时持有名额的不超过两个
+func TestEtcdSemaphore(t *testing.T) {
+	client := newTestEtcdClient(t)
+	var (
+		inside int32
+		peak   int32
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < 4; i++ {
+		sem, err := NewEtcdSemaphore(client, "/semaphores/test/batch", 2)
+		if err != nil {
+			t.Fatalf("Failed to create semaphore: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sem.Acquire(context.Background()); err != nil {
+				t.Errorf("Failed to acquire semaphore: %v", err)
+				return
+			}
+			n := atomic.AddInt32(&inside, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(200 * time.Millisecond)
+			atomic.AddInt32(&inside, -1)
+			if err := sem.Release(context.Background()); err != nil {
+				t.Errorf("Failed to release semaphore: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("Peak concurrent holders = %d, want 2", peak)
+	}
+}
+
+func TestEtcdSemaphoreAcquireTimeout(t *testing.T) {
+	client := newTestEtcdClient(t)
+	holder, err := NewEtcdSemaphore(client, "/semaphores/test/timeout", 1)
+	if err != nil {
+		t.Fatalf("Failed to create semaphore: %v", err)
+	}
+	if err := holder.Acquire(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire semaphore: %v", err)
+	}
+	defer holder.Release(context.Background())
+
+	waiter, _ := NewEtcdSemaphore(client, "/semaphores/test/timeout", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := waiter.Acquire(ctx); err == nil {
+		t.Fatalf("Expected Acquire to time out while the only slot is held")
+	}
+	// 放弃排队的竞争者不再占位
+	resp, err := client.Get(context.Background(), holder.Key()+"/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		t.Fatalf("Failed to count holders: %v", err)
+	}
+	if resp.Count != 1 {
+		t.Errorf("Expected 1 key after the waiter gave up, got %d", resp.Count)
+	}
+	if _, err := NewEtcdSemaphore(client, "/semaphores/test/timeout", 0); err == nil {
+		t.Errorf("Expected error for non-positive max")
+	}
+}
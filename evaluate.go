@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Evaluate 在 v 上按路径表达式取值，支持字段访问、切片/数组下标和 map 键，例如
+//
+//	Services[0].Addr
+//	Meta[region]
+//
+// 指针和接口会自动解引用；下标越界、键不存在或路径不合法时返回错误
+func Evaluate(v interface{}, expr string) (interface{}, error) {
+	steps, err := parsePath(expr)
+	if err != nil {
+		return nil, err
+	}
+	cur := reflect.ValueOf(v)
+	walked := ""
+	for _, step := range steps {
+		for cur.Kind() == reflect.Ptr || cur.Kind() == reflect.Interface {
+			if cur.IsNil() {
+				return nil, fmt.Errorf("evaluate %q: nil value at %q", expr, walked)
+			}
+			cur = cur.Elem()
+		}
+		if !cur.IsValid() {
+			return nil, fmt.Errorf("evaluate %q: invalid value at %q", expr, walked)
+		}
+		if step.field != "" {
+			if cur.Kind() != reflect.Struct {
+				return nil, fmt.Errorf("evaluate %q: cannot access field %s on %s", expr, step.field, cur.Type())
+			}
+			sf, ok := cur.Type().FieldByName(step.field)
+			if !ok || !sf.IsExported() {
+				return nil, fmt.Errorf("evaluate %q: %s has no exported field %s", expr, cur.Type(), step.field)
+			}
+			cur = cur.FieldByIndex(sf.Index)
+			walked = joinPath(walked, step.field)
+			continue
+		}
+		switch cur.Kind() {
+		case reflect.Slice, reflect.Array:
+			idx, err := strconv.Atoi(step.key)
+			if err != nil {
+				return nil, fmt.Errorf("evaluate %q: invalid index %q", expr, step.key)
+			}
+			if idx < 0 || idx >= cur.Len() {
+				return nil, fmt.Errorf("evaluate %q: index %d out of range [0, %d)", expr, idx, cur.Len())
+			}
+			cur = cur.Index(idx)
+		case reflect.Map:
+			key, err := convertString(step.key, cur.Type().Key())
+			if err != nil {
+				return nil, fmt.Errorf("evaluate %q: invalid map key %q: %w", expr, step.key, err)
+			}
+			val := cur.MapIndex(key)
+			if !val.IsValid() {
+				return nil, fmt.Errorf("evaluate %q: key %q not found", expr, step.key)
+			}
+			cur = val
+		default:
+			return nil, fmt.Errorf("evaluate %q: cannot index %s", expr, cur.Type())
+		}
+		walked += "[" + step.key + "]"
+	}
+	if !cur.IsValid() {
+		return nil, nil
+	}
+	return cur.Interface(), nil
+}
+
+// pathStep 是路径中的一步：字段名或方括号中的下标/键，二者只有一个非空
+type pathStep struct {
+	field string
+	key   string
+}
+
+func parsePath(expr string) ([]pathStep, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	var steps []pathStep
+	s := expr
+	expectField := !strings.HasPrefix(s, "[")
+	for s != "" {
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end <= 1 {
+				return nil, fmt.Errorf("bad path %q: unterminated or empty brackets", expr)
+			}
+			steps = append(steps, pathStep{key: s[1:end]})
+			s = s[end+1:]
+			expectField = false
+			continue
+		}
+		if s[0] == '.' {
+			if expectField || len(s) == 1 {
+				return nil, fmt.Errorf("bad path %q: unexpected '.'", expr)
+			}
+			s = s[1:]
+			expectField = true
+			continue
+		}
+		if !expectField {
+			return nil, fmt.Errorf("bad path %q: missing '.' before %q", expr, s)
+		}
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		steps = append(steps, pathStep{field: s[:end]})
+		s = s[end:]
+		expectField = false
+	}
+	if expectField {
+		return nil, fmt.Errorf("bad path %q: trailing '.'", expr)
+	}
+	return steps, nil
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// convertString 把字符串转换为 t 类型的值，支持字符串、布尔和数值类型
+func convertString(s string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.SetFloat(f)
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported type %s", t)
+	}
+	return v, nil
+}
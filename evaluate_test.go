@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+type evalInstance struct {
+	Addr string
+}
+
+type evalConfig struct {
+	Services []evalInstance
+	Meta     map[string]string
+	Owner    *Person
+}
+
+func TestEvaluate(t *testing.T) {
+	cfg := &evalConfig{
+		Services: []evalInstance{{Addr: "10.0.0.1:8080"}, {Addr: "10.0.0.2:8080"}},
+		Meta:     map[string]string{"region": "cn-east"},
+		Owner:    &Person{Name: "Alice", Age: 30},
+	}
+	cases := []struct {
+		expr string
+		want interface{}
+	}{
+		{"Owner.Age", 30},
+		{"Services[1].Addr", "10.0.0.2:8080"},
+		{"Meta[region]", "cn-east"},
+	}
+	for _, c := range cases {
+		got, err := Evaluate(cfg, c.expr)
+		if err != nil {
+			t.Fatalf("Evaluate(%q) failed: %v", c.expr, err)
+		}
+		if got != c.want {
+			t.Errorf("Evaluate(%q) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	cfg := evalConfig{Services: []evalInstance{{Addr: "10.0.0.1:8080"}}, Meta: map[string]string{}}
+	for _, expr := range []string{"Services[2].Addr", "Meta[zone]", "Missing", "Services[x]", "Services..Addr", "Owner.Name"} {
+		if _, err := Evaluate(cfg, expr); err == nil {
+			t.Errorf("Evaluate(%q) expected error", expr)
+		} else {
+			t.Logf("Evaluate(%q): %v", expr, err)
+		}
+	}
+}
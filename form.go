@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+)
+
+// DecodeForm 把表单值绑定到结构体指针上，key 取字段的 form 标签，没有标签时使用字段名
+// 切片字段接收同名 key 的全部值，其余字段只取第一个值；类型转换规则与 MapToStruct 相同
+// 表单中没有的字段保持原值，数字等无法转换时返回带字段名的错误
+func DecodeForm(values url.Values, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode form: expected non-nil struct pointer, got %T", out)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := field.Name
+		if name := field.Tag.Get("form"); name == "-" {
+			continue
+		} else if name != "" {
+			key = name
+		}
+		vs, ok := values[key]
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		fv := rv.Field(i)
+		if fv.Kind() != reflect.Slice || fv.Type().Elem().Kind() == reflect.Uint8 {
+			if err := setFromString(fv, field, vs[0]); err != nil {
+				return err
+			}
+			continue
+		}
+		// 先转换到新切片，出错时字段保持原值
+		slice := reflect.MakeSlice(fv.Type(), len(vs), len(vs))
+		for j, s := range vs {
+			if err := setFromString(slice.Index(j), field, s); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+	}
+	return nil
+}
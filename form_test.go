@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type orderForm struct {
+	Tags     []string `form:"tag"`
+	Quantity int      `form:"qty"`
+	Price    float64
+	Note     string `form:"note"`
+}
+
+func TestDecodeForm(t *testing.T) {
+	form := orderForm{Note: "keep"}
+	values := url.Values{
+		"tag":   {"fast", "gift"},
+		"qty":   {"3"},
+		"Price": {"9.5"},
+	}
+	if err := DecodeForm(values, &form); err != nil {
+		t.Fatalf("DecodeForm failed: %v", err)
+	}
+	if !reflect.DeepEqual(form.Tags, []string{"fast", "gift"}) {
+		t.Errorf("Tags = %v", form.Tags)
+	}
+	if form.Quantity != 3 || form.Price != 9.5 || form.Note != "keep" {
+		t.Errorf("Unexpected form %+v", form)
+	}
+}
+
+func TestDecodeFormMalformedNumber(t *testing.T) {
+	var form orderForm
+	err := DecodeForm(url.Values{"qty": {"three"}}, &form)
+	if err == nil || !strings.Contains(err.Error(), "Quantity") {
+		t.Errorf("Expected error naming the field, got %v", err)
+	}
+}
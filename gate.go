@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Gate 是容量为 N 的计数门，最多 N 个持有者同时通过
+// 计数只用 int32 上的 CompareAndSwap 维护，没有通道和 mutex，等待时使用与 SpinLock 相同的自适应退避
+// 适合临界区很短、对延迟敏感的热路径；持有时间较长时用带缓冲的通道更合适
+type Gate struct {
+	count    int32
+	capacity int32
+}
+
+// NewGate 创建容量为 capacity 的计数门，capacity 必须为正数
+func NewGate(capacity int) *Gate {
+	if capacity <= 0 {
+		panic("gate: capacity must be positive")
+	}
+	return &Gate{capacity: int32(capacity)}
+}
+
+// Acquire 自旋直到获得一个名额
+func (g *Gate) Acquire() {
+	g.AcquireContext(context.Background())
+}
+
+// AcquireContext 自旋直到获得一个名额，ctx 在获得名额之前结束时返回 ctx.Err()
+func (g *Gate) AcquireContext(ctx context.Context) error {
+	b := newSpinBackoff(0, 0, 0, 0)
+	for !g.TryAcquire() {
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TryAcquire 在还有空余名额时占用一个并返回 true，名额已满时立即返回 false
+func (g *Gate) TryAcquire() bool {
+	for {
+		n := atomic.LoadInt32(&g.count)
+		if n >= g.capacity {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&g.count, n, n+1) {
+			return true
+		}
+	}
+}
+
+// Release 归还一个名额
+func (g *Gate) Release() {
+	if atomic.AddInt32(&g.count, -1) < 0 {
+		panic("gate: Release without Acquire")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGateCapacity(t *testing.T) {
+	g := NewGate(3)
+	var inside, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.Acquire()
+				n := atomic.AddInt32(&inside, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Microsecond)
+				atomic.AddInt32(&inside, -1)
+				g.Release()
+			}
+		}()
+	}
+	wg.Wait()
+	if peak > 3 {
+		t.Errorf("Peak holders = %d, want at most 3", peak)
+	}
+}
+
+func TestGateTryAcquire(t *testing.T) {
+	g := NewGate(2)
+	if !g.TryAcquire() || !g.TryAcquire() {
+		t.Fatalf("Expected two slots to be free")
+	}
+	if g.TryAcquire() {
+		t.Fatalf("Expected TryAcquire to fail when the gate is full")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.AcquireContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("AcquireContext on a full gate = %v, want DeadlineExceeded", err)
+	}
+	g.Release()
+	if !g.TryAcquire() {
+		t.Errorf("Expected a slot after Release")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic on Release without Acquire")
+		}
+	}()
+	g.Release()
+	g.Release()
+	g.Release()
+}
+
+// chanSemaphore 是用带缓冲通道实现的信号量，作为基准对照
+type chanSemaphore chan struct{}
+
+func (s chanSemaphore) Acquire() { s <- struct{}{} }
+func (s chanSemaphore) Release() { <-s }
+
+// benchmarkGate 让 16 个 goroutine 争用容量为 4 的名额，持有期间做少量工作
+func benchmarkGate(b *testing.B, acquire, release func()) {
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		var sink int
+		for pb.Next() {
+			acquire()
+			for j := 0; j < 100; j++ {
+				sink++
+			}
+			release()
+		}
+		_ = sink
+	})
+}
+
+func BenchmarkGate(b *testing.B) {
+	g := NewGate(4)
+	benchmarkGate(b, g.Acquire, g.Release)
+}
+
+func BenchmarkChanSemaphore(b *testing.B) {
+	s := make(chanSemaphore, 4)
+	benchmarkGate(b, s.Acquire, s.Release)
+}
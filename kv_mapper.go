@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// StructToKV 把结构体（或结构体指针）展开为 etcd 的 key/value，key 为 prefix/字段名
+// 字段名取 `etcd:"..."` 标签，没有标签时使用字段名，标签为 "-" 的字段跳过
+// 嵌套结构体递归展开为 prefix/外层字段/内层字段，nil 指针跳过
+// 值的格式与 KVToStruct 的解析规则对应：time.Time 按 `time` 标签格式化（默认 RFC3339），
+// time.Duration 输出为 1m30s 形式，其余基础类型按 fmt 的默认格式
+func StructToKV(prefix string, v interface{}) (map[string]string, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("struct to kv: expected struct, got %T", v)
+	}
+	kvs := make(map[string]string)
+	if err := structToKV(rv, prefix, kvs); err != nil {
+		return nil, err
+	}
+	return kvs, nil
+}
+
+func structToKV(rv reflect.Value, prefix string, kvs map[string]string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		key, ok := etcdFieldKey(field)
+		if !ok {
+			continue
+		}
+		key = joinKey(prefix, key)
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		switch {
+		case fv.Type() == timeType:
+			layout := field.Tag.Get("time")
+			if layout == "" {
+				layout = time.RFC3339
+			}
+			kvs[key] = fv.Interface().(time.Time).Format(layout)
+		case fv.Type() == durationType:
+			kvs[key] = time.Duration(fv.Int()).String()
+		case fv.Kind() == reflect.Struct:
+			if err := structToKV(fv, key, kvs); err != nil {
+				return err
+			}
+		default:
+			switch fv.Kind() {
+			case reflect.String, reflect.Bool,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+				kvs[key] = fmt.Sprint(fv.Interface())
+			default:
+				return fmt.Errorf("field %s: unsupported type %s", field.Name, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// KVToStruct 按 StructToKV 的 key 规则把 kvs 中的值写回结构体指针
+// 嵌套结构体指针为 nil 且 kvs 中有它的字段时会自动分配；kvs 中没有的字段保持原值
+func KVToStruct(prefix string, kvs map[string]string, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("kv to struct: expected non-nil struct pointer, got %T", out)
+	}
+	return kvToStruct(rv.Elem(), prefix, kvs)
+}
+
+func kvToStruct(rv reflect.Value, prefix string, kvs map[string]string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		key, ok := etcdFieldKey(field)
+		if !ok {
+			continue
+		}
+		key = joinKey(prefix, key)
+		fv := rv.Field(i)
+		elemType := field.Type
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if elemType.Kind() == reflect.Struct && elemType != timeType {
+			if !hasKeyPrefix(kvs, key+"/") {
+				continue
+			}
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(elemType))
+				}
+				fv = fv.Elem()
+			}
+			if err := kvToStruct(fv, key, kvs); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := kvs[key]
+		if !ok {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			// 先转换到新值，出错时字段保持原值
+			nv := reflect.New(elemType)
+			if err := setFromString(nv.Elem(), field, s); err != nil {
+				return err
+			}
+			fv.Set(nv)
+			continue
+		}
+		if err := setFromString(fv, field, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// etcdFieldKey 返回字段在 etcd 中的 key 片段，未导出或标签为 "-" 的字段返回 false
+func etcdFieldKey(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	switch name := field.Tag.Get("etcd"); name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return name, true
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + name
+}
+
+func hasKeyPrefix(kvs map[string]string, prefix string) bool {
+	for k := range kvs {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
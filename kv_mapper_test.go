@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type etcdEndpoint struct {
+	Host string `etcd:"host"`
+	Port int    `etcd:"port"`
+}
+
+type etcdServiceConfig struct {
+	Name     string        `etcd:"name"`
+	Enabled  bool          `etcd:"enabled"`
+	Weight   float64       `etcd:"weight"`
+	Timeout  time.Duration `etcd:"timeout"`
+	Since    time.Time     `etcd:"since" time:"2006-01-02"`
+	Primary  etcdEndpoint  `etcd:"primary"`
+	Backup   *etcdEndpoint `etcd:"backup"`
+	Retries  int
+	Internal string `etcd:"-"`
+	secret   string
+}
+
+func TestStructToKV(t *testing.T) {
+	cfg := etcdServiceConfig{
+		Name:     "order",
+		Enabled:  true,
+		Weight:   0.5,
+		Timeout:  1500 * time.Millisecond,
+		Since:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Primary:  etcdEndpoint{Host: "10.0.0.1", Port: 8080},
+		Retries:  3,
+		Internal: "skip",
+		secret:   "skip",
+	}
+	kvs, err := StructToKV("/config/order/", &cfg)
+	if err != nil {
+		t.Fatalf("StructToKV failed: %v", err)
+	}
+	want := map[string]string{
+		"/config/order/name":         "order",
+		"/config/order/enabled":      "true",
+		"/config/order/weight":       "0.5",
+		"/config/order/timeout":      "1.5s",
+		"/config/order/since":        "2024-05-01",
+		"/config/order/primary/host": "10.0.0.1",
+		"/config/order/primary/port": "8080",
+		"/config/order/Retries":      "3",
+	}
+	if !reflect.DeepEqual(kvs, want) {
+		t.Errorf("StructToKV = %v, want %v", kvs, want)
+	}
+
+	if _, err := StructToKV("/config", 42); err == nil {
+		t.Errorf("Expected error for non-struct input")
+	}
+	if _, err := StructToKV("/config", struct{ Tags []string }{}); err == nil {
+		t.Errorf("Expected error for unsupported field type")
+	}
+}
+
+func TestKVToStructRoundTrip(t *testing.T) {
+	in := etcdServiceConfig{
+		Name:    "order",
+		Enabled: true,
+		Weight:  2.25,
+		Timeout: time.Minute,
+		Since:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Primary: etcdEndpoint{Host: "10.0.0.1", Port: 8080},
+		Backup:  &etcdEndpoint{Host: "10.0.0.2", Port: 9090},
+		Retries: 5,
+	}
+	kvs, err := StructToKV("/config/order", in)
+	if err != nil {
+		t.Fatalf("StructToKV failed: %v", err)
+	}
+	var out etcdServiceConfig
+	if err := KVToStruct("/config/order", kvs, &out); err != nil {
+		t.Fatalf("KVToStruct failed: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestKVToStructErrors(t *testing.T) {
+	var cfg etcdServiceConfig
+	if err := KVToStruct("/config", nil, cfg); err == nil {
+		t.Errorf("Expected error for non-pointer output")
+	}
+	err := KVToStruct("/config", map[string]string{"/config/primary/port": "http"}, &cfg)
+	if err == nil {
+		t.Fatalf("Expected error for invalid port")
+	}
+	t.Logf("KVToStruct error: %v", err)
+	if cfg.Backup != nil {
+		t.Errorf("Backup should stay nil when no backup keys are present")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// LockStats 是一个锁 key 的累计获取次数和等待时间
+type LockStats struct {
+	Acquisitions int64
+	TotalWait    time.Duration
+}
+
+// LockHeatmap 汇总进程内各个分布式锁的争用情况，用于容量规划时找出最热的锁
+// 多个锁可以共用同一个 LockHeatmap，并发安全
+type LockHeatmap struct {
+	mu    sync.Mutex
+	stats map[string]LockStats
+}
+
+func NewLockHeatmap() *LockHeatmap {
+	return &LockHeatmap{stats: make(map[string]LockStats)}
+}
+
+// WithLockHeatmap 让锁在每次成功获取后把 key 和等待时间记录到 h
+func WithLockHeatmap(h *LockHeatmap) LockOption {
+	return func(o *lockOptions) {
+		o.heatmap = h
+	}
+}
+
+func (h *LockHeatmap) record(key string, wait time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stats[key]
+	s.Acquisitions++
+	s.TotalWait += wait
+	h.stats[key] = s
+}
+
+// Heatmap 返回当前统计的快照，调用方可以随意修改返回的 map
+func (h *LockHeatmap) Heatmap() map[string]LockStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshot := make(map[string]LockStats, len(h.stats))
+	for k, v := range h.stats {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Reset 清空统计，开始新的观察窗口
+func (h *LockHeatmap) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats = make(map[string]LockStats)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockHeatmap(t *testing.T) {
+	client := newTestEtcdClient(t)
+	heatmap := NewLockHeatmap()
+	hot, err := NewEtcdDistributedLock(client, "hot", 5, WithLockPrefix("/locks/heatmap"), WithLockHeatmap(heatmap))
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	cold, err := NewEtcdDistributedLock(client, "cold", 5, WithLockPrefix("/locks/heatmap"), WithLockHeatmap(heatmap))
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := hot.Lock(ctx); err != nil {
+			t.Fatalf("Failed to acquire hot lock: %v", err)
+		}
+		hot.Unlock(ctx)
+	}
+	if _, err := cold.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire cold lock: %v", err)
+	}
+	cold.Unlock(ctx)
+
+	// 另一个持有者占用锁 200ms，等待时间应累计到 hot 上
+	holder, _ := NewEtcdDistributedLock(client, "hot", 5, WithLockPrefix("/locks/heatmap"))
+	if _, err := holder.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire holder lock: %v", err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		holder.Unlock(ctx)
+	}()
+	if _, err := hot.Lock(ctx); err != nil {
+		t.Fatalf("Failed to acquire contended hot lock: %v", err)
+	}
+	hot.Unlock(ctx)
+
+	stats := heatmap.Heatmap()
+	if got := stats[hot.Key()].Acquisitions; got != 4 {
+		t.Errorf("hot acquisitions = %d, want 4", got)
+	}
+	if got := stats[cold.Key()].Acquisitions; got != 1 {
+		t.Errorf("cold acquisitions = %d, want 1", got)
+	}
+	if wait := stats[hot.Key()].TotalWait; wait < 200*time.Millisecond {
+		t.Errorf("hot total wait = %v, want at least 200ms", wait)
+	}
+
+	heatmap.Reset()
+	if len(heatmap.Heatmap()) != 0 {
+		t.Errorf("Expected empty heatmap after Reset")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// MarshalWithProfile 把结构体编码为 JSON，key 由 transform 根据字段名生成，忽略静态的 json 标签
+// 同一份数据可以在运行时按不同的命名风格（snake_case、camelCase 等）输出，而不需要复制结构体
+// 嵌套结构体以及结构体的切片、map 会递归转换
+func MarshalWithProfile(v interface{}, transform func(fieldName string) string) ([]byte, error) {
+	if transform == nil {
+		return nil, fmt.Errorf("transform cannot be nil")
+	}
+	return json.Marshal(profileValue(reflect.ValueOf(v), transform))
+}
+
+func profileValue(v reflect.Value, transform func(string) string) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return profileValue(v.Elem(), transform)
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			out[transform(field.Name)] = profileValue(v.Field(i), transform)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		// []byte 保持 encoding/json 的 base64 编码
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = profileValue(v.Index(i), transform)
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		// map 的 key 是数据而不是字段名，保持原样
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = profileValue(iter.Value(), transform)
+		}
+		return out
+	default:
+		return v.Interface()
+	}
+}
+
+// SnakeCase 把 Go 字段名转换为 snake_case，例如 ServiceAddr -> service_addr，HTTPPort -> http_port
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// 小写后跟大写，或连续大写的最后一个后跟小写时断词
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// CamelCase 把 Go 字段名转换为首字母小写的 camelCase，例如 ServiceAddr -> serviceAddr，HTTPPort -> httpPort
+func CamelCase(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		// 开头连续的大写字母整体转小写，但保留下一个单词的首字母
+		if !unicode.IsUpper(runes[i]) || (i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
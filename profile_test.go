@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type profileRecord struct {
+	ServiceAddr string `json:"addr"`
+	HTTPPort    int
+	Owner       Person
+}
+
+func TestMarshalWithProfile(t *testing.T) {
+	rec := profileRecord{ServiceAddr: "10.0.0.1", HTTPPort: 8080, Owner: Person{Name: "Alice", Age: 30}}
+	cases := []struct {
+		name      string
+		transform func(string) string
+		want      map[string]interface{}
+	}{
+		{"snake_case", SnakeCase, map[string]interface{}{
+			"service_addr": "10.0.0.1",
+			"http_port":    float64(8080),
+			"owner":        map[string]interface{}{"name": "Alice", "age": float64(30)},
+		}},
+		{"camelCase", CamelCase, map[string]interface{}{
+			"serviceAddr": "10.0.0.1",
+			"httpPort":    float64(8080),
+			"owner":       map[string]interface{}{"name": "Alice", "age": float64(30)},
+		}},
+	}
+	for _, c := range cases {
+		data, err := MarshalWithProfile(rec, c.transform)
+		if err != nil {
+			t.Fatalf("%s: MarshalWithProfile failed: %v", c.name, err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: invalid json %s: %v", c.name, data, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %s, want %v", c.name, data, c.want)
+		}
+	}
+}
+
+func TestMarshalPersonSnakeCase(t *testing.T) {
+	data, err := MarshalWithProfile(&Person{Name: "Bob", Age: 20}, SnakeCase)
+	if err != nil {
+		t.Fatalf("MarshalWithProfile failed: %v", err)
+	}
+	if string(data) != `{"age":20,"name":"Bob"}` {
+		t.Errorf("Unexpected output %s", data)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "sync/atomic"
+
+// ReentrantSpinLock 是可重入的自旋锁：持有者用同一个 token 再次加锁只增加计数，不会死锁
+// Go 不暴露 goroutine ID，持有者身份由调用方显式传入的 token 表示
+//
+// 注意：锁只认 token 不认 goroutine，调用方必须保证每个并发执行流使用不同的非零 token
+// （例如每个请求生成一个随机数），两个 goroutine 共用同一个 token 时互斥不再成立
+type ReentrantSpinLock struct {
+	sl    SpinLock
+	owner int64 // 持有者的 token，0 表示未被持有
+	count int32 // 重入次数，只由持有者修改
+}
+
+// LockToken 以 token 的身份加锁，token 已经持有锁时只增加重入计数
+func (l *ReentrantSpinLock) LockToken(token int64) {
+	if token == 0 {
+		panic("reentrant spin lock: token must be non-zero")
+	}
+	if atomic.LoadInt64(&l.owner) == token {
+		l.count++
+		return
+	}
+	l.sl.Lock()
+	atomic.StoreInt64(&l.owner, token)
+	l.count = 1
+}
+
+// UnlockToken 减少 token 的重入计数，计数归零时释放锁；token 不是持有者时 panic
+func (l *ReentrantSpinLock) UnlockToken(token int64) {
+	if token == 0 || atomic.LoadInt64(&l.owner) != token {
+		panic("reentrant spin lock: unlock by non-owner")
+	}
+	if l.count--; l.count > 0 {
+		return
+	}
+	atomic.StoreInt64(&l.owner, 0)
+	l.sl.Unlock()
+}
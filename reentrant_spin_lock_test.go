@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReentrantSpinLockNested(t *testing.T) {
+	var l ReentrantSpinLock
+	l.LockToken(1)
+	l.LockToken(1)
+	l.LockToken(1)
+	l.UnlockToken(1)
+	l.UnlockToken(1)
+	if l.sl.TryLock() {
+		t.Fatalf("Expected lock to be held until the outermost unlock")
+	}
+	l.UnlockToken(1)
+	if !l.sl.TryLock() {
+		t.Fatalf("Expected lock to be released after the outermost unlock")
+	}
+	l.sl.Unlock()
+}
+
+func TestReentrantSpinLockContention(t *testing.T) {
+	var l ReentrantSpinLock
+	const goroutines, rounds = 8, 500
+	counter := 0
+	var wg sync.WaitGroup
+	for g := 1; g <= goroutines; g++ {
+		wg.Add(1)
+		go func(token int64) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				l.LockToken(token)
+				l.LockToken(token)
+				counter++
+				l.UnlockToken(token)
+				l.UnlockToken(token)
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	if counter != goroutines*rounds {
+		t.Errorf("counter = %d, want %d", counter, goroutines*rounds)
+	}
+}
+
+func TestReentrantSpinLockUnlockByNonOwner(t *testing.T) {
+	var l ReentrantSpinLock
+	l.LockToken(1)
+	defer l.UnlockToken(1)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic when unlocking with another token")
+		}
+	}()
+	l.UnlockToken(2)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// restartKeyPrefix 是滚动重启名额所在的前缀，每个集群一个子 key
+const restartKeyPrefix = "/restart/"
+
+// RestartCoordinator 用 EtcdSemaphore 限制滚动重启时同时处于维护状态的实例数
+//
+// 每个实例创建自己的 RestartCoordinator，重启前 AcquireMaintenanceSlot 拿到名额，
+// 然后注销服务、重启、重新注册，最后 ReleaseMaintenanceSlot 归还名额。
+// 名额绑定在实例自己的租约上，实例在维护中崩溃时名额在租约过期后自动归还
+type RestartCoordinator struct {
+	sem *EtcdSemaphore
+}
+
+// NewRestartCoordinator 创建集群 cluster 的重启协调器，同一时间最多 k 个实例处于维护状态
+// 同一个集群的全部实例必须使用相同的 k
+func NewRestartCoordinator(client *clientv3.Client, cluster string, k int) (*RestartCoordinator, error) {
+	sem, err := NewEtcdSemaphore(client, restartKeyPrefix+strings.Trim(cluster, "/"), k)
+	if err != nil {
+		return nil, err
+	}
+	return &RestartCoordinator{sem: sem}, nil
+}
+
+// AcquireMaintenanceSlot 阻塞直到拿到维护名额或 ctx 结束，名额按请求顺序分配
+func (c *RestartCoordinator) AcquireMaintenanceSlot(ctx context.Context) error {
+	return c.sem.Acquire(ctx)
+}
+
+// ReleaseMaintenanceSlot 归还维护名额，应在实例重新注册之后调用
+func (c *RestartCoordinator) ReleaseMaintenanceSlot(ctx context.Context) error {
+	return c.sem.Release(ctx)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRestartCoordinatorSerializesMaintenance K=1 时三个实例的维护窗口互不重叠
+func TestRestartCoordinatorSerializesMaintenance(t *testing.T) {
+	client := newTestEtcdClient(t)
+	type window struct{ start, end time.Time }
+	var (
+		mu      sync.Mutex
+		windows []window
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 3; i++ {
+		c, err := NewRestartCoordinator(client, "test-cluster", 1)
+		if err != nil {
+			t.Fatalf("Failed to create restart coordinator: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.AcquireMaintenanceSlot(context.Background()); err != nil {
+				t.Errorf("Failed to acquire maintenance slot: %v", err)
+				return
+			}
+			// 注销、重启、重新注册
+			w := window{start: time.Now()}
+			time.Sleep(100 * time.Millisecond)
+			w.end = time.Now()
+			mu.Lock()
+			windows = append(windows, w)
+			mu.Unlock()
+			if err := c.ReleaseMaintenanceSlot(context.Background()); err != nil {
+				t.Errorf("Failed to release maintenance slot: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(windows) != 3 {
+		t.Fatalf("Expected 3 maintenance windows, got %d", len(windows))
+	}
+	for i, a := range windows {
+		for _, b := range windows[i+1:] {
+			if a.start.Before(b.end) && b.start.Before(a.end) {
+				t.Errorf("Maintenance windows overlap: %v-%v and %v-%v", a.start, a.end, b.start, b.end)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// RWSpinLock 是基于原子操作的读写自旋锁：多个读者可以同时持有，写者独占
+// state 为 -1 表示被写者持有，大于 0 表示当前读者数量，0 表示空闲
+// 写者在读者持续到来时可能一直拿不到锁，适合读多写少且临界区很短的场景
+type RWSpinLock struct {
+	state int32
+}
+
+// RLock 在没有写者持有时把读者数量加一
+func (l *RWSpinLock) RLock() {
+	for {
+		s := atomic.LoadInt32(&l.state)
+		if s >= 0 && atomic.CompareAndSwapInt32(&l.state, s, s+1) {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+// RUnlock 把读者数量减一
+func (l *RWSpinLock) RUnlock() {
+	if atomic.AddInt32(&l.state, -1) < 0 {
+		panic("rw spin lock: RUnlock of unlocked lock")
+	}
+}
+
+// Lock 等待全部读者和写者退出后独占锁
+func (l *RWSpinLock) Lock() {
+	for !atomic.CompareAndSwapInt32(&l.state, 0, -1) {
+		runtime.Gosched()
+	}
+}
+
+// Unlock 释放写锁
+func (l *RWSpinLock) Unlock() {
+	if !atomic.CompareAndSwapInt32(&l.state, -1, 0) {
+		panic("rw spin lock: Unlock of unlocked lock")
+	}
+}
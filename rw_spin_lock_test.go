@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRWSpinLockReadersShare(t *testing.T) {
+	var l RWSpinLock
+	l.RLock()
+	acquired := make(chan struct{})
+	go func() {
+		l.RLock()
+		close(acquired)
+		l.RUnlock()
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("Second reader blocked by the first one")
+	}
+	l.RUnlock()
+}
+
+func TestRWSpinLockWriterWaitsForReaders(t *testing.T) {
+	var l RWSpinLock
+	l.RLock()
+	l.RLock()
+	var written int32
+	done := make(chan struct{})
+	go func() {
+		l.Lock()
+		atomic.StoreInt32(&written, 1)
+		l.Unlock()
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	l.RUnlock()
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&written) != 0 {
+		t.Fatalf("Writer acquired the lock while a reader was still active")
+	}
+	l.RUnlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Writer not admitted after all readers left")
+	}
+
+	// 写者持有期间读者被阻塞
+	l.Lock()
+	read := make(chan struct{})
+	go func() {
+		l.RLock()
+		close(read)
+		l.RUnlock()
+	}()
+	select {
+	case <-read:
+		t.Fatalf("Reader acquired the lock while a writer held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.Unlock()
+	<-read
+}
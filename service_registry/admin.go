@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Admin 是面向运维的管理工具，操作的是命名空间下全部服务的实例，而不是某个注册端自己的实例
+type Admin struct {
+	client *clientv3.Client
+	// 查询租约剩余时间使用的 Lease，默认就是 client，测试中可以替换成假实现
+	lease clientv3.Lease
+	opts  options
+}
+
+// NewEtcdAdmin 创建管理工具，选项与注册端、发现端相同（WithNamespace、WithTLS、WithOpRetry 等）
+func NewEtcdAdmin(endpoints []string, dialTimeout time.Duration, opts ...Option) (*Admin, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrEmptyEndpoints
+	}
+	o := newOptions(opts)
+	cli, err := newClient(endpoints, dialTimeout, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{client: cli, lease: cli, opts: o}, nil
+}
+
+// Close 关闭 etcd 客户端
+func (a *Admin) Close() error {
+	return a.client.Close()
+}
+
+// ReapOrphans 删除绑定在已失效租约上的实例 key，返回删除的数量
+// 注册端崩溃后残留的实例通常会随租约过期消失，这里用于主动清理：
+// 列出全部实例 key，逐个租约查询剩余时间，只有租约已经过期（TTL <= 0）或不存在时才删除它上面的 key；
+// 剩余时间很短但仍然有效的租约不受影响，没有绑定租约的 key 也不处理
+// 删除时比较 key 当前绑定的租约，查询之后重新注册到新租约上的实例不会被误删
+func (a *Admin) ReapOrphans(ctx context.Context) (int, error) {
+	var resp *clientv3.GetResponse
+	err := withRetry(ctx, a.opts.opRetry, a.opts.requestTimeout, func(ctx context.Context) error {
+		var err error
+		resp, err = a.client.Get(ctx, "", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	byLease := make(map[clientv3.LeaseID][]string)
+	for _, kv := range resp.Kvs {
+		if _, ok := serviceNameFromKey(string(kv.Key)); !ok || kv.Lease == 0 {
+			continue
+		}
+		leaseID := clientv3.LeaseID(kv.Lease)
+		byLease[leaseID] = append(byLease[leaseID], string(kv.Key))
+	}
+	reaped := 0
+	for leaseID, keys := range byLease {
+		alive, err := a.leaseAlive(ctx, leaseID)
+		if err != nil {
+			return reaped, err
+		}
+		if alive {
+			continue
+		}
+		for _, key := range keys {
+			var deleted bool
+			err := withRetry(ctx, a.opts.opRetry, a.opts.requestTimeout, func(ctx context.Context) error {
+				txnResp, err := a.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.LeaseValue(key), "=", leaseID)).
+					Then(clientv3.OpDelete(key)).
+					Commit()
+				deleted = err == nil && txnResp.Succeeded && txnResp.Responses[0].GetResponseDeleteRange().Deleted > 0
+				return err
+			})
+			if err != nil {
+				return reaped, err
+			}
+			if deleted {
+				a.opts.logger.Infof("reaped orphan %s bound to expired lease %x", key, leaseID)
+				reaped++
+			}
+		}
+	}
+	return reaped, nil
+}
+
+// leaseAlive 查询租约是否仍然有效，etcd 对已经过期或不存在的租约返回 TTL -1
+func (a *Admin) leaseAlive(ctx context.Context, leaseID clientv3.LeaseID) (bool, error) {
+	var resp *clientv3.LeaseTimeToLiveResponse
+	err := withRetry(ctx, a.opts.opRetry, a.opts.requestTimeout, func(ctx context.Context) error {
+		var err error
+		resp, err = a.lease.TimeToLive(ctx, leaseID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.TTL > 0, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// expiringLease 把指定租约的剩余时间报告为 ttls 中的值，模拟租约已经过期但 key 还没有被清理
+type expiringLease struct {
+	clientv3.Lease
+	ttls map[clientv3.LeaseID]int64
+}
+
+func (l *expiringLease) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	if ttl, ok := l.ttls[id]; ok {
+		return &clientv3.LeaseTimeToLiveResponse{ID: id, TTL: ttl}, nil
+	}
+	return l.Lease.TimeToLive(ctx, id, opts...)
+}
+
+func TestAdminReapOrphans(t *testing.T) {
+	const name = "reap_orphan_service"
+	admin, err := NewEtcdAdmin([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd admin: %v", err)
+	}
+	// 先注册，最后执行：put 的清理需要客户端仍然打开
+	t.Cleanup(func() { admin.Close() })
+	cli := admin.client
+
+	// put 写入一个实例，ttl 大于 0 时绑定新租约，返回 key 和租约
+	put := func(ttl int64) (string, clientv3.LeaseID) {
+		key := newServiceKey(name)
+		var opts []clientv3.OpOption
+		leaseID := clientv3.NoLease
+		if ttl > 0 {
+			grant, err := cli.Grant(context.Background(), ttl)
+			if err != nil {
+				t.Fatalf("Failed to grant lease: %v", err)
+			}
+			t.Cleanup(func() { cli.Revoke(context.Background(), grant.ID) })
+			leaseID = grant.ID
+			opts = append(opts, clientv3.WithLease(leaseID))
+		}
+		if _, err := cli.Put(context.Background(), key, "localhost:9911", opts...); err != nil {
+			t.Fatalf("Failed to put instance: %v", err)
+		}
+		t.Cleanup(func() { cli.Delete(context.Background(), key) })
+		return key, leaseID
+	}
+	orphanKey, orphanLease := put(30)
+	lowKey, lowLease := put(30)
+	healthyKey, _ := put(30)
+	unleasedKey, _ := put(0)
+
+	// orphan 的租约已经过期，low 的租约只剩 1 秒但仍然有效
+	admin.lease = &expiringLease{Lease: admin.lease, ttls: map[clientv3.LeaseID]int64{orphanLease: -1, lowLease: 1}}
+	reaped, err := admin.ReapOrphans(context.Background())
+	if err != nil {
+		t.Fatalf("ReapOrphans failed: %v", err)
+	}
+	if reaped != 1 {
+		t.Errorf("ReapOrphans reaped %d keys, want 1", reaped)
+	}
+	for key, want := range map[string]bool{orphanKey: false, lowKey: true, healthyKey: true, unleasedKey: true} {
+		resp, err := cli.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		if exists := len(resp.Kvs) == 1; exists != want {
+			t.Errorf("%s exists = %v after ReapOrphans, want %v", key, exists, want)
+		}
+	}
+
+	// 已经清理过的孤儿不会重复计数
+	if reaped, err := admin.ReapOrphans(context.Background()); err != nil || reaped != 0 {
+		t.Errorf("second ReapOrphans = %d, %v, want 0", reaped, err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// LoadBalancer 从一个服务的实例中选择一个，instances 非空且按 key 排序
+// 同一个 DiscoveryEtcd 的全部查询共用一个 LoadBalancer，实现需要支持并发调用
+type LoadBalancer interface {
+	Pick(name string, instances []ServiceInstance) ServiceInstance
+}
+
+// RandomBalancer 均匀随机选择实例，是默认的策略
+type RandomBalancer struct{}
+
+func (RandomBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	// 随机返回一个服务地址
+	return instances[rand.Intn(len(instances))]
+}
+
+// RoundRobinBalancer 按服务名维护游标，依次轮流选择实例
+// 实例集合变化后游标继续递增，从对应位置接着轮转
+type RoundRobinBalancer struct {
+	mu      sync.Mutex
+	cursors map[string]int
+}
+
+// NewRoundRobinBalancer 创建轮询负载均衡器
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{cursors: make(map[string]int)}
+}
+
+func (b *RoundRobinBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	b.mu.Lock()
+	cursor := b.cursors[name]
+	b.cursors[name] = cursor + 1
+	b.mu.Unlock()
+	return instances[cursor%len(instances)]
+}
+
+// WeightedBalancer 按记录中的权重（见 ServiceRecord.Weight）成比例地随机选择实例
+// 纯字符串记录用 host:port|weight=3 登记权重，JSON 和 protobuf 记录写在元数据 weight 中
+type WeightedBalancer struct{}
+
+func (WeightedBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	total := 0
+	for _, inst := range instances {
+		total += inst.Weight()
+	}
+	n := rand.Intn(total)
+	for _, inst := range instances {
+		if n -= inst.Weight(); n < 0 {
+			return inst
+		}
+	}
+	return instances[len(instances)-1]
+}
+
+// SmoothWeightedBalancer 是 nginx 风格的平滑加权轮询：按服务名为每个实例维护当前权重，
+// 每次选择时各实例的当前权重加上自身权重（见 ServiceRecord.Weight），选出当前权重最大的实例后减去总权重
+// 权重 {5,1,1} 的实例按 a a b a c a a 的顺序选择，高权重实例的选择均匀分散而不是连续扎堆
+// 实例集合变化时新实例从 0 开始累加，已下线实例的状态被丢弃
+type SmoothWeightedBalancer struct {
+	mu      sync.Mutex
+	current map[string]map[string]int // 服务名 -> 实例 key -> 当前权重
+}
+
+// NewSmoothWeightedBalancer 创建平滑加权轮询负载均衡器
+func NewSmoothWeightedBalancer() *SmoothWeightedBalancer {
+	return &SmoothWeightedBalancer{current: make(map[string]map[string]int)}
+}
+
+func (b *SmoothWeightedBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev := b.current[name]
+	current := make(map[string]int, len(instances))
+	total, best := 0, 0
+	for i, inst := range instances {
+		w := inst.Weight()
+		total += w
+		current[inst.Key] = prev[inst.Key] + w
+		// 当前权重相同时选择靠前的实例，保证序列确定
+		if current[inst.Key] > current[instances[best].Key] {
+			best = i
+		}
+	}
+	current[instances[best].Key] -= total
+	b.current[name] = current
+	return instances[best]
+}
+
+// PreferNewestBalancer 以 Bias 的概率选择 CreateRevision 最大（最新注册）的实例，否则随机选择
+type PreferNewestBalancer struct {
+	Bias float64
+}
+
+func (b PreferNewestBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	return pickNewest(instances, b.Bias)
+}
+
+// pickNewest 以 bias 的概率选择 CreateRevision 最大（最新注册）的实例，否则随机选择
+// bias 为 1 时总是选择最新的实例，部署期间可以用来让旧实例逐渐排空
+func pickNewest(instances []ServiceInstance, bias float64) ServiceInstance {
+	if rand.Float64() >= bias {
+		return instances[rand.Intn(len(instances))]
+	}
+	newest := instances[0]
+	for _, inst := range instances[1:] {
+		if inst.CreateRevision > newest.CreateRevision {
+			newest = inst
+		}
+	}
+	return newest
+}
+
+// LeastLoadedBalancer 随机取两个实例，选择其中负载（见 ServiceRecord.Load）较低的一个
+// 负载由各实例通过 RegistryEtcd.UpdateLoad 上报，没有上报的实例按 0 计算
+// 只比较两个而不是全局最低，避免全部请求同时涌向同一个刚上报低负载的实例
+type LeastLoadedBalancer struct{}
+
+func (LeastLoadedBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	if len(instances) == 1 {
+		return instances[0]
+	}
+	i := rand.Intn(len(instances))
+	j := rand.Intn(len(instances) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := instances[i], instances[j]
+	if b.Load() < a.Load() {
+		return b
+	}
+	return a
+}
+
+// TieredBalancer 只在层级（见 ServiceRecord.Tier）最小的实例中选择，例如优先使用主区域，
+// 主区域没有可用实例时才回退到备用区域；同层的实例交给 Next 选择，Next 为 nil 时随机选择
+// 传入的实例已经过健康探测和排空过滤，因此主层实例全部不健康时也会回退
+type TieredBalancer struct {
+	Next LoadBalancer
+}
+
+func (b TieredBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	preferred := instances[0].Tier()
+	for _, inst := range instances[1:] {
+		preferred = min(preferred, inst.Tier())
+	}
+	tier := make([]ServiceInstance, 0, len(instances))
+	for _, inst := range instances {
+		if inst.Tier() == preferred {
+			tier = append(tier, inst)
+		}
+	}
+	next := b.Next
+	if next == nil {
+		next = RandomBalancer{}
+	}
+	return next.Pick(name, tier)
+}
+
+// KeyedBalancer 按路由 key 选择实例，同一个 key 在实例集合不变时总是落到同一个实例
+type KeyedBalancer interface {
+	PickKey(name, key string, instances []ServiceInstance) ServiceInstance
+}
+
+// DefaultHashReplicas 是 ConsistentHashBalancer 默认的每实例虚拟节点数
+const DefaultHashReplicas = 100
+
+// ConsistentHashBalancer 把实例按 key 放到哈希环上，路由 key 落到顺时针方向的第一个虚拟节点
+// 实例增减时只有落在变化区间内的 key 会改变归属，适合需要缓存亲和的场景
+// 不带 key 的 Pick 退化为随机选择
+type ConsistentHashBalancer struct {
+	// Replicas 是每个实例的虚拟节点数，越大分布越均匀，<= 0 时使用 DefaultHashReplicas
+	Replicas int
+
+	mu    sync.Mutex
+	rings map[string]*hashRing
+}
+
+// NewConsistentHashBalancer 创建每实例 replicas 个虚拟节点的一致性哈希负载均衡器
+func NewConsistentHashBalancer(replicas int) *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{Replicas: replicas}
+}
+
+func (b *ConsistentHashBalancer) Pick(name string, instances []ServiceInstance) ServiceInstance {
+	return RandomBalancer{}.Pick(name, instances)
+}
+
+func (b *ConsistentHashBalancer) PickKey(name, key string, instances []ServiceInstance) ServiceInstance {
+	return b.ring(name, instances).lookup(key)
+}
+
+// ring 返回服务的哈希环，实例集合没有变化时复用上次构建的环
+func (b *ConsistentHashBalancer) ring(name string, instances []ServiceInstance) *hashRing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r, ok := b.rings[name]; ok && r.matches(instances) {
+		return r
+	}
+	replicas := b.Replicas
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	r := newHashRing(instances, replicas)
+	if b.rings == nil {
+		b.rings = make(map[string]*hashRing)
+	}
+	b.rings[name] = r
+	return r
+}
+
+// hashRing 是按哈希值排序的虚拟节点，节点指向 instances 中的下标
+type hashRing struct {
+	instances []ServiceInstance
+	hashes    []uint32
+	owners    []int
+}
+
+func newHashRing(instances []ServiceInstance, replicas int) *hashRing {
+	r := &hashRing{instances: append([]ServiceInstance(nil), instances...)}
+	type vnode struct {
+		hash  uint32
+		owner int
+	}
+	nodes := make([]vnode, 0, len(instances)*replicas)
+	for i, inst := range instances {
+		// 用实例的 key 而不是地址定位虚拟节点，同一地址重新注册后位置不变
+		for j := 0; j < replicas; j++ {
+			nodes = append(nodes, vnode{crc32.ChecksumIEEE([]byte(strconv.Itoa(j) + "#" + inst.Key)), i})
+		}
+	}
+	sort.Slice(nodes, func(a, b int) bool { return nodes[a].hash < nodes[b].hash })
+	r.hashes = make([]uint32, len(nodes))
+	r.owners = make([]int, len(nodes))
+	for i, n := range nodes {
+		r.hashes[i], r.owners[i] = n.hash, n.owner
+	}
+	return r
+}
+
+// lookup 返回顺时针方向第一个哈希值不小于 key 哈希的虚拟节点所属的实例
+func (r *hashRing) lookup(key string) ServiceInstance {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.instances[r.owners[i]]
+}
+
+// matches 判断环是否由同一组实例构建
+func (r *hashRing) matches(instances []ServiceInstance) bool {
+	return sameInstances(r.instances, instances)
+}
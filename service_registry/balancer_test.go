@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestPickNewest(t *testing.T) {
+	instances := []ServiceInstance{
+		{ServiceRecord: ServiceRecord{Addr: "localhost:9301"}, CreateRevision: 10},
+		{ServiceRecord: ServiceRecord{Addr: "localhost:9302"}, CreateRevision: 20},
+	}
+	for i := 0; i < 100; i++ {
+		if got := pickNewest(instances, 1); got.Addr != "localhost:9302" {
+			t.Fatalf("bias 1 picked %s, want the newest instance", got.Addr)
+		}
+	}
+	// bias 0.5 时最新实例被选中的概率为 0.5 + 0.5/2 = 0.75
+	const rounds = 4000
+	newest := 0
+	for i := 0; i < rounds; i++ {
+		if pickNewest(instances, 0.5).Addr == "localhost:9302" {
+			newest++
+		}
+	}
+	if ratio := float64(newest) / rounds; ratio < 0.7 || ratio > 0.8 {
+		t.Errorf("bias 0.5 picked newest %.2f of the time, want about 0.75", ratio)
+	}
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	instances := []ServiceInstance{
+		{ServiceRecord: ServiceRecord{Addr: "localhost:9501"}, Key: "rr-a"},
+		{ServiceRecord: ServiceRecord{Addr: "localhost:9502"}, Key: "rr-b"},
+		{ServiceRecord: ServiceRecord{Addr: "localhost:9503"}, Key: "rr-c"},
+	}
+	b := NewRoundRobinBalancer()
+	want := []string{"localhost:9501", "localhost:9502", "localhost:9503", "localhost:9501"}
+	for i, w := range want {
+		if got := b.Pick("rr", instances).Addr; got != w {
+			t.Errorf("pick %d = %s, want %s", i, got, w)
+		}
+	}
+	// 不同服务名的游标互相独立
+	if got := b.Pick("other", instances).Addr; got != "localhost:9501" {
+		t.Errorf("first pick for another service = %s, want localhost:9501", got)
+	}
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	instances := []ServiceInstance{
+		{ServiceRecord: decodePlainRecord("localhost:9511|weight=3")},
+		{ServiceRecord: decodePlainRecord("localhost:9512")},
+	}
+	const rounds = 4000
+	heavy := 0
+	for i := 0; i < rounds; i++ {
+		if (WeightedBalancer{}).Pick("weighted", instances).Addr == "localhost:9511" {
+			heavy++
+		}
+	}
+	if ratio := float64(heavy) / rounds; ratio < 0.7 || ratio > 0.8 {
+		t.Errorf("weight 3:1 picked heavy instance %.2f of the time, want about 0.75", ratio)
+	}
+}
+
+func TestSmoothWeightedBalancer(t *testing.T) {
+	instances := []ServiceInstance{
+		{ServiceRecord: decodePlainRecord("localhost:9531|weight=5"), Key: "swrr-a"},
+		{ServiceRecord: decodePlainRecord("localhost:9532"), Key: "swrr-b"},
+		{ServiceRecord: decodePlainRecord("localhost:9533"), Key: "swrr-c"},
+	}
+	b := NewSmoothWeightedBalancer()
+	// nginx 平滑加权轮询在权重 {5,1,1} 下的一个完整周期，第二个周期与第一个相同
+	pattern := []string{"localhost:9531", "localhost:9531", "localhost:9532", "localhost:9531", "localhost:9533", "localhost:9531", "localhost:9531"}
+	for round := 0; round < 2; round++ {
+		for i, want := range pattern {
+			if got := b.Pick("swrr", instances).Addr; got != want {
+				t.Errorf("round %d pick %d = %s, want %s", round, i, got, want)
+			}
+		}
+	}
+
+	// 下线的实例不再被选择，剩下的实例继续按权重轮转
+	for i := 0; i < 6; i++ {
+		if got := b.Pick("swrr", instances[1:]).Addr; got != instances[1+i%2].Addr {
+			t.Errorf("pick %d after removal = %s, want %s", i, got, instances[1+i%2].Addr)
+		}
+	}
+}
+
+func TestDiscoveryRoundRobin(t *testing.T) {
+	const name = "round_robin_service"
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	for _, kv := range [][2]string{{name + "/a", "localhost:9521|weight=2"}, {name + "/b", "localhost:9522"}} {
+		if _, err := cli.Put(context.Background(), kv[0], kv[1]); err != nil {
+			t.Fatalf("Failed to put record: %v", err)
+		}
+		defer cli.Delete(context.Background(), kv[0])
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithBalancer(NewRoundRobinBalancer()))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	for i, want := range []string{"localhost:9521", "localhost:9522", "localhost:9521"} {
+		addr, err := discovery.GetServiceAddr(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Failed to get service address: %v", err)
+		}
+		if addr != want {
+			t.Errorf("pick %d = %s, want %s", i, addr, want)
+		}
+	}
+}
+
+func TestConsistentHashRedistribution(t *testing.T) {
+	var instances []ServiceInstance
+	for i := 0; i < 5; i++ {
+		instances = append(instances, ServiceInstance{
+			ServiceRecord: ServiceRecord{Addr: fmt.Sprintf("localhost:%d", 9531+i)},
+			Key:           fmt.Sprintf("hash-%d", i),
+		})
+	}
+	b := NewConsistentHashBalancer(100)
+	const keys = 10000
+	before := make([]string, keys)
+	counts := make(map[string]int)
+	for i := range before {
+		before[i] = b.PickKey("hash", fmt.Sprintf("user-%d", i), instances).Addr
+		counts[before[i]]++
+	}
+	// 100 个虚拟节点时每个实例分到的 key 不应偏离平均值太多
+	for addr, n := range counts {
+		if n < keys/5/2 || n > keys/5*2 {
+			t.Errorf("%s owns %d of %d keys, distribution too skewed", addr, n, keys)
+		}
+	}
+
+	removed := instances[2].Addr
+	remaining := append(append([]ServiceInstance(nil), instances[:2]...), instances[3:]...)
+	moved := 0
+	for i, prev := range before {
+		addr := b.PickKey("hash", fmt.Sprintf("user-%d", i), remaining).Addr
+		if addr == prev {
+			continue
+		}
+		moved++
+		// 只有原本落在被移除实例上的 key 可以改变归属
+		if prev != removed {
+			t.Fatalf("key user-%d moved from %s to %s although %s is still present", i, prev, addr, prev)
+		}
+	}
+	if moved != counts[removed] {
+		t.Errorf("moved %d keys, want exactly the %d keys owned by %s", moved, counts[removed], removed)
+	}
+	t.Logf("removing one of 5 instances moved %.1f%% of keys", float64(moved)*100/keys)
+}
+
+func TestDiscoveryServiceAddrForKey(t *testing.T) {
+	const name = "consistent_hash_service"
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	for _, kv := range [][2]string{{name + "/a", "localhost:9541"}, {name + "/b", "localhost:9542"}, {name + "/c", "localhost:9543"}} {
+		if _, err := cli.Put(context.Background(), kv[0], kv[1]); err != nil {
+			t.Fatalf("Failed to put record: %v", err)
+		}
+		defer cli.Delete(context.Background(), kv[0])
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	for _, key := range []string{"user-1", "user-2", "user-3"} {
+		first, err := discovery.GetServiceAddrForKey(context.Background(), name, key)
+		if err != nil {
+			t.Fatalf("Failed to get service address: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			if addr, _ := discovery.GetServiceAddrForKey(context.Background(), name, key); addr != first {
+				t.Errorf("key %s routed to %s, previously %s", key, addr, first)
+			}
+		}
+	}
+}
+
+func TestLeastLoadedBalancerUpdateLoad(t *testing.T) {
+	const name = "least_loaded_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	services := []Service{
+		&OrderService{name: name, addr: "localhost:9541"},
+		&OrderService{name: name, addr: "localhost:9542"},
+		metadataService{&OrderService{name: name, addr: "localhost:9543"}, map[string]string{"zone": "a"}},
+	}
+	keys := make(map[string]string)
+	for _, s := range services {
+		key, err := registry.Registry(context.Background(), s)
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", s.Addr(), err)
+		}
+		keys[s.Addr()] = key
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithBalancer(LeastLoadedBalancer{}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	const rounds = 300
+	share := func(addr string) float64 {
+		n := 0
+		for i := 0; i < rounds; i++ {
+			got, err := discovery.GetServiceAddr(context.Background(), name)
+			if err != nil {
+				t.Fatalf("Failed to get service address: %v", err)
+			}
+			if got == addr {
+				n++
+			}
+		}
+		return float64(n) / rounds
+	}
+	if s := share("localhost:9541"); s < 0.2 || s > 0.5 {
+		t.Errorf("without load reports localhost:9541 got %.2f of picks, want about 1/3", s)
+	}
+
+	for addr, load := range map[string]float64{"localhost:9541": 0.9, "localhost:9542": 0.1, "localhost:9543": 0.2} {
+		if err := registry.UpdateLoad(context.Background(), keys[addr], load); err != nil {
+			t.Fatalf("Failed to update load of %s: %v", addr, err)
+		}
+	}
+	// 两两比较时负载最高的实例总是落选
+	if s := share("localhost:9541"); s != 0 {
+		t.Errorf("overloaded localhost:9541 got %.2f of picks, want 0", s)
+	}
+
+	resp, err := discovery.client.Get(context.Background(), keys["localhost:9541"])
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("Failed to get plain record: %v", err)
+	}
+	if got := string(resp.Kvs[0].Value); got != "localhost:9541|load=0.9" {
+		t.Errorf("plain record = %q, want localhost:9541|load=0.9", got)
+	}
+	if ttl, err := registry.ServiceTimeToLive(context.Background(), keys["localhost:9541"]); err != nil || ttl <= 0 {
+		t.Errorf("updated record lost its lease: ttl %d, %v", ttl, err)
+	}
+	instances, err := discovery.GetServiceInstances(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	for _, inst := range instances {
+		if inst.Addr == "localhost:9543" && (inst.Load() != 0.2 || inst.Metadata["zone"] != "a") {
+			t.Errorf("json record metadata = %v, want load 0.2 and zone kept", inst.Metadata)
+		}
+	}
+	if err := registry.UpdateLoad(context.Background(), keys["localhost:9542"], -1); err == nil {
+		t.Errorf("Expected error for negative load")
+	}
+}
+
+func TestTieredBalancer(t *testing.T) {
+	instances := []ServiceInstance{
+		{ServiceRecord: decodePlainRecord("localhost:9551|tier=1")},
+		{ServiceRecord: decodePlainRecord("localhost:9552")},
+		{ServiceRecord: decodePlainRecord("localhost:9553|tier=2")},
+		{ServiceRecord: decodePlainRecord("localhost:9554|tier=0")},
+	}
+	b := TieredBalancer{Next: NewRoundRobinBalancer()}
+	// 主层的两个实例轮流被选中，备用层不参与
+	for i, want := range []string{"localhost:9552", "localhost:9554", "localhost:9552"} {
+		if got := b.Pick("tiered", instances).Addr; got != want {
+			t.Errorf("pick %d = %s, want %s", i, got, want)
+		}
+	}
+	// 主层没有实例时回退到下一层
+	for i := 0; i < 10; i++ {
+		if got := (TieredBalancer{}).Pick("tiered", []ServiceInstance{instances[0], instances[2]}).Addr; got != "localhost:9551" {
+			t.Fatalf("Expected fallback to tier 1, got %s", got)
+		}
+	}
+}
+
+func TestDiscoveryTieredFailover(t *testing.T) {
+	const name = "tiered_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	primary, err := registry.Registry(context.Background(), metadataService{&OrderService{name: name, addr: "localhost:9561"}, map[string]string{MetadataTier: "0"}})
+	if err != nil {
+		t.Fatalf("Failed to register primary: %v", err)
+	}
+	if _, err := registry.Registry(context.Background(), metadataService{&OrderService{name: name, addr: "localhost:9562"}, map[string]string{MetadataTier: "1"}}); err != nil {
+		t.Fatalf("Failed to register secondary: %v", err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithBalancer(TieredBalancer{}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	pickAll := func(want string) {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			addr, err := discovery.GetServiceAddr(context.Background(), name)
+			if err != nil {
+				t.Fatalf("Failed to get service address: %v", err)
+			}
+			if addr != want {
+				t.Fatalf("Got %s, want %s", addr, want)
+			}
+		}
+	}
+	pickAll("localhost:9561")
+
+	if err := registry.DeRegistryService(context.Background(), primary); err != nil {
+		t.Fatalf("Failed to deregister primary: %v", err)
+	}
+	pickAll("localhost:9562")
+
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if _, err := discovery.GetServiceAddr(context.Background(), name); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Expected ErrServiceNotFound with no instances, got %v", err)
+	}
+}
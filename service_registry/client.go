@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
+)
+
+// ErrEmptyEndpoints 表示构造注册端或发现端时没有提供 etcd endpoint
+var ErrEmptyEndpoints = errors.New("etcd endpoints cannot be empty")
+
+// defaultEagerConnectTimeout 是 dialTimeout 为 0 时 WithEagerConnect 等待 endpoint 响应的时间
+const defaultEagerConnectTimeout = 5 * time.Second
+
+// gRPC keepalive 的默认值，见 WithDialKeepAlive
+const (
+	DefaultDialKeepAliveTime    = 30 * time.Second
+	DefaultDialKeepAliveTimeout = 10 * time.Second
+)
+
+// ping 并发地对客户端的每个 endpoint 发送 Status 请求，任意一个 endpoint 响应时认为集群可用
+// 全部失败时返回的错误列出每个 endpoint 及其失败原因
+func ping(ctx context.Context, client *clientv3.Client) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	endpoints := client.Endpoints()
+	results := make(chan error, len(endpoints))
+	for _, ep := range endpoints {
+		go func() {
+			if _, err := client.Status(ctx, ep); err != nil {
+				results <- fmt.Errorf("etcd endpoint %s: %w", ep, err)
+				return
+			}
+			results <- nil
+		}()
+	}
+	var errs []error
+	for range endpoints {
+		err := <-results
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("etcd cluster unreachable: %w", errors.Join(errs...))
+}
+
+// EndpointHealth 是 ClusterHealth 返回的单个 etcd endpoint 的状态
+type EndpointHealth struct {
+	Endpoint string
+	// Healthy 表示 endpoint 响应了 Status 请求，为 false 时 Err 说明原因，其余字段为零值
+	Healthy bool
+	// Leader 表示该 endpoint 对应的成员是当前的 leader
+	Leader bool
+	// DBSize 是该成员后端数据库的大小（字节）
+	DBSize int64
+	Err    error
+}
+
+// clusterHealth 并发地对客户端的每个 endpoint 发送 Status 请求，结果按 endpoint 的顺序返回
+// 部分 endpoint 失败时仍返回全部结果，只有全部失败时才返回错误
+func clusterHealth(ctx context.Context, client *clientv3.Client) ([]EndpointHealth, error) {
+	endpoints := client.Endpoints()
+	health := make([]EndpointHealth, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health[i].Endpoint = ep
+			resp, err := client.Status(ctx, ep)
+			if err != nil {
+				health[i].Err = err
+				return
+			}
+			health[i].Healthy = true
+			health[i].Leader = resp.Header.MemberId == resp.Leader
+			health[i].DBSize = resp.DbSize
+		}()
+	}
+	wg.Wait()
+	var errs []error
+	for _, h := range health {
+		if h.Healthy {
+			return health, nil
+		}
+		errs = append(errs, fmt.Errorf("etcd endpoint %s: %w", h.Endpoint, h.Err))
+	}
+	return health, fmt.Errorf("etcd cluster unreachable: %w", errors.Join(errs...))
+}
+
+// newClient 按选项中的连接、TLS 和认证配置创建 etcd 客户端，注册端和发现端共用
+func newClient(endpoints []string, dialTimeout time.Duration, o options) (*clientv3.Client, error) {
+	cfg, err := clientConfig(endpoints, dialTimeout, o)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	applyNamespace(cli, o.namespace)
+	if o.eagerConnect {
+		timeout := dialTimeout
+		if timeout <= 0 {
+			timeout = defaultEagerConnectTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := ping(ctx, cli); err != nil {
+			cli.Close()
+			return nil, err
+		}
+	}
+	return cli, nil
+}
+
+// applyNamespace 用 etcd 的 namespace 代理包装客户端的 KV、Watcher 和 Lease，
+// 之后经过客户端的读写、事务和 Watch 都限定在 prefix 下，prefix 为空时什么也不做
+func applyNamespace(cli *clientv3.Client, prefix string) {
+	if prefix == "" {
+		return
+	}
+	cli.KV = namespace.NewKV(cli.KV, prefix)
+	cli.Watcher = namespace.NewWatcher(cli.Watcher, prefix)
+	cli.Lease = namespace.NewLease(cli.Lease, prefix)
+}
+
+// clientConfig 把选项转换为 clientv3.Config
+func clientConfig(endpoints []string, dialTimeout time.Duration, o options) (clientv3.Config, error) {
+	cfg := clientv3.Config{
+		Endpoints:            endpoints,
+		DialTimeout:          dialTimeout,
+		DialKeepAliveTime:    o.dialKeepAliveTime,
+		DialKeepAliveTimeout: o.dialKeepAliveTimeout,
+		AutoSyncInterval:     o.autoSyncInterval,
+		Username:             o.username,
+		Password:             o.password,
+	}
+	if o.tlsCertFile != "" || o.tlsKeyFile != "" || o.tlsCAFile != "" {
+		tlsCfg, err := loadTLSConfig(o.tlsCertFile, o.tlsKeyFile, o.tlsCAFile)
+		if err != nil {
+			return clientv3.Config{}, err
+		}
+		cfg.TLS = tlsCfg
+	}
+	return cfg, nil
+}
+
+// loadTLSConfig 加载客户端证书和 CA 证书，certFile 和 keyFile 为空时只校验服务端证书
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load etcd client certificate %s / %s: %w", certFile, keyFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("load etcd ca certificate %s: %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("load etcd ca certificate %s: no PEM certificate found", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
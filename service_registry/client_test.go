@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTLSMissingCertificate(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "client.pem")
+	_, err := NewEtcdRegistry([]string{"localhost:2379"}, time.Second, LeaseTTL, WithTLS(missing, missing, ""))
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected error naming %s, got %v", missing, err)
+	}
+}
+
+func TestWithTLSInvalidCA(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write ca file: %v", err)
+	}
+	_, err := NewEtcdDiscovery([]string{"localhost:2379"}, time.Second, WithTLS("", "", ca))
+	if err == nil || !strings.Contains(err.Error(), ca) {
+		t.Errorf("Expected error naming %s, got %v", ca, err)
+	}
+}
+
+func TestWithAuthConfig(t *testing.T) {
+	o := newOptions([]Option{WithAuth("root", "secret")})
+	cli, err := newClient([]string{"localhost:2379"}, time.Second, o)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer cli.Close()
+	if cli.Username != "root" || cli.Password != "secret" {
+		t.Errorf("Credentials not passed to client config")
+	}
+}
+
+func TestDialKeepAliveConfig(t *testing.T) {
+	cfg, err := clientConfig([]string{"localhost:2379"}, time.Second, newOptions(nil))
+	if err != nil {
+		t.Fatalf("Failed to build client config: %v", err)
+	}
+	if cfg.DialKeepAliveTime != DefaultDialKeepAliveTime || cfg.DialKeepAliveTimeout != DefaultDialKeepAliveTimeout {
+		t.Errorf("Expected default keepalive, got %v / %v", cfg.DialKeepAliveTime, cfg.DialKeepAliveTimeout)
+	}
+	if cfg.AutoSyncInterval != 0 {
+		t.Errorf("Expected auto sync to be disabled by default, got %v", cfg.AutoSyncInterval)
+	}
+
+	o := newOptions([]Option{WithDialKeepAlive(10*time.Second, 3*time.Second), WithAutoSyncInterval(time.Minute)})
+	cfg, err = clientConfig([]string{"localhost:2379"}, time.Second, o)
+	if err != nil {
+		t.Fatalf("Failed to build client config: %v", err)
+	}
+	if cfg.DialKeepAliveTime != 10*time.Second || cfg.DialKeepAliveTimeout != 3*time.Second {
+		t.Errorf("Keepalive not passed to client config: %v / %v", cfg.DialKeepAliveTime, cfg.DialKeepAliveTimeout)
+	}
+	if cfg.AutoSyncInterval != time.Minute {
+		t.Errorf("Auto sync interval not passed to client config: %v", cfg.AutoSyncInterval)
+	}
+
+	// 两个构造函数都能在这些选项下正常工作
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, time.Second, LeaseTTL, WithDialKeepAlive(10*time.Second, 3*time.Second), WithAutoSyncInterval(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, time.Second, WithDialKeepAlive(10*time.Second, 3*time.Second), WithAutoSyncInterval(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	if err := discovery.Ping(context.Background()); err != nil {
+		t.Errorf("Ping failed with keepalive configured: %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	if err := registry.Ping(context.Background()); err != nil {
+		t.Errorf("Ping failed against a running etcd: %v", err)
+	}
+
+	// 一个 endpoint 不可达时，其余 endpoint 仍能响应
+	discovery, err := NewEtcdDiscovery([]string{"localhost:1", "localhost:2379"}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := discovery.Ping(ctx); err != nil {
+		t.Errorf("Ping failed with one reachable endpoint: %v", err)
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	discovery, err := NewEtcdDiscovery([]string{"localhost:1"}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = discovery.Ping(ctx)
+	if err == nil || !strings.Contains(err.Error(), "localhost:1") {
+		t.Fatalf("Expected error naming localhost:1, got %v", err)
+	}
+	t.Logf("Ping error: %v", err)
+}
+
+func TestWithEagerConnect(t *testing.T) {
+	// 一个坏 endpoint 加一个好 endpoint，构造成功
+	registry, err := NewEtcdRegistry([]string{"localhost:1", "localhost:2379"}, time.Second, LeaseTTL, WithEagerConnect())
+	if err != nil {
+		t.Fatalf("Expected eager connect to succeed with one reachable endpoint, got %v", err)
+	}
+	registry.Close()
+
+	// 全部不可达时构造函数在 dialTimeout 内失败，错误列出每个 endpoint
+	start := time.Now()
+	_, err = NewEtcdDiscovery([]string{"localhost:1", "localhost:2"}, 500*time.Millisecond, WithEagerConnect())
+	if err == nil {
+		t.Fatalf("Expected eager connect to fail with no reachable endpoint")
+	}
+	if !strings.Contains(err.Error(), "localhost:1") || !strings.Contains(err.Error(), "localhost:2") {
+		t.Errorf("Expected error naming both endpoints, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Eager connect took %v, want about the dial timeout", elapsed)
+	}
+}
+
+func TestClusterHealth(t *testing.T) {
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	health, err := discovery.ClusterHealth(context.Background())
+	if err != nil {
+		t.Fatalf("ClusterHealth failed: %v", err)
+	}
+	if len(health) != 1 {
+		t.Fatalf("Expected one endpoint, got %v", health)
+	}
+	if h := health[0]; !h.Healthy || !h.Leader || h.DBSize <= 0 || h.Err != nil {
+		t.Errorf("Expected a healthy leader, got %+v", h)
+	}
+
+	// 不可达的 endpoint 单独报告，不影响其余结果
+	discovery, err = NewEtcdDiscovery([]string{"localhost:1", "localhost:2379"}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	health, err = discovery.ClusterHealth(ctx)
+	if err != nil {
+		t.Fatalf("ClusterHealth failed with one reachable endpoint: %v", err)
+	}
+	if len(health) != 2 || health[0].Healthy || health[0].Err == nil || !health[1].Healthy {
+		t.Errorf("Expected only localhost:2379 to be healthy, got %+v", health)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+// Codec 决定注册端写入 etcd 的 value 和发现端如何把 value 还原为实例，两端需要使用同一个 Codec
+//
+// Codec 建立在带格式标识的记录（见 EncodeRecord、DecodeRecord）之上：内置的 StringCodec、JSONCodec、ProtoCodec
+// 只是选择写入哪种格式，解码时都交给 DecodeRecord 按记录自带的标识处理，所以它们之间可以混用、滚动切换。
+// WithRecordFormat 等价于 WithCodec 对应的内置 Codec。自定义 Codec 完全接管 value 的格式，
+// 不再和其他格式兼容，适合需要写入自有结构的场景
+type Codec interface {
+	// Encode 编码注册端要写入的实例；Service 实现 MetadataAware 时元数据已经合并了注册选项添加的内容
+	Encode(Service) ([]byte, error)
+	// Decode 还原实例的地址和元数据，Key 和 CreateRevision 由发现端填充
+	Decode([]byte) (ServiceInstance, error)
+}
+
+// StringCodec 是默认的 Codec：没有元数据时写纯地址，有元数据时自动改用 JSON 记录
+type StringCodec struct{}
+
+func (StringCodec) Encode(s Service) ([]byte, error) {
+	rec := recordOf(s)
+	if len(rec.Metadata) > 0 {
+		return EncodeRecord(FormatJSON, rec)
+	}
+	return EncodeRecord(FormatPlain, rec)
+}
+
+func (StringCodec) Decode(data []byte) (ServiceInstance, error) {
+	return decodeInstance(data)
+}
+
+// JSONCodec 总是写带格式标识的 JSON 记录
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(s Service) ([]byte, error) {
+	return EncodeRecord(FormatJSON, recordOf(s))
+}
+
+func (JSONCodec) Decode(data []byte) (ServiceInstance, error) {
+	return decodeInstance(data)
+}
+
+// ProtoCodec 总是写带格式标识的 protobuf 记录
+type ProtoCodec struct{}
+
+func (ProtoCodec) Encode(s Service) ([]byte, error) {
+	return EncodeRecord(FormatProto, recordOf(s))
+}
+
+func (ProtoCodec) Decode(data []byte) (ServiceInstance, error) {
+	return decodeInstance(data)
+}
+
+// unknownFormatCodec 是 WithRecordFormat 收到未知格式时使用的 Codec，注册时返回错误
+type unknownFormatCodec RecordFormat
+
+func (c unknownFormatCodec) Encode(s Service) ([]byte, error) {
+	return EncodeRecord(RecordFormat(c), recordOf(s))
+}
+
+func (unknownFormatCodec) Decode(data []byte) (ServiceInstance, error) {
+	return decodeInstance(data)
+}
+
+// codecForFormat 返回写入 format 格式的内置 Codec
+func codecForFormat(format RecordFormat) Codec {
+	switch format {
+	case FormatPlain:
+		return StringCodec{}
+	case FormatJSON:
+		return JSONCodec{}
+	case FormatProto:
+		return ProtoCodec{}
+	default:
+		return unknownFormatCodec(format)
+	}
+}
+
+// isBuiltinCodec 判断 Codec 是否写入带格式标识的记录
+func isBuiltinCodec(c Codec) bool {
+	switch c.(type) {
+	case StringCodec, JSONCodec, ProtoCodec:
+		return true
+	}
+	return false
+}
+
+func decodeInstance(data []byte) (ServiceInstance, error) {
+	rec, err := DecodeRecord(data)
+	return ServiceInstance{ServiceRecord: rec}, err
+}
+
+// recordOf 取出服务的地址和元数据
+func recordOf(s Service) ServiceRecord {
+	rec := ServiceRecord{Addr: s.Addr()}
+	if m, ok := s.(MetadataAware); ok {
+		rec.Metadata = m.Metadata()
+	}
+	return rec
+}
+
+// recordService 把注册端合并好的记录（服务自身的元数据加上健康检查地址等）作为 Service 交给 Codec
+type recordService struct {
+	name string
+	rec  ServiceRecord
+}
+
+func (s recordService) Name() string                { return s.name }
+func (s recordService) Addr() string                { return s.rec.Addr }
+func (s recordService) Metadata() map[string]string { return s.rec.Metadata }
+
+// decode 用配置的 Codec 解码记录并按 WithAddrRewrite 改写地址，发现端的查询、缓存和 Watch 共用
+func (o options) decode(data []byte) (ServiceRecord, error) {
+	inst, err := o.codec.Decode(data)
+	if err != nil {
+		return ServiceRecord{}, err
+	}
+	if o.addrRewrite != nil {
+		inst.Addr = o.addrRewrite(inst.Addr)
+	}
+	return inst.ServiceRecord, nil
+}
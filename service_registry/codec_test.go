@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	meta := map[string]string{"version": "v3", "zone": "b"}
+	cases := []struct {
+		name    string
+		codec   Codec
+		service Service
+		want    ServiceRecord
+		prefix  byte
+	}{
+		{"string", StringCodec{}, &OrderService{name: "codec", addr: "10.0.0.1:8080"}, ServiceRecord{Addr: "10.0.0.1:8080"}, '1'},
+		{"string with metadata", StringCodec{}, metadataService{&OrderService{name: "codec", addr: "10.0.0.2:8080"}, meta}, ServiceRecord{Addr: "10.0.0.2:8080", Metadata: meta}, byte(FormatJSON)},
+		{"json", JSONCodec{}, &OrderService{name: "codec", addr: "10.0.0.3:8080"}, ServiceRecord{Addr: "10.0.0.3:8080"}, byte(FormatJSON)},
+		{"json with metadata", JSONCodec{}, metadataService{&OrderService{name: "codec", addr: "10.0.0.4:8080"}, meta}, ServiceRecord{Addr: "10.0.0.4:8080", Metadata: meta}, byte(FormatJSON)},
+	}
+	for _, c := range cases {
+		data, err := c.codec.Encode(c.service)
+		if err != nil {
+			t.Fatalf("%s: encode failed: %v", c.name, err)
+		}
+		if data[0] != c.prefix {
+			t.Errorf("%s: encoded record starts with %#x, want %#x", c.name, data[0], c.prefix)
+		}
+		inst, err := c.codec.Decode(data)
+		if err != nil {
+			t.Fatalf("%s: decode failed: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(inst.ServiceRecord, c.want) {
+			t.Errorf("%s: round trip gave %+v, want %+v", c.name, inst.ServiceRecord, c.want)
+		}
+	}
+	// 内置 Codec 之间可以互相解码
+	data, _ := JSONCodec{}.Encode(metadataService{&OrderService{name: "codec", addr: "10.0.0.5:8080"}, meta})
+	if inst, err := (StringCodec{}).Decode(data); err != nil || inst.Addr != "10.0.0.5:8080" {
+		t.Errorf("StringCodec failed to decode a JSON record: %+v, %v", inst, err)
+	}
+}
+
+// pipeCodec 是自定义 Codec：value 为 addr;zone，不使用格式标识
+type pipeCodec struct{}
+
+func (pipeCodec) Encode(s Service) ([]byte, error) {
+	zone := ""
+	if m, ok := s.(MetadataAware); ok {
+		zone = m.Metadata()["zone"]
+	}
+	return []byte(s.Addr() + ";" + zone), nil
+}
+
+func (pipeCodec) Decode(data []byte) (ServiceInstance, error) {
+	addr, zone, ok := strings.Cut(string(data), ";")
+	if !ok {
+		return ServiceInstance{}, errors.New("missing zone separator")
+	}
+	return ServiceInstance{ServiceRecord: ServiceRecord{Addr: addr, Metadata: map[string]string{"zone": zone}}}, nil
+}
+
+func TestWithCodec(t *testing.T) {
+	const name = "custom_codec_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithCodec(pipeCodec{}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	key, err := registry.Registry(context.Background(), metadataService{&OrderService{name: name, addr: "localhost:9551"}, map[string]string{"zone": "c"}})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	resp, err := registry.client.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "localhost:9551;c" {
+		t.Fatalf("Expected value written by the codec, got %v, %v", resp, err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithCodec(pipeCodec{}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	instances, err := discovery.GetServiceInstances(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(instances) != 1 || instances[0].Addr != "localhost:9551" || instances[0].Metadata["zone"] != "c" || instances[0].Key != key {
+		t.Errorf("Unexpected instances %+v", instances)
+	}
+}
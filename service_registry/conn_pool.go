@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrConnPoolClosed 表示连接池已经关闭
+var ErrConnPoolClosed = errors.New("connection pool closed")
+
+// ConnPool 按发现到的地址缓存连接：第一次 GetConn 时用 dial 建立连接，之后复用
+// 连接类型由调用方决定，例如 *grpc.ClientConn 或 net.Conn；Track 让连接池跟随服务实例的注销回收连接
+type ConnPool[T any] struct {
+	dial  func(addr string) (T, error)
+	close func(T) error
+
+	mu     sync.Mutex
+	conns  map[string]*poolEntry[T]
+	closed bool
+}
+
+// poolEntry 是一个地址的连接，ready 关闭后 conn 或 err 可读
+type poolEntry[T any] struct {
+	ready chan struct{}
+	conn  T
+	err   error
+}
+
+// NewConnPool 创建连接池，dial 建立到地址的连接，closeConn 在连接被回收或连接池关闭时调用，可以为 nil
+func NewConnPool[T any](dial func(addr string) (T, error), closeConn func(T) error) *ConnPool[T] {
+	return &ConnPool[T]{dial: dial, close: closeConn, conns: make(map[string]*poolEntry[T])}
+}
+
+// GetConn 返回到 addr 的连接，没有时建立一个
+// 并发的第一次调用只有一个会 dial，其余等待它的结果；dial 失败不缓存，下次调用重新 dial
+func (p *ConnPool[T]) GetConn(addr string) (T, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		var zero T
+		return zero, ErrConnPoolClosed
+	}
+	e, ok := p.conns[addr]
+	if !ok {
+		e = &poolEntry[T]{ready: make(chan struct{})}
+		p.conns[addr] = e
+	}
+	p.mu.Unlock()
+	if ok {
+		<-e.ready
+		return e.conn, e.err
+	}
+	e.conn, e.err = p.dial(addr)
+	close(e.ready)
+	if e.err != nil {
+		p.mu.Lock()
+		if p.conns[addr] == e {
+			delete(p.conns, addr)
+		}
+		p.mu.Unlock()
+	}
+	return e.conn, e.err
+}
+
+// Evict 移除并关闭到 addr 的连接，正在 dial 的连接在建立后关闭
+func (p *ConnPool[T]) Evict(addr string) {
+	p.mu.Lock()
+	e, ok := p.conns[addr]
+	delete(p.conns, addr)
+	p.mu.Unlock()
+	if ok {
+		go p.closeEntry(e)
+	}
+}
+
+// Len 返回连接池中的地址数量
+func (p *ConnPool[T]) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Close 关闭全部连接，之后 GetConn 返回 ErrConnPoolClosed
+func (p *ConnPool[T]) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[string]*poolEntry[T])
+	p.mu.Unlock()
+	var errs []error
+	for _, e := range conns {
+		errs = append(errs, p.closeEntry(e))
+	}
+	return errors.Join(errs...)
+}
+
+// closeEntry 等待 dial 完成后关闭连接
+func (p *ConnPool[T]) closeEntry(e *poolEntry[T]) error {
+	<-e.ready
+	if e.err != nil || p.close == nil {
+		return nil
+	}
+	return p.close(e.conn)
+}
+
+// Track 用 WatchServiceEvents 跟随服务 name 的实例变化，一个地址的最后一个实例被移除时回收它的连接
+// 同一个地址重新注册（例如租约丢失后重新注册）时连接保留；ctx 结束或 d 关闭后停止跟随
+func (p *ConnPool[T]) Track(ctx context.Context, d *DiscoveryEtcd, name string) error {
+	events, err := d.WatchServiceEvents(ctx, name)
+	if err != nil {
+		return err
+	}
+	// 订阅之前已经存在的实例没有 Added 事件，先读取一次
+	instances, err := d.GetServiceInstances(ctx, name)
+	if err != nil && !errors.Is(err, ErrServiceNotFound) {
+		return err
+	}
+	keys := make(map[string]string, len(instances))
+	for _, inst := range instances {
+		keys[inst.Key] = inst.Addr
+	}
+	go func() {
+		for ev := range events {
+			switch ev.Type {
+			case EventAdded:
+				keys[ev.Key] = ev.Addr
+			case EventRemoved:
+				addr, ok := keys[ev.Key]
+				if !ok {
+					addr = ev.Addr
+				}
+				delete(keys, ev.Key)
+				if addr != "" && !hasAddr(keys, addr) {
+					p.Evict(addr)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// hasAddr 判断是否还有实例使用 addr
+func hasAddr(keys map[string]string, addr string) bool {
+	for _, a := range keys {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn 是 fakeDialer 建立的连接
+type fakeConn struct {
+	addr   string
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// fakeDialer 记录每个地址被 dial 的次数
+type fakeDialer struct {
+	mu    sync.Mutex
+	dials map[string]int
+	fail  map[string]bool
+}
+
+func newFakeDialer() *fakeDialer {
+	return &fakeDialer{dials: make(map[string]int), fail: make(map[string]bool)}
+}
+
+func (d *fakeDialer) dial(addr string) (*fakeConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials[addr]++
+	if d.fail[addr] {
+		return nil, errors.New("connection refused")
+	}
+	return &fakeConn{addr: addr}, nil
+}
+
+func (d *fakeDialer) count(addr string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials[addr]
+}
+
+func closeFakeConn(c *fakeConn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	dialer := newFakeDialer()
+	pool := NewConnPool(dialer.dial, closeFakeConn)
+
+	var wg sync.WaitGroup
+	conns := make([]*fakeConn, 8)
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pool.GetConn("localhost:9971")
+			if err != nil {
+				t.Errorf("GetConn failed: %v", err)
+			}
+			conns[i] = conn
+		}()
+	}
+	wg.Wait()
+	if n := dialer.count("localhost:9971"); n != 1 {
+		t.Errorf("Expected a single dial, got %d", n)
+	}
+	for _, c := range conns[1:] {
+		if c != conns[0] {
+			t.Fatalf("Expected every caller to share one connection")
+		}
+	}
+
+	// dial 失败不缓存
+	dialer.fail["localhost:9972"] = true
+	if _, err := pool.GetConn("localhost:9972"); err == nil {
+		t.Errorf("Expected dial error")
+	}
+	dialer.fail["localhost:9972"] = false
+	if _, err := pool.GetConn("localhost:9972"); err != nil {
+		t.Errorf("Expected retry after failed dial to succeed: %v", err)
+	}
+	if n := dialer.count("localhost:9972"); n != 2 {
+		t.Errorf("Expected failed dial to be retried, got %d dials", n)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !conns[0].isClosed() {
+		t.Errorf("Expected connection to be closed with the pool")
+	}
+	if _, err := pool.GetConn("localhost:9971"); !errors.Is(err, ErrConnPoolClosed) {
+		t.Errorf("Expected ErrConnPoolClosed, got %v", err)
+	}
+}
+
+func TestConnPoolTrackEviction(t *testing.T) {
+	const name = "pooled_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	var registries []*RegistryEtcd
+	for _, addr := range []string{"localhost:9973", "localhost:9974", "localhost:9974"} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: addr}); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+		registries = append(registries, registry)
+	}
+
+	dialer := newFakeDialer()
+	pool := NewConnPool(dialer.dial, closeFakeConn)
+	defer pool.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pool.Track(ctx, discovery, name); err != nil {
+		t.Fatalf("Track failed: %v", err)
+	}
+	addrs, err := discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	conns := make(map[string]*fakeConn)
+	for _, addr := range addrs {
+		if conns[addr], err = pool.GetConn(addr); err != nil {
+			t.Fatalf("GetConn failed: %v", err)
+		}
+	}
+
+	waitFor := func(cond func() bool, what string) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 实例注销后连接被回收
+	if err := registries[0].DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	waitFor(conns["localhost:9973"].isClosed, "connection to the removed instance to close")
+	if pool.Len() != 1 {
+		t.Errorf("Expected one pooled address, got %d", pool.Len())
+	}
+
+	// 同一地址还有其他实例时保留连接
+	if err := registries[1].DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if conns["localhost:9974"].isClosed() {
+		t.Errorf("Connection closed while another instance still uses the address")
+	}
+	if err := registries[2].DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	waitFor(conns["localhost:9974"].isClosed, "connection to the last instance to close")
+
+	// 回收后再次获取会重新 dial
+	if _, err := pool.GetConn("localhost:9973"); err != nil {
+		t.Fatalf("GetConn failed: %v", err)
+	}
+	if n := dialer.count("localhost:9973"); n != 2 {
+		t.Errorf("Expected a new dial after eviction, got %d dials", n)
+	}
+}
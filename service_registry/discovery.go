@@ -3,49 +3,223 @@ package main
 import (
 	"context"
 	"errors"
-	"math/rand"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 type Discovery interface {
-	GetServiceAddr(name string) (string, error)
+	GetServiceAddr(ctx context.Context, name string) (string, error)
+	// 返回服务全部实例的地址，去重并排序
+	GetAllServiceAddrs(ctx context.Context, name string) ([]string, error)
 	// 监控服务的地址变化
-	WatchService(name string) (<-chan string, error)
+	WatchService(ctx context.Context, name string) (<-chan string, error)
 }
 
+var _ Discovery = (*DiscoveryEtcd)(nil)
+
+// ErrServiceNotFound 表示服务名下没有任何实例，返回的错误中附带服务名
+var ErrServiceNotFound = errors.New("service not found")
+
 type DiscoveryEtcd struct {
 	client *clientv3.Client
+	// 查询实例使用的 KV，默认就是 client，测试中可以替换成假实现
+	kv clientv3.KV
+	// WatchService、WatchServiceBatched 和 Subscribe 使用的 Watcher，默认就是 client，测试中可以替换成假实现
+	watcher clientv3.Watcher
+	opts    options
+	// Close 时取消，WatchService 启动的 goroutine 随之退出
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// 开启 WithInstanceCache 时的实例缓存
+	cache *instanceCache
+	// 健康探测和负载均衡
+	selector
+
+	// 按服务名共享的 Watch，见 Subscribe
+	hubMu   sync.Mutex
+	watches map[string]*sharedWatch
 }
 
-func NewEtcdDiscovery(endpoints []string, dialTimeout time.Duration) (*DiscoveryEtcd, error) {
+func NewEtcdDiscovery(endpoints []string, dialTimeout time.Duration, opts ...Option) (*DiscoveryEtcd, error) {
 	if len(endpoints) == 0 {
-		return nil, errors.New("etcd endpoints cannot be empty")
+		return nil, ErrEmptyEndpoints
 	}
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: dialTimeout,
-	})
+	o := newOptions(opts)
+	cli, err := newClient(endpoints, dialTimeout, o)
 	if err != nil {
 		return nil, err
 	}
-	return &DiscoveryEtcd{
-		client: cli,
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &DiscoveryEtcd{
+		client:   cli,
+		kv:       cli,
+		watcher:  cli,
+		opts:     o,
+		ctx:      ctx,
+		cancel:   cancel,
+		selector: newSelector(o),
+	}
+	if o.instanceCache {
+		d.cache = newInstanceCache(o.cacheTTL)
+	}
+	return d, nil
 }
 
-func (d *DiscoveryEtcd) GetServiceAddr(name string) (string, error) {
-	// etcd 获取服务地址逻辑
-	resp, err := d.client.Get(context.Background(), name, clientv3.WithPrefix())
+// Close 停止全部 WatchService 并关闭 etcd 客户端
+func (d *DiscoveryEtcd) Close() error {
+	d.cancel()
+	return d.client.Close()
+}
+
+// Ping 检查 etcd 集群是否可达，可以用作就绪探针；错误中包含失败的 endpoint
+func (d *DiscoveryEtcd) Ping(ctx context.Context) error {
+	return ping(ctx, d.client)
+}
+
+// ClusterHealth 返回每个配置的 etcd endpoint 是否可达、是否是 leader 以及数据库大小，用于集群降级告警
+// 部分 endpoint 不可达时仍返回全部结果，只有全部不可达时才返回错误
+func (d *DiscoveryEtcd) ClusterHealth(ctx context.Context) ([]EndpointHealth, error) {
+	return clusterHealth(ctx, d.client)
+}
+
+func (d *DiscoveryEtcd) GetServiceAddr(ctx context.Context, name string) (string, error) {
+	rec, err := d.GetServiceRecord(ctx, name)
 	if err != nil {
 		return "", err
 	}
+	return rec.Addr, nil
+}
+
+// GetAllServiceAddrs 返回服务全部实例的地址，去重并排序，供调用方自己做负载均衡或预热连接池
+// 配置了 WithHealthCheck 时只返回探测通过的实例
+func (d *DiscoveryEtcd) GetAllServiceAddrs(ctx context.Context, name string) ([]string, error) {
+	instances, err := d.healthyInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueAddrs(instances), nil
+}
+
+// ServiceInstance 是发现端看到的一个服务实例：解码后的记录加上它在 etcd 中的 key 和创建版本
+type ServiceInstance struct {
+	ServiceRecord
+	Key string
+	// CreateRevision 是 key 创建时的 revision，越大说明注册得越晚
+	CreateRevision int64
+}
+
+// GetServiceInstances 返回服务的全部实例及其元数据，按 key 排序
+// 旧版本写入的纯地址记录作为没有元数据的实例返回
+func (d *DiscoveryEtcd) GetServiceInstances(ctx context.Context, name string) ([]ServiceInstance, error) {
+	return d.instances(ctx, name)
+}
+
+// GetServiceRecord 返回一个服务实例的完整记录，包括元数据中的健康检查地址等信息
+func (d *DiscoveryEtcd) GetServiceRecord(ctx context.Context, name string) (ServiceRecord, error) {
+	instances, err := d.healthyInstances(ctx, name)
+	if err != nil {
+		return ServiceRecord{}, err
+	}
+	return d.pick(name, instances).ServiceRecord, nil
+}
+
+// instances 查询服务的全部实例，用配置的 Codec 解码（内置 Codec 按记录自带的格式标识解码），结果按 key 排序
+// 升级期间可能混有无法解码的记录，跳过它们，只有全部无法解码时才返回错误
+// 开启 WithInstanceCache 时从本地缓存读取
+func (d *DiscoveryEtcd) instances(ctx context.Context, name string) (instances []ServiceInstance, err error) {
+	if m := d.opts.metrics; m != nil {
+		start := time.Now()
+		defer func() { m.ObserveLookup(name, time.Since(start), err) }()
+	}
+	if d.cache != nil {
+		return d.cache.instances(ctx, d, name)
+	}
+	resp, err := d.fetch(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	if len(resp.Kvs) == 0 {
-		return "", errors.New("service not found")
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	instances, decodeErr := d.decodeInstances(resp.Kvs)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no decodable service record: %w", decodeErr)
+	}
+	return instances, nil
+}
+
+// fetch 按重试策略读取服务名前缀下的全部 key
+func (d *DiscoveryEtcd) fetch(ctx context.Context, name string) (*clientv3.GetResponse, error) {
+	// etcd 获取服务地址逻辑
+	var resp *clientv3.GetResponse
+	err := withRetry(ctx, d.opts.opRetry, d.opts.requestTimeout, func(ctx context.Context) error {
+		var err error
+		resp, err = d.kv.Get(ctx, serviceKeyPrefix(name), clientv3.WithPrefix())
+		return err
+	})
+	return resp, err
+}
+
+// decodeInstances 解码记录，跳过无法解码的记录并返回最后一个解码错误
+func (d *DiscoveryEtcd) decodeInstances(kvs []*mvccpb.KeyValue) ([]ServiceInstance, error) {
+	instances := make([]ServiceInstance, 0, len(kvs))
+	var decodeErr error
+	for _, kv := range kvs {
+		rec, err := d.opts.decode(kv.Value)
+		if err != nil {
+			decodeErr = err
+			continue
+		}
+		instances = append(instances, ServiceInstance{
+			ServiceRecord:  rec,
+			Key:            string(kv.Key),
+			CreateRevision: kv.CreateRevision,
+		})
+	}
+	return instances, decodeErr
+}
+
+// ListServices 列出当前至少有一个实例的服务名，去重并排序，可以用来构建服务目录
+// 它只读取 key，不依赖 WithServiceIndex，但需要扫描整个命名空间，开销随实例总数增长；
+// 命名空间中不是实例 key（服务名/uuid）的 key 会被忽略
+func (d *DiscoveryEtcd) ListServices(ctx context.Context) ([]string, error) {
+	var resp *clientv3.GetResponse
+	err := withRetry(ctx, d.opts.opRetry, d.opts.requestTimeout, func(ctx context.Context) error {
+		var err error
+		resp, err = d.kv.Get(ctx, "", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, kv := range resp.Kvs {
+		name, ok := serviceNameFromKey(string(kv.Key))
+		if !ok {
+			continue
+		}
+		if _, dup := seen[name]; !dup {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetServiceAddrForKey 按路由 key 选择实例，同一个 key 总是落到同一个实例，实例增减时只有少量 key 改变归属
+// 配置的负载均衡器实现了 KeyedBalancer 时使用它，否则使用默认虚拟节点数的 ConsistentHashBalancer
+func (d *DiscoveryEtcd) GetServiceAddrForKey(ctx context.Context, name, key string) (string, error) {
+	instances, err := d.healthyInstances(ctx, name)
+	if err != nil {
+		return "", err
 	}
-	// 随机返回一个服务地址
-	randIndex := rand.Intn(len(resp.Kvs))
-	addr := string(resp.Kvs[randIndex].Value)
-	return addr, nil
+	return d.keyed.PickKey(name, key, instances).Addr, nil
 }
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestDiscovery(t *testing.T) {
@@ -10,10 +20,380 @@ func TestDiscovery(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create etcd discovery: %v", err)
 	}
-	addr, err := client.GetServiceAddr("order_service")
+	addr, err := client.GetServiceAddr(context.Background(), "order_service")
 	if err != nil {
 		t.Fatalf("Failed to get service address: %v", err)
 	}
 	t.Logf("Discovered service address: %s", addr)
 	// 通过地址与服务通信的逻辑
 }
+
+type metadataService struct {
+	*OrderService
+	meta map[string]string
+}
+
+func (s metadataService) Metadata() map[string]string {
+	return s.meta
+}
+
+func TestDiscoveryMixedFormats(t *testing.T) {
+	const name = "mixed_format_service"
+	meta := map[string]string{"version": "v2"}
+	services := []struct {
+		format  RecordFormat
+		service Service
+	}{
+		{FormatPlain, &OrderService{name: name, addr: "localhost:9001"}},
+		{FormatJSON, metadataService{&OrderService{name: name, addr: "localhost:9002"}, meta}},
+		{FormatProto, metadataService{&OrderService{name: name, addr: "localhost:9003"}, meta}},
+	}
+	for _, s := range services {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithRecordFormat(s.format))
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		if _, err := registry.Registry(context.Background(), s.service); err != nil {
+			t.Fatalf("Failed to register %s: %v", s.service.Addr(), err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	// 模拟更新版本的注册端写入的未知格式记录，发现端应跳过它
+	unknownKey := name + "/unknown"
+	if _, err := cli.Put(context.Background(), unknownKey, "\x07future"); err != nil {
+		t.Fatalf("Failed to put unknown record: %v", err)
+	}
+	defer cli.Delete(context.Background(), unknownKey)
+
+	resp, err := cli.Get(context.Background(), name, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Failed to get records: %v", err)
+	}
+	withMeta := 0
+	for _, kv := range resp.Kvs {
+		rec, err := DecodeRecord(kv.Value)
+		if err != nil {
+			continue
+		}
+		if rec.Metadata["version"] == "v2" {
+			withMeta++
+		}
+	}
+	if withMeta != 2 {
+		t.Errorf("Expected 2 records carrying metadata, got %d", withMeta)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		addr, err := discovery.GetServiceAddr(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Failed to get service address: %v", err)
+		}
+		if addr != "localhost:9001" && addr != "localhost:9002" && addr != "localhost:9003" {
+			t.Fatalf("Unexpected address %q", addr)
+		}
+	}
+}
+
+func TestDiscoveryHealthCheckURL(t *testing.T) {
+	const name = "health_url_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+		WithRecordFormat(FormatJSON), WithHealthCheckURL("/healthz"))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9101"}); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	rec, err := discovery.GetServiceRecord(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get service record: %v", err)
+	}
+	if rec.Metadata[MetadataHealthCheckURL] != "/healthz" {
+		t.Errorf("Health check url = %q, want /healthz", rec.Metadata[MetadataHealthCheckURL])
+	}
+	if got, want := rec.HealthCheckURL(), "http://localhost:9101/healthz"; got != want {
+		t.Errorf("HealthCheckURL() = %q, want %q", got, want)
+	}
+}
+
+func TestGetAllServiceAddrs(t *testing.T) {
+	const name = "all_addrs_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	for _, addr := range []string{"localhost:9622", "localhost:9621", "localhost:9622"} {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: addr}); err != nil {
+			t.Fatalf("Failed to register %s: %v", addr, err)
+		}
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	addrs, err := discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if want := []string{"localhost:9621", "localhost:9622"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("GetAllServiceAddrs = %v, want %v", addrs, want)
+	}
+}
+
+func TestGetServiceInstancesMetadata(t *testing.T) {
+	const name = "instances_metadata_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	// 默认格式下带元数据的服务自动使用 JSON，不带元数据的仍写纯地址
+	meta := map[string]string{"version": "v3", "region": "cn-north"}
+	if _, err := registry.Registry(context.Background(), metadataService{&OrderService{name: name, addr: "localhost:9631"}, meta}); err != nil {
+		t.Fatalf("Failed to register service with metadata: %v", err)
+	}
+	plainKey, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9632"})
+	if err != nil {
+		t.Fatalf("Failed to register plain service: %v", err)
+	}
+	resp, err := registry.client.Get(context.Background(), plainKey)
+	if err != nil || len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "localhost:9632" {
+		t.Fatalf("Expected plain address value for %s, err %v", plainKey, err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	instances, err := discovery.GetServiceInstances(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	got := make(map[string]map[string]string)
+	for _, inst := range instances {
+		got[inst.Addr] = inst.Metadata
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got["localhost:9631"], meta) || len(got["localhost:9632"]) != 0 {
+		t.Errorf("Unexpected instances %+v", instances)
+	}
+}
+
+func TestGetServiceAddrContextDeadline(t *testing.T) {
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if _, err := discovery.GetServiceAddr(ctx, "order_service"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+// flakyKV 的前 failures 次 Get 返回 err，之后返回 kvs
+type flakyKV struct {
+	clientv3.KV
+	failures int
+	err      error
+	kvs      []*mvccpb.KeyValue
+	calls    int
+}
+
+func (f *flakyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+	return &clientv3.GetResponse{Kvs: f.kvs}, nil
+}
+
+func newFakeDiscovery(kv clientv3.KV, opts ...Option) *DiscoveryEtcd {
+	o := newOptions(opts)
+	return &DiscoveryEtcd{kv: kv, opts: o, selector: newSelector(o)}
+}
+
+func TestGetServiceAddrRetry(t *testing.T) {
+	kv := &flakyKV{
+		failures: 2,
+		err:      status.Error(codes.Unavailable, "connection refused"),
+		kvs:      []*mvccpb.KeyValue{{Key: []byte("retry_service/a"), Value: []byte("localhost:9551")}},
+	}
+	d := newFakeDiscovery(kv, WithRetry(3, 5*time.Millisecond))
+	addr, err := d.GetServiceAddr(context.Background(), "retry_service")
+	if err != nil {
+		t.Fatalf("Expected success after two transient failures, got %v", err)
+	}
+	if addr != "localhost:9551" || kv.calls != 3 {
+		t.Errorf("Got %s after %d calls, want localhost:9551 after 3", addr, kv.calls)
+	}
+
+	// 重试次数用完后返回最后一次的错误
+	kv = &flakyKV{failures: 5, err: rpctypes.ErrLeaderChanged}
+	d = newFakeDiscovery(kv, WithRetry(3, time.Millisecond))
+	if _, err := d.GetServiceAddr(context.Background(), "retry_service"); !errors.Is(err, rpctypes.ErrLeaderChanged) {
+		t.Errorf("Expected leader changed error, got %v", err)
+	}
+	if kv.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", kv.calls)
+	}
+}
+
+func TestGetServiceAddrNotFoundNoRetry(t *testing.T) {
+	kv := &flakyKV{}
+	d := newFakeDiscovery(kv, WithRetry(5, 50*time.Millisecond))
+	start := time.Now()
+	if _, err := d.GetServiceAddr(context.Background(), "missing_service"); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("Expected ErrServiceNotFound, got %v", err)
+	}
+	if kv.calls != 1 || time.Since(start) > 40*time.Millisecond {
+		t.Errorf("Service not found should fail immediately, got %d calls in %v", kv.calls, time.Since(start))
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.5}
+	for attempt := 1; attempt <= 3; attempt++ {
+		base := 100 * time.Millisecond << (attempt - 1)
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(attempt); d < base/2 || d > base*3/2 {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", attempt, d, base/2, base*3/2)
+			}
+		}
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	if _, err := NewEtcdDiscovery(nil, time.Second); !errors.Is(err, ErrEmptyEndpoints) {
+		t.Errorf("NewEtcdDiscovery without endpoints = %v, want ErrEmptyEndpoints", err)
+	}
+	if _, err := NewEtcdRegistry(nil, time.Second, LeaseTTL); !errors.Is(err, ErrEmptyEndpoints) {
+		t.Errorf("NewEtcdRegistry without endpoints = %v, want ErrEmptyEndpoints", err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	_, err = discovery.GetServiceAddr(context.Background(), "never_registered_service")
+	if !errors.Is(err, ErrServiceNotFound) || !strings.Contains(err.Error(), "never_registered_service") {
+		t.Errorf("GetServiceAddr of unknown service = %v, want ErrServiceNotFound naming the service", err)
+	}
+
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	if err := registry.DeRegistryService(context.Background(), "never_registered_service-1"); !errors.Is(err, ErrServiceNotRegistered) {
+		t.Errorf("DeRegistryService of unknown key = %v, want ErrServiceNotRegistered", err)
+	}
+	if _, err := registry.TimeToLive(context.Background()); !errors.Is(err, ErrServiceNotRegistered) {
+		t.Errorf("TimeToLive without services = %v, want ErrServiceNotRegistered", err)
+	}
+}
+
+func TestListServices(t *testing.T) {
+	const ns = "/list_services_test/"
+	for _, s := range []Service{
+		&OrderService{name: "catalog_order", addr: "localhost:9921"},
+		&OrderService{name: "catalog_order", addr: "localhost:9922"},
+		&OrderService{name: "catalog_user", addr: "localhost:9923"},
+		&OrderService{name: "catalog_user", addr: "localhost:9924"},
+		&OrderService{name: "catalog_user", addr: "localhost:9925"},
+	} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithNamespace(ns))
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+		if _, err := registry.Registry(context.Background(), s); err != nil {
+			t.Fatalf("Failed to register %s: %v", s.Addr(), err)
+		}
+	}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithNamespace(ns))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	// 命名空间中不是实例 key 的数据被忽略
+	if _, err := discovery.client.Put(context.Background(), ns+"config/timeout", "3s"); err != nil {
+		t.Fatalf("Failed to put unrelated key: %v", err)
+	}
+	defer discovery.client.Delete(context.Background(), ns+"config/timeout")
+
+	names, err := discovery.ListServices(context.Background())
+	if err != nil {
+		t.Fatalf("ListServices failed: %v", err)
+	}
+	if want := []string{"catalog_order", "catalog_user"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListServices = %v, want %v", names, want)
+	}
+}
+
+func TestDiscoveryAddrRewrite(t *testing.T) {
+	const name = "rewritten_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "10.0.0.8:9941"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	rewrite := func(addr string) string {
+		return strings.Replace(addr, "10.0.0.8", "gateway.example.com", 1)
+	}
+	for _, opts := range [][]Option{
+		{WithAddrRewrite(rewrite)},
+		{WithAddrRewrite(rewrite), WithInstanceCache()},
+	} {
+		discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, opts...)
+		if err != nil {
+			t.Fatalf("Failed to create etcd discovery: %v", err)
+		}
+		defer discovery.Close()
+		addr, err := discovery.GetServiceAddr(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Failed to get address: %v", err)
+		}
+		if addr != "gateway.example.com:9941" {
+			t.Errorf("GetServiceAddr = %q, want rewritten address", addr)
+		}
+		ch, err := discovery.WatchService(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Failed to watch service: %v", err)
+		}
+		if addr := receiveAddr(t, ch); addr != "gateway.example.com:9941" {
+			t.Errorf("WatchService sent %q, want rewritten address", addr)
+		}
+	}
+}
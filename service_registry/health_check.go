@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTTL 是健康探测结果默认的缓存时间
+const DefaultHealthCheckTTL = 2 * time.Second
+
+// ErrNoHealthyInstances 表示服务有实例，但全部没有通过健康探测
+var ErrNoHealthyInstances = errors.New("no healthy service instance")
+
+// healthChecker 探测实例地址并按地址缓存探测结果
+type healthChecker struct {
+	probe func(addr string) bool
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	results map[string]healthResult
+}
+
+type healthResult struct {
+	healthy bool
+	at      time.Time
+}
+
+func newHealthChecker(probe func(addr string) bool, ttl time.Duration) *healthChecker {
+	return &healthChecker{probe: probe, ttl: ttl, results: make(map[string]healthResult)}
+}
+
+// filter 返回探测通过的实例，缓存过期的地址并发重新探测
+func (h *healthChecker) filter(instances []ServiceInstance) []ServiceInstance {
+	now := time.Now()
+	healthy := make(map[string]bool, len(instances))
+	var stale []string
+	h.mu.Lock()
+	for _, inst := range instances {
+		if _, seen := healthy[inst.Addr]; seen {
+			continue
+		}
+		if r, ok := h.results[inst.Addr]; ok && now.Sub(r.at) < h.ttl {
+			healthy[inst.Addr] = r.healthy
+			continue
+		}
+		healthy[inst.Addr] = false
+		stale = append(stale, inst.Addr)
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range stale {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok := h.probe(addr)
+			h.mu.Lock()
+			h.results[addr] = healthResult{healthy: ok, at: time.Now()}
+			h.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	h.mu.Lock()
+	for _, addr := range stale {
+		healthy[addr] = h.results[addr].healthy
+	}
+	h.mu.Unlock()
+	filtered := make([]ServiceInstance, 0, len(instances))
+	for _, inst := range instances {
+		if healthy[inst.Addr] {
+			filtered = append(filtered, inst)
+		}
+	}
+	return filtered
+}
+
+// healthyInstances 返回服务的实例，配置了健康探测时过滤掉探测失败的实例
+func (d *DiscoveryEtcd) healthyInstances(ctx context.Context, name string) ([]ServiceInstance, error) {
+	instances, err := d.instances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.healthy(instances)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckFilter(t *testing.T) {
+	const name = "health_checked_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	for _, addr := range []string{"localhost:9661", "localhost:9662"} {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: addr}); err != nil {
+			t.Fatalf("Failed to register %s: %v", addr, err)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		down   = map[string]bool{"localhost:9662": true}
+		probes int
+	)
+	probe := func(addr string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		probes++
+		return !down[addr]
+	}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second,
+		WithHealthCheck(probe), WithHealthCheckTTL(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+
+	addrs, err := discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if want := []string{"localhost:9661"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("GetAllServiceAddrs = %v, want %v", addrs, want)
+	}
+	for i := 0; i < 10; i++ {
+		if addr, err := discovery.GetServiceAddr(context.Background(), name); err != nil || addr != "localhost:9661" {
+			t.Errorf("GetServiceAddr = %q, %v", addr, err)
+		}
+	}
+	// 探测结果在 TTL 内被缓存
+	if probes != 2 {
+		t.Errorf("Expected 2 probes within the TTL, got %d", probes)
+	}
+}
+
+func TestHealthCheckNoHealthyInstances(t *testing.T) {
+	const name = "unhealthy_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9663"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second,
+		WithHealthCheck(func(string) bool { return false }))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	if _, err := discovery.GetServiceAddr(context.Background(), name); !errors.Is(err, ErrNoHealthyInstances) {
+		t.Errorf("Expected ErrNoHealthyInstances, got %v", err)
+	}
+}
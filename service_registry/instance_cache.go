@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// cacheTTLJitter 是 WithCacheTTL 有效期的随机抖动比例
+const cacheTTLJitter = 0.1
+
+// instanceCache 按服务名缓存实例列表，每个服务由一个后台 Watch 保持更新
+type instanceCache struct {
+	mu      sync.RWMutex
+	entries map[string]*cacheEntry
+
+	// 缓存有效期，0 表示不过期，见 WithCacheTTL
+	ttl time.Duration
+	// 当前时间，测试中可以替换成假时钟
+	now func() time.Time
+}
+
+// cacheEntry 是一个服务的缓存，ready 关闭后 err 或 snapshot 可读
+type cacheEntry struct {
+	ready chan struct{}
+	err   error
+
+	mu       sync.RWMutex
+	state    map[string]ServiceInstance
+	snapshot []ServiceInstance // 按 key 排序，每次变化时整体替换
+	expires  time.Time         // 开启 WithCacheTTL 时全量数据的过期时间
+
+	// 串行化过期后的刷新，并发的查询只有一个会访问 etcd
+	refreshMu sync.Mutex
+}
+
+func newInstanceCache(ttl time.Duration) *instanceCache {
+	return &instanceCache{entries: make(map[string]*cacheEntry), ttl: ttl, now: time.Now}
+}
+
+// instances 返回服务的缓存实例，第一次查询时读取全量数据并启动 Watch
+// 并发的第一次查询只有一个会访问 etcd，其余等待它的结果
+func (c *instanceCache) instances(ctx context.Context, d *DiscoveryEtcd, name string) ([]ServiceInstance, error) {
+	c.mu.RLock()
+	e, ok := c.entries[name]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		if e, ok = c.entries[name]; !ok {
+			e = &cacheEntry{ready: make(chan struct{})}
+			c.entries[name] = e
+		}
+		c.mu.Unlock()
+		if !ok {
+			c.load(ctx, d, name, e)
+		}
+	}
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	if c.expired(e) {
+		if err := c.refresh(ctx, d, name, e); err != nil {
+			return nil, err
+		}
+	}
+	e.mu.RLock()
+	snapshot := e.snapshot
+	e.mu.RUnlock()
+	if len(snapshot) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	return append([]ServiceInstance(nil), snapshot...), nil
+}
+
+// load 读取服务的全量实例并启动 Watch，读取失败时移除缓存项，下次查询重新加载
+func (c *instanceCache) load(ctx context.Context, d *DiscoveryEtcd, name string, e *cacheEntry) {
+	defer close(e.ready)
+	resp, err := d.fetch(ctx, name)
+	if err != nil {
+		e.err = err
+		c.mu.Lock()
+		delete(c.entries, name)
+		c.mu.Unlock()
+		return
+	}
+	c.reset(d, e, resp)
+	go c.follow(d, name, e, resp.Header.Revision)
+}
+
+// expired 判断缓存项是否超过了 WithCacheTTL 的有效期
+func (c *instanceCache) expired(e *cacheEntry) bool {
+	if c.ttl <= 0 {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return !c.now().Before(e.expires)
+}
+
+// refresh 重新读取过期缓存项的全量数据，Watch 仍然继续更新它
+func (c *instanceCache) refresh(ctx context.Context, d *DiscoveryEtcd, name string, e *cacheEntry) error {
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+	// 等待期间其他查询可能已经刷新过
+	if !c.expired(e) {
+		return nil
+	}
+	resp, err := d.fetch(ctx, name)
+	if err != nil {
+		return err
+	}
+	c.reset(d, e, resp)
+	return nil
+}
+
+// reset 用全量读取的结果替换缓存，并按 WithCacheTTL 计算新的过期时间
+func (c *instanceCache) reset(d *DiscoveryEtcd, e *cacheEntry, resp *clientv3.GetResponse) {
+	var expires time.Time
+	if c.ttl > 0 {
+		jitter := 1 + cacheTTLJitter*(2*rand.Float64()-1)
+		expires = c.now().Add(time.Duration(float64(c.ttl) * jitter))
+	}
+	e.reset(d, resp, expires)
+}
+
+// reset 用全量读取的结果替换缓存
+func (e *cacheEntry) reset(d *DiscoveryEtcd, resp *clientv3.GetResponse, expires time.Time) {
+	instances, _ := d.decodeInstances(resp.Kvs)
+	state := make(map[string]ServiceInstance, len(instances))
+	for _, inst := range instances {
+		state[inst.Key] = inst
+	}
+	e.mu.Lock()
+	e.state = state
+	e.snapshot = instances
+	e.expires = expires
+	e.mu.Unlock()
+}
+
+// follow 把 Watch 事件应用到缓存，直到 DiscoveryEtcd 被关闭
+// revision 被压缩时 watchPrefix 重新读取全量数据，读取失败时丢弃缓存项，下次查询重新加载
+func (c *instanceCache) follow(d *DiscoveryEtcd, name string, e *cacheEntry, rev int64) {
+	err := d.watchPrefix(d.ctx, name, rev, func(events []*clientv3.Event) {
+		e.apply(d, events)
+	}, func(resp *clientv3.GetResponse) {
+		c.reset(d, e, resp)
+	})
+	if d.ctx.Err() != nil {
+		return
+	}
+	d.opts.logger.Warnf("instance cache for %s dropped: %v", name, err)
+	c.mu.Lock()
+	if c.entries[name] == e {
+		delete(c.entries, name)
+	}
+	c.mu.Unlock()
+}
+
+// apply 应用一批 Watch 事件并重建快照
+func (e *cacheEntry) apply(d *DiscoveryEtcd, events []*clientv3.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		if ev.Type == clientv3.EventTypeDelete {
+			delete(e.state, key)
+			continue
+		}
+		rec, err := d.opts.decode(ev.Kv.Value)
+		if err != nil {
+			continue
+		}
+		e.state[key] = ServiceInstance{ServiceRecord: rec, Key: key, CreateRevision: ev.Kv.CreateRevision}
+	}
+	snapshot := make([]ServiceInstance, 0, len(e.state))
+	for _, inst := range e.state {
+		snapshot = append(snapshot, inst)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Key < snapshot[j].Key })
+	e.snapshot = snapshot
+}
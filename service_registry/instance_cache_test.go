@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestInstanceCache(t *testing.T) {
+	const name = "cached_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9651"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithInstanceCache())
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+
+	// 并发的第一次查询共享同一次加载
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addr, err := discovery.GetServiceAddr(context.Background(), name); err != nil || addr != "localhost:9651" {
+				t.Errorf("GetServiceAddr = %q, %v", addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 新注册和注销的实例通过 Watch 进入缓存
+	key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9652"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	waitAddrs(t, discovery, name, []string{"localhost:9651", "localhost:9652"})
+	if err := registry.DeRegistryService(context.Background(), key); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	waitAddrs(t, discovery, name, []string{"localhost:9651"})
+}
+
+func waitAddrs(t *testing.T, d *DiscoveryEtcd, name string, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		addrs, err := d.GetAllServiceAddrs(context.Background(), name)
+		if err == nil && reflect.DeepEqual(addrs, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetAllServiceAddrs = %v, %v, want %v", addrs, err, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// countingKV 统计 Get 的次数
+type countingKV struct {
+	clientv3.KV
+	mu   sync.Mutex
+	gets int
+}
+
+func (c *countingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+	return c.KV.Get(ctx, key, opts...)
+}
+
+func (c *countingKV) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gets
+}
+
+// fakeClock 是只在测试中手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestInstanceCacheTTL(t *testing.T) {
+	const name = "ttl_cached_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9661"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithCacheTTL(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	kv := &countingKV{KV: discovery.kv}
+	discovery.kv = kv
+	clock := &fakeClock{now: time.Now()}
+	discovery.cache.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		if _, err := discovery.GetServiceAddr(context.Background(), name); err != nil {
+			t.Fatalf("GetServiceAddr failed: %v", err)
+		}
+	}
+	if n := kv.count(); n != 1 {
+		t.Fatalf("Expected lookups within the TTL to be served from cache, got %d Gets", n)
+	}
+
+	// 抖动最多 10%，未到 TTL 的 90% 时不刷新，超过 TTL 的 110% 后一定刷新
+	clock.Advance(50 * time.Second)
+	if _, err := discovery.GetServiceAddr(context.Background(), name); err != nil {
+		t.Fatalf("GetServiceAddr failed: %v", err)
+	}
+	if n := kv.count(); n != 1 {
+		t.Errorf("Expected no refresh before the TTL, got %d Gets", n)
+	}
+	clock.Advance(20 * time.Second)
+	for i := 0; i < 3; i++ {
+		if _, err := discovery.GetServiceAddr(context.Background(), name); err != nil {
+			t.Fatalf("GetServiceAddr failed: %v", err)
+		}
+	}
+	if n := kv.count(); n != 2 {
+		t.Errorf("Expected exactly one refresh after the TTL, got %d Gets", n)
+	}
+}
+
+// TestInstanceCacheCompacted 缓存的 Watch 遇到压缩错误时重新读取全量数据，断开期间的变化不会丢失
+func TestInstanceCacheCompacted(t *testing.T) {
+	const name = "compacted_cached_service"
+	logger := &recordingLogger{}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithInstanceCache(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	w := newFlakyWatcher(discovery.client)
+	w.compact = true
+	discovery.watcher = w
+	kv := &countingKV{KV: discovery.kv}
+	discovery.kv = kv
+
+	oldRegistry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer oldRegistry.Close()
+	defer oldRegistry.DeRegistry(context.Background())
+	if _, err := oldRegistry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9671"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	waitAddrs(t, discovery, name, []string{"localhost:9671"})
+
+	// 切断 Watch，断开期间替换实例，恢复时 Watch 返回压缩错误
+	close(w.drop)
+	newRegistry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer newRegistry.Close()
+	defer newRegistry.DeRegistry(context.Background())
+	if _, err := newRegistry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9672"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := oldRegistry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	close(w.resume)
+	waitAddrs(t, discovery, name, []string{"localhost:9672"})
+
+	if n := kv.count(); n != 2 {
+		t.Errorf("Expected one resync Get after compaction, got %d Gets", n)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for len(w.calls()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a fresh watch after resync, got watches starting at %v", w.calls())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !logger.contains("WARN watch for " + name + " lost events") {
+		t.Errorf("Expected compaction to be logged, got %v", logger.lines)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// MethodSig 描述接口中一个方法的签名
+type MethodSig struct {
+	Name     string
+	In       []reflect.Type // 参数类型，变长参数的最后一个为切片类型
+	Out      []reflect.Type // 返回值类型
+	Variadic bool
+}
+
+// String 以 Go 语法形式输出方法签名，例如 Registry(main.Service) error
+func (m MethodSig) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.Name)
+	sb.WriteString("(")
+	for i, in := range m.In {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if m.Variadic && i == len(m.In)-1 {
+			sb.WriteString("..." + in.Elem().String())
+		} else {
+			sb.WriteString(in.String())
+		}
+	}
+	sb.WriteString(")")
+	switch len(m.Out) {
+	case 0:
+	case 1:
+		sb.WriteString(" " + m.Out[0].String())
+	default:
+		outs := make([]string, len(m.Out))
+		for i, out := range m.Out {
+			outs[i] = out.String()
+		}
+		sb.WriteString(" (" + strings.Join(outs, ", ") + ")")
+	}
+	return sb.String()
+}
+
+// InterfaceMethods 通过反射列出接口的全部方法签名，用于在运行时生成 Registry/Discovery 的测试替身
+// ifacePtr 必须是接口的指针，例如 (*Registry)(nil)，否则返回 nil 而不是 panic
+func InterfaceMethods(ifacePtr interface{}) []MethodSig {
+	t := reflect.TypeOf(ifacePtr)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		return nil
+	}
+	iface := t.Elem()
+	// 接口类型的 Method 不包含接收者，参数从下标 0 开始
+	methods := make([]MethodSig, 0, iface.NumMethod())
+	for i := 0; i < iface.NumMethod(); i++ {
+		m := iface.Method(i)
+		sig := MethodSig{
+			Name:     m.Name,
+			Variadic: m.Type.IsVariadic(),
+		}
+		for j := 0; j < m.Type.NumIn(); j++ {
+			sig.In = append(sig.In, m.Type.In(j))
+		}
+		for j := 0; j < m.Type.NumOut(); j++ {
+			sig.Out = append(sig.Out, m.Type.Out(j))
+		}
+		methods = append(methods, sig)
+	}
+	return methods
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestInterfaceMethodsRegistry(t *testing.T) {
+	methods := InterfaceMethods((*Registry)(nil))
+	got := make(map[string]MethodSig)
+	for _, m := range methods {
+		got[m.Name] = m
+	}
+
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+	stringType := reflect.TypeOf("")
+	ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
+	serviceType := reflect.TypeOf((*Service)(nil)).Elem()
+
+	reg, ok := got["Registry"]
+	if !ok {
+		t.Fatalf("Registry method not found in %v", methods)
+	}
+	if !reflect.DeepEqual(reg.In, []reflect.Type{ctxType, serviceType}) || !reflect.DeepEqual(reg.Out, []reflect.Type{stringType, errType}) {
+		t.Errorf("Unexpected Registry signature: %s", reg)
+	}
+
+	dereg, ok := got["DeRegistry"]
+	if !ok {
+		t.Fatalf("DeRegistry method not found in %v", methods)
+	}
+	if !reflect.DeepEqual(dereg.In, []reflect.Type{ctxType}) || !reflect.DeepEqual(dereg.Out, []reflect.Type{errType}) {
+		t.Errorf("Unexpected DeRegistry signature: %s", dereg)
+	}
+	t.Logf("Registry methods: %v", methods)
+}
+
+type stubTarget interface {
+	Format(prefix string, args ...interface{}) (string, int, error)
+}
+
+func TestInterfaceMethodsVariadic(t *testing.T) {
+	methods := InterfaceMethods((*stubTarget)(nil))
+	if len(methods) != 1 {
+		t.Fatalf("Expected 1 method, got %d", len(methods))
+	}
+	m := methods[0]
+	if !m.Variadic || len(m.In) != 2 || len(m.Out) != 3 {
+		t.Fatalf("Unexpected signature: %s", m)
+	}
+	if want := "Format(string, ...interface {}) (string, int, error)"; m.String() != want {
+		t.Errorf("String() = %q, want %q", m.String(), want)
+	}
+}
+
+func TestInterfaceMethodsInvalidInput(t *testing.T) {
+	if InterfaceMethods(nil) != nil || InterfaceMethods(&OrderService{}) != nil {
+		t.Errorf("Expected nil for non-interface pointer input")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrLeaseManagerClosed 表示共享租约已经撤销或关闭，不能再挂载 key
+var ErrLeaseManagerClosed = errors.New("lease manager closed")
+
+// LeaseManager 用一个租约和一个续约 goroutine 承载多个 key
+// 注册大量服务时不必为每个实例单独申请租约、启动续约；撤销租约时 etcd 原子地删除全部挂载的 key
+type LeaseManager struct {
+	client *clientv3.Client
+	ttl    int64
+	// 超过这个时间没有收到续约响应就按租约丢失处理，0 表示 TTL 的两倍
+	stall time.Duration
+
+	mu      sync.Mutex
+	leaseID clientv3.LeaseID
+	ttlLeft int64             // 最近一次续约响应中的剩余 TTL（秒）
+	keys    map[string]string // 挂载的 key 及其 value，续租失败后重新写入
+	cancel  context.CancelFunc
+	closed  bool
+	// Revoke 或 stop 时关闭，onLost 中等待重新申请的退避随之结束
+	done chan struct{}
+	// 续约 goroutine 计入 wg，RegistryEtcd 传入自己的 keepAlives，注销和关闭时等待它们退出
+	wg *sync.WaitGroup
+	// onLost 在租约意外丢失（过期、被外部撤销）时调用
+	onLost func(m *LeaseManager, lost clientv3.LeaseID)
+}
+
+// NewLeaseManager 申请一个 TTL 为 ttl 秒的租约并启动续约
+func NewLeaseManager(ctx context.Context, client *clientv3.Client, ttl int64) (*LeaseManager, error) {
+	return newLeaseManager(ctx, client, ttl, 0, nil, nil)
+}
+
+func newLeaseManager(ctx context.Context, client *clientv3.Client, ttl int64, stall time.Duration, wg *sync.WaitGroup, onLost func(*LeaseManager, clientv3.LeaseID)) (*LeaseManager, error) {
+	if wg == nil {
+		wg = &sync.WaitGroup{}
+	}
+	m := &LeaseManager{client: client, ttl: ttl, stall: stall, keys: make(map[string]string), done: make(chan struct{}), wg: wg, onLost: onLost}
+	if err := m.grant(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// grant 申请新租约，把已挂载的 key 写回新租约，然后启动续约
+func (m *LeaseManager) grant(ctx context.Context) error {
+	resp, err := m.client.Grant(ctx, m.ttl)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	ops := make([]clientv3.Op, 0, len(m.keys))
+	for k, v := range m.keys {
+		ops = append(ops, clientv3.OpPut(k, v, clientv3.WithLease(resp.ID)))
+	}
+	m.mu.Unlock()
+	if len(ops) > 0 {
+		if _, err := m.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+			m.client.Revoke(context.Background(), resp.ID)
+			return err
+		}
+	}
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	keepAliveCh, err := m.client.KeepAlive(keepAliveCtx, resp.ID)
+	if err != nil {
+		cancel()
+		m.client.Revoke(context.Background(), resp.ID)
+		return err
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		cancel()
+		m.client.Revoke(context.Background(), resp.ID)
+		return ErrLeaseManagerClosed
+	}
+	m.leaseID = resp.ID
+	m.ttlLeft = resp.TTL
+	m.cancel = cancel
+	onLost := m.onLost
+	// 在 m.mu 下计数：关闭之后不会再有新的续约 goroutine，等待方不会漏掉它
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	// 全部挂载的 key 共用这一个续约 goroutine
+	go func() {
+		defer m.wg.Done()
+		// 续约卡住时先停止这次续约，等 onLost 把 key 写到新租约上之后再撤销旧租约
+		stalled := consumeKeepAlive(keepAliveCh, nil, stallTimeout(m.stall, m.ttl), func(ka *clientv3.LeaseKeepAliveResponse) {
+			m.mu.Lock()
+			if m.leaseID == ka.ID {
+				m.ttlLeft = ka.TTL
+			}
+			m.mu.Unlock()
+		})
+		if stalled {
+			cancel()
+		}
+		if (stalled || keepAliveCtx.Err() == nil) && onLost != nil {
+			onLost(m, resp.ID)
+		}
+		if stalled {
+			m.client.Revoke(context.Background(), resp.ID)
+		}
+	}()
+	return nil
+}
+
+// LeaseID 返回当前的共享租约
+func (m *LeaseManager) LeaseID() clientv3.LeaseID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leaseID
+}
+
+// remainingTTL 返回最近一次续约响应中的剩余 TTL（秒），不访问 etcd
+func (m *LeaseManager) remainingTTL() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ttlLeft
+}
+
+// Attach 把 key 绑定到共享租约上写入 etcd
+func (m *LeaseManager) Attach(ctx context.Context, key, value string) error {
+	return m.attach(ctx, key, value)
+}
+
+// attach 在同一个事务中写入 key 和附带的其他操作（例如服务名索引）
+func (m *LeaseManager) attach(ctx context.Context, key, value string, extra ...clientv3.Op) error {
+	return m.attachAll(ctx, map[string]string{key: value}, extra...)
+}
+
+// attachAll 在同一个事务中写入全部 key，要么全部挂载成功，要么一个也不写入
+func (m *LeaseManager) attachAll(ctx context.Context, kvs map[string]string, extra ...clientv3.Op) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrLeaseManagerClosed
+	}
+	leaseID := m.leaseID
+	m.mu.Unlock()
+	ops := make([]clientv3.Op, 0, len(kvs)+len(extra))
+	for k, v := range kvs {
+		ops = append(ops, clientv3.OpPut(k, v, clientv3.WithLease(leaseID)))
+	}
+	if _, err := m.client.Txn(ctx).Then(append(ops, extra...)...).Commit(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	for k, v := range kvs {
+		m.keys[k] = v
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// swap 在 key 的当前值等于 expected 时把它替换成 value，返回是否替换
+func (m *LeaseManager) swap(ctx context.Context, key, expected, value string) (bool, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return false, ErrLeaseManagerClosed
+	}
+	leaseID := m.leaseID
+	m.mu.Unlock()
+	resp, err := m.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", expected)).
+		Then(clientv3.OpPut(key, value, clientv3.WithLease(leaseID))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		return false, err
+	}
+	m.mu.Lock()
+	m.keys[key] = value
+	m.mu.Unlock()
+	return true, nil
+}
+
+// move 在 oldKey 仍然存在时删除它并把 value 写到 newKey，两者在同一个事务中完成，返回是否移动
+func (m *LeaseManager) move(ctx context.Context, oldKey, newKey, value string, extra ...clientv3.Op) (bool, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return false, ErrLeaseManagerClosed
+	}
+	leaseID := m.leaseID
+	m.mu.Unlock()
+	resp, err := m.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(oldKey), ">", 0)).
+		Then(append([]clientv3.Op{clientv3.OpDelete(oldKey), clientv3.OpPut(newKey, value, clientv3.WithLease(leaseID))}, extra...)...).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		return false, err
+	}
+	m.mu.Lock()
+	delete(m.keys, oldKey)
+	m.keys[newKey] = value
+	m.mu.Unlock()
+	return true, nil
+}
+
+// Detach 删除 key，共享租约和其余 key 不受影响
+func (m *LeaseManager) Detach(ctx context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.keys, key)
+	m.mu.Unlock()
+	_, err := m.client.Delete(ctx, key)
+	return err
+}
+
+// empty 判断是否已经没有挂载的 key
+func (m *LeaseManager) empty() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.keys) == 0
+}
+
+// Revoke 撤销共享租约，etcd 在同一个 revision 中删除全部挂载的 key，之后不能再挂载
+func (m *LeaseManager) Revoke(ctx context.Context) error {
+	m.mu.Lock()
+	m.shutdown()
+	m.keys = make(map[string]string)
+	leaseID := m.leaseID
+	m.mu.Unlock()
+	_, err := m.client.Revoke(ctx, leaseID)
+	return err
+}
+
+// stop 停止续约但不撤销租约，挂载的 key 在租约过期后消失
+func (m *LeaseManager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shutdown()
+}
+
+// shutdown 停止续约并关闭 done，调用方持有 m.mu
+func (m *LeaseManager) shutdown() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+}
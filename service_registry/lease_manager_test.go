@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestLeaseManagerRevokeRemovesAllKeys(t *testing.T) {
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	m, err := NewLeaseManager(context.Background(), cli, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create lease manager: %v", err)
+	}
+	const prefix = "/lease_manager_test/"
+	for i := 0; i < 5; i++ {
+		if err := m.Attach(context.Background(), fmt.Sprintf("%s%d", prefix, i), "v"); err != nil {
+			t.Fatalf("Failed to attach key: %v", err)
+		}
+	}
+	if err := m.Detach(context.Background(), prefix+"0"); err != nil {
+		t.Fatalf("Failed to detach key: %v", err)
+	}
+
+	watchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchCh := cli.Watch(watchCtx, prefix, clientv3.WithPrefix())
+	if err := m.Revoke(context.Background()); err != nil {
+		t.Fatalf("Failed to revoke shared lease: %v", err)
+	}
+	// 撤销租约在同一个 revision 中删除全部挂载的 key
+	select {
+	case resp := <-watchCh:
+		if len(resp.Events) != 4 {
+			t.Fatalf("Expected 4 deletions in one revision, got %d events", len(resp.Events))
+		}
+		for _, ev := range resp.Events {
+			if ev.Type != clientv3.EventTypeDelete || ev.Kv.ModRevision != resp.Events[0].Kv.ModRevision {
+				t.Errorf("Unexpected event %v at revision %d", ev.Type, ev.Kv.ModRevision)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for deletions")
+	}
+	if err := m.Attach(context.Background(), prefix+"late", "v"); err == nil {
+		t.Errorf("Expected error attaching to a revoked lease")
+	}
+}
+
+func TestRegistrySharedLease(t *testing.T) {
+	const name = "shared_lease_service"
+	const n = 20
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithSharedLease())
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+
+	register := func(i int) {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: fmt.Sprintf("localhost:%d", 9841+i)}); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+	}
+	register(0)
+	time.Sleep(100 * time.Millisecond)
+	before := runtime.NumGoroutine()
+	for i := 1; i < n; i++ {
+		register(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// 独立租约时每个实例多一个续约 goroutine，共享租约时数量不随实例增长
+	if after := runtime.NumGoroutine(); after-before >= n/2 {
+		t.Errorf("Goroutines grew from %d to %d for %d more services, expected a single shared keepalive", before, after, n-1)
+	}
+
+	resp, err := registry.client.Get(context.Background(), name, clientv3.WithPrefix())
+	if err != nil || len(resp.Kvs) != n {
+		t.Fatalf("Expected %d registered instances, got %d, err %v", n, len(resp.Kvs), err)
+	}
+	for _, kv := range resp.Kvs {
+		if kv.Lease != resp.Kvs[0].Lease {
+			t.Fatalf("Instances use different leases %x and %x", kv.Lease, resp.Kvs[0].Lease)
+		}
+	}
+	leases, err := registry.client.Leases(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list leases: %v", err)
+	}
+	t.Logf("%d instances share lease %x, %d leases in etcd", n, resp.Kvs[0].Lease, len(leases.Leases))
+
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if resp, err := registry.client.Get(context.Background(), name, clientv3.WithPrefix()); err != nil || len(resp.Kvs) != 0 {
+		t.Errorf("Expected all instances to be removed, got %d", len(resp.Kvs))
+	}
+	// 注销后再次注册会申请新的共享租约
+	register(0)
+}
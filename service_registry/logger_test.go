@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// recordingLogger 记录全部日志，供测试检查
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) logf(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.logf("DEBUG", format, args...)
+}
+func (l *recordingLogger) Infof(format string, args ...interface{}) { l.logf("INFO", format, args...) }
+func (l *recordingLogger) Warnf(format string, args ...interface{}) { l.logf("WARN", format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.logf("ERROR", format, args...)
+}
+
+func (l *recordingLogger) contains(prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRegistryLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, 2, WithLogger(logger),
+		WithReRegister(RetryPolicy{MaxAttempts: 2, BaseDelay: 50 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	key, err := registry.Registry(context.Background(), &OrderService{name: "logged_service", addr: "localhost:9691"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if !logger.contains("DEBUG granted lease") {
+		t.Errorf("Expected lease grant to be logged, got %v", logger.lines)
+	}
+
+	resp, err := registry.client.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("Failed to get registered key: %v", err)
+	}
+	if _, err := registry.client.Revoke(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		t.Fatalf("Failed to revoke lease: %v", err)
+	}
+	select {
+	case <-registry.KeepAliveErrors():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for keepalive loss")
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for !logger.contains("INFO re-registering logged_service") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected re-registration to be logged, got %v", logger.lines)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !logger.contains("WARN keepalive lost") {
+		t.Errorf("Expected keepalive loss to be logged, got %v", logger.lines)
+	}
+}
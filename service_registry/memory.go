@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore 是进程内的服务存储，相当于一个 etcd 集群
+// 共用同一个 MemoryStore 的 MemoryRegistry 和 MemoryDiscovery 互相可见，用于不依赖 etcd 的单元测试
+type MemoryStore struct {
+	mu        sync.Mutex
+	rev       int64
+	instances map[string]ServiceInstance
+	// 按监听的服务名前缀登记的变更通知，通知只表示“有变化”，不携带内容
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// NewMemoryStore 创建空的进程内服务存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		instances: make(map[string]ServiceInstance),
+		watchers:  make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func (s *MemoryStore) put(inst ServiceInstance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rev++
+	inst.CreateRevision = s.rev
+	s.instances[inst.Key] = inst
+	s.notify(inst.Key)
+}
+
+func (s *MemoryStore) delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.instances[key]; !ok {
+		return false
+	}
+	s.rev++
+	delete(s.instances, key)
+	s.notify(key)
+	return true
+}
+
+// list 返回服务 name 的实例，按 key 排序，与 etcd 的前缀查询一致
+func (s *MemoryStore) list(name string) []ServiceInstance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var instances []ServiceInstance
+	for key, inst := range s.instances {
+		if strings.HasPrefix(key, serviceKeyPrefix(name)) {
+			instances = append(instances, inst)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Key < instances[j].Key })
+	return instances
+}
+
+// notify 通知前缀匹配 key 的监听者，调用方持有 s.mu
+func (s *MemoryStore) notify(key string) {
+	for name, chs := range s.watchers {
+		if !strings.HasPrefix(key, serviceKeyPrefix(name)) {
+			continue
+		}
+		for ch := range chs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// subscribe 登记服务名前缀 name 的变更通知，返回的函数取消登记
+func (s *MemoryStore) subscribe(name string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watchers[name] == nil {
+		s.watchers[name] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[name][ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers[name], ch)
+		if len(s.watchers[name]) == 0 {
+			delete(s.watchers, name)
+		}
+	}
+}
+
+// MemoryRegistry 是基于 MemoryStore 的 Registry 实现，没有租约，实例在注销前一直存在
+// 记录按与 RegistryEtcd 相同的规则构造（地址检查、元数据、健康检查地址）
+type MemoryRegistry struct {
+	store *MemoryStore
+	opts  options
+
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+var _ Registry = (*MemoryRegistry)(nil)
+
+// NewMemoryRegistry 创建向 store 注册服务的进程内注册中心
+func NewMemoryRegistry(store *MemoryStore, opts ...Option) *MemoryRegistry {
+	return &MemoryRegistry{store: store, opts: newOptions(opts), keys: make(map[string]bool)}
+}
+
+func (r *MemoryRegistry) Registry(ctx context.Context, service Service) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	rec, err := newRecord(r.opts, service)
+	if err != nil {
+		return "", err
+	}
+	key := newServiceKey(service.Name())
+	r.store.put(ServiceInstance{ServiceRecord: rec, Key: key})
+	r.mu.Lock()
+	r.keys[key] = true
+	r.mu.Unlock()
+	return key, nil
+}
+
+// DeRegistry 注销通过这个注册中心注册的全部实例
+func (r *MemoryRegistry) DeRegistry(ctx context.Context) error {
+	r.mu.Lock()
+	keys := r.keys
+	r.keys = make(map[string]bool)
+	r.mu.Unlock()
+	for key := range keys {
+		r.store.delete(key)
+	}
+	return nil
+}
+
+// DeRegistryService 注销 key 对应的一个实例
+func (r *MemoryRegistry) DeRegistryService(ctx context.Context, key string) error {
+	r.mu.Lock()
+	ok := r.keys[key]
+	delete(r.keys, key)
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotRegistered, key)
+	}
+	r.store.delete(key)
+	return nil
+}
+
+// MemoryDiscovery 是基于 MemoryStore 的 Discovery 实现
+// 负载均衡和健康探测与 DiscoveryEtcd 共用同一套逻辑（WithBalancer、WithHealthCheck 等选项同样生效）
+type MemoryDiscovery struct {
+	store *MemoryStore
+	opts  options
+	selector
+}
+
+var _ Discovery = (*MemoryDiscovery)(nil)
+
+// NewMemoryDiscovery 创建从 store 发现服务的进程内发现端
+func NewMemoryDiscovery(store *MemoryStore, opts ...Option) *MemoryDiscovery {
+	o := newOptions(opts)
+	return &MemoryDiscovery{store: store, opts: o, selector: newSelector(o)}
+}
+
+func (d *MemoryDiscovery) GetServiceAddr(ctx context.Context, name string) (string, error) {
+	instances, err := d.healthyInstances(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return d.pick(name, instances).Addr, nil
+}
+
+// GetAllServiceAddrs 返回服务全部健康实例的地址，去重并排序
+func (d *MemoryDiscovery) GetAllServiceAddrs(ctx context.Context, name string) ([]string, error) {
+	instances, err := d.healthyInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueAddrs(instances), nil
+}
+
+// GetServiceInstances 返回服务的全部实例，按 key 排序
+func (d *MemoryDiscovery) GetServiceInstances(ctx context.Context, name string) ([]ServiceInstance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	instances := d.store.list(name)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	return instances, nil
+}
+
+// GetServiceAddrForKey 按路由 key 选择实例，规则与 DiscoveryEtcd.GetServiceAddrForKey 相同
+func (d *MemoryDiscovery) GetServiceAddrForKey(ctx context.Context, name, key string) (string, error) {
+	instances, err := d.healthyInstances(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return d.keyed.PickKey(name, key, instances).Addr, nil
+}
+
+func (d *MemoryDiscovery) healthyInstances(ctx context.Context, name string) ([]ServiceInstance, error) {
+	instances, err := d.GetServiceInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.healthy(instances)
+}
+
+// WatchService 与 DiscoveryEtcd.WatchService 语义相同：订阅后立即发送一次当前选中的地址，
+// 实例集合变化时发送新地址，没有实例时发送空字符串；通道按 WithWatchBuffer 缓冲，满时丢弃最旧的地址，ctx 结束后关闭
+func (d *MemoryDiscovery) WatchService(ctx context.Context, name string) (<-chan string, error) {
+	notify, unsubscribe := d.store.subscribe(name)
+	ch := make(chan string, d.opts.watchBuffer)
+	go func() {
+		defer close(ch)
+		defer unsubscribe()
+		var last []ServiceInstance
+		for first := true; ; first = false {
+			// 与 DiscoveryEtcd 一致，开启 WithDrainAware 时排空中的实例不参与选择，进入排空同样视为实例集合变化
+			instances := d.serving(d.store.list(name))
+			if first || !sameInstances(last, instances) {
+				addr := ""
+				if len(instances) > 0 {
+					addr = d.pick(name, instances).Addr
+				}
+				sendDropOldest(ch, addr)
+			}
+			last = instances
+			select {
+			case <-ctx.Done():
+				return
+			case <-notify:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// sameInstances 判断两组按 key 排序的实例是否有相同的 key 和地址，WatchService 和哈希环用它判断实例集合是否变化
+func sameInstances(a, b []ServiceInstance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Addr != b[i].Addr {
+			return false
+		}
+	}
+	return true
+}
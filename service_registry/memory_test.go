@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryRegistryDiscovery(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewMemoryRegistry(store)
+	discovery := NewMemoryDiscovery(store, WithBalancer(NewRoundRobinBalancer()))
+	ctx := context.Background()
+
+	var keys []string
+	for _, addr := range []string{"localhost:9901", "localhost:9902"} {
+		key, err := registry.Registry(ctx, &OrderService{name: "memory_service", addr: addr})
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", addr, err)
+		}
+		keys = append(keys, key)
+	}
+	if _, err := registry.Registry(ctx, &OrderService{name: "memory_service", addr: "localhost9903"}); err == nil {
+		t.Errorf("Expected the same address validation as RegistryEtcd")
+	}
+
+	addrs, err := discovery.GetAllServiceAddrs(ctx, "memory_service")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"localhost:9901", "localhost:9902"}) {
+		t.Fatalf("GetAllServiceAddrs = %v, %v", addrs, err)
+	}
+	// 轮询选择按 key 排序后的实例，与 DiscoveryEtcd 的选择路径相同
+	instances, _ := discovery.GetServiceInstances(ctx, "memory_service")
+	for i := 0; i < 4; i++ {
+		addr, err := discovery.GetServiceAddr(ctx, "memory_service")
+		if err != nil {
+			t.Fatalf("Failed to get service address: %v", err)
+		}
+		if want := instances[i%2].Addr; addr != want {
+			t.Errorf("pick %d = %s, want %s", i, addr, want)
+		}
+	}
+
+	if err := registry.DeRegistryService(ctx, keys[0]); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if err := registry.DeRegistry(ctx); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if _, err := discovery.GetServiceAddr(ctx, "memory_service"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Expected ErrServiceNotFound after DeRegistry, got %v", err)
+	}
+}
+
+func TestMemoryDiscoveryHealthCheck(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewMemoryRegistry(store)
+	for _, addr := range []string{"localhost:9911", "localhost:9912"} {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: "memory_health_service", addr: addr}); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+	}
+	healthy := map[string]bool{"localhost:9912": true}
+	discovery := NewMemoryDiscovery(store, WithHealthCheck(func(addr string) bool { return healthy[addr] }))
+	for i := 0; i < 10; i++ {
+		if addr, err := discovery.GetServiceAddr(context.Background(), "memory_health_service"); err != nil || addr != "localhost:9912" {
+			t.Fatalf("GetServiceAddr = %s, %v, want only the healthy instance", addr, err)
+		}
+	}
+	healthy = map[string]bool{}
+	discovery = NewMemoryDiscovery(store, WithHealthCheck(func(addr string) bool { return healthy[addr] }))
+	if _, err := discovery.GetServiceAddr(context.Background(), "memory_health_service"); !errors.Is(err, ErrNoHealthyInstances) {
+		t.Errorf("Expected ErrNoHealthyInstances, got %v", err)
+	}
+}
+
+func TestMemoryWatchService(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewMemoryRegistry(store)
+	discovery := NewMemoryDiscovery(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := discovery.WatchService(ctx, "memory_watch_service")
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "" {
+		t.Errorf("Expected empty address before registration, got %q", addr)
+	}
+	key, err := registry.Registry(context.Background(), &OrderService{name: "memory_watch_service", addr: "localhost:9921"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "localhost:9921" {
+		t.Errorf("Expected registered address, got %q", addr)
+	}
+	// 其他服务的变化不会触发通知
+	registry.Registry(context.Background(), &OrderService{name: "other_memory_service", addr: "localhost:9922"})
+	select {
+	case addr := <-ch:
+		t.Errorf("Unexpected notification %q for another service", addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := registry.DeRegistryService(context.Background(), key); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "" {
+		t.Errorf("Expected empty address after deregistration, got %q", addr)
+	}
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("Expected channel to be closed after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Channel not closed after cancel")
+	}
+}
+
+func TestMemoryWatchServiceDrainAware(t *testing.T) {
+	const name = "memory_drain_watch_service"
+	store := NewMemoryStore()
+	registry := NewMemoryRegistry(store)
+	discovery := NewMemoryDiscovery(store, WithDrainAware())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9931"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	draining := metadataService{OrderService: &OrderService{name: name, addr: "localhost:9932"}, meta: map[string]string{MetadataDraining: "true"}}
+	if _, err := registry.Registry(context.Background(), draining); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	ch, err := discovery.WatchService(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "localhost:9931" {
+		t.Errorf("Expected only the serving instance, got %q", addr)
+	}
+
+	// 剩下的实例进入排空后没有可选的实例
+	instances, err := discovery.GetServiceInstances(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	for _, inst := range instances {
+		if inst.Key == key {
+			inst.Metadata = map[string]string{MetadataDraining: "true"}
+			store.put(inst)
+		}
+	}
+	if addr := receiveAddr(t, ch); addr != "" {
+		t.Errorf("Expected empty address once every instance is draining, got %q", addr)
+	}
+}
+
+func TestMemoryWatchServiceBuffer(t *testing.T) {
+	const name = "memory_buffered_watch_service"
+	store := NewMemoryStore()
+	registry := NewMemoryRegistry(store)
+	discovery := NewMemoryDiscovery(store, WithWatchBuffer(3), WithPreferNewest(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := discovery.WatchService(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	if c := cap(ch); c != 3 {
+		t.Fatalf("Watch channel capacity = %d, want 3", c)
+	}
+	// 不读取通道，每次注册都让选中的地址变成最新的实例
+	for i := 1; i <= 8; i++ {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: fmt.Sprintf("localhost:994%d", i)}); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(ch); n > 3 {
+		t.Fatalf("Buffer holds %d addresses, want at most 3", n)
+	}
+	// 缓冲中保留的是最近的地址，最后一个是当前选中的地址
+	var got []string
+	for len(got) == 0 || got[len(got)-1] != "localhost:9948" {
+		got = append(got, receiveAddr(t, ch))
+	}
+	if len(got) > 3 {
+		t.Fatalf("Received %d buffered addresses, want at most 3: %v", len(got), got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("Expected addresses in registration order, got %v", got)
+		}
+	}
+}
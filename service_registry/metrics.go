@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics 收集注册和发现操作的次数与耗时，通过 WithMetrics 配置
+// 没有配置时不会调用任何方法，也不会为统计耗时读取时钟
+type Metrics interface {
+	// ObserveRegistry 记录一次 Registry 调用的耗时和结果
+	ObserveRegistry(dur time.Duration, err error)
+	// ObserveLookup 记录一次服务实例查询的耗时和结果
+	ObserveLookup(name string, dur time.Duration, err error)
+	// IncKeepAlive 记录一次成功的租约续约
+	IncKeepAlive()
+}
+
+// PrometheusMetrics 是基于 Prometheus 的 Metrics 实现
+type PrometheusMetrics struct {
+	registrations        *prometheus.CounterVec
+	registrationDuration prometheus.Histogram
+	lookups              *prometheus.CounterVec
+	lookupDuration       *prometheus.HistogramVec
+	keepAlives           prometheus.Counter
+}
+
+// NewPrometheusMetrics 创建指标并注册到 reg，reg 为 nil 时注册到 prometheus.DefaultRegisterer
+func NewPrometheusMetrics(reg prometheus.Registerer) (*PrometheusMetrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &PrometheusMetrics{
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "service_registry_registrations_total",
+			Help: "Number of service registrations by result.",
+		}, []string{"result"}),
+		registrationDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "service_registry_registration_duration_seconds",
+			Help:    "Time taken to register a service instance.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "service_registry_lookups_total",
+			Help: "Number of service instance lookups by service and result.",
+		}, []string{"service", "result"}),
+		lookupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "service_registry_lookup_duration_seconds",
+			Help:    "Time taken to look up service instances.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"service"}),
+		keepAlives: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "service_registry_keepalives_total",
+			Help: "Number of successful lease keepalive responses.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.registrations, m.registrationDuration, m.lookups, m.lookupDuration, m.keepAlives} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *PrometheusMetrics) ObserveRegistry(dur time.Duration, err error) {
+	m.registrations.WithLabelValues(resultLabel(err)).Inc()
+	m.registrationDuration.Observe(dur.Seconds())
+}
+
+func (m *PrometheusMetrics) ObserveLookup(name string, dur time.Duration, err error) {
+	m.lookups.WithLabelValues(name, resultLabel(err)).Inc()
+	m.lookupDuration.WithLabelValues(name).Observe(dur.Seconds())
+}
+
+func (m *PrometheusMetrics) IncKeepAlive() {
+	m.keepAlives.Inc()
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counterValue 从 Gather 的结果中取出带指定标签的计数器值
+func counterValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	const name = "metered_service"
+	reg := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatalf("Failed to create metrics: %v", err)
+	}
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9681"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	if _, err := discovery.GetServiceAddr(context.Background(), name); err != nil {
+		t.Fatalf("Failed to get service address: %v", err)
+	}
+	discovery.GetServiceAddr(context.Background(), "missing_metered_service")
+
+	if v := counterValue(t, reg, "service_registry_registrations_total", map[string]string{"result": "ok"}); v != 1 {
+		t.Errorf("registrations ok = %v, want 1", v)
+	}
+	if v := counterValue(t, reg, "service_registry_lookups_total", map[string]string{"service": name, "result": "ok"}); v != 1 {
+		t.Errorf("lookups ok = %v, want 1", v)
+	}
+	if v := counterValue(t, reg, "service_registry_lookups_total", map[string]string{"service": "missing_metered_service", "result": "error"}); v != 1 {
+		t.Errorf("lookups error = %v, want 1", v)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestNamespaceIsolation(t *testing.T) {
+	const name = "namespaced_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithNamespace("/prod/"))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9611"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	if resp, err := cli.Get(context.Background(), "/prod/"+key); err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("Expected key under /prod/, err %v", err)
+	}
+
+	staging, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithNamespace("/staging/"))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer staging.Close()
+	if addr, err := staging.GetServiceAddr(context.Background(), name); err == nil {
+		t.Errorf("Expected service to be invisible from /staging/, got %s", addr)
+	}
+
+	prod, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithNamespace("/prod/"))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer prod.Close()
+	instances, err := prod.instances(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(instances) != 1 || instances[0].Addr != "localhost:9611" || instances[0].Key != key {
+		t.Errorf("Unexpected instances %+v", instances)
+	}
+	if strings.HasPrefix(instances[0].Key, "/prod/") {
+		t.Errorf("Expected namespace stripped from key %s", instances[0].Key)
+	}
+}
+
+// TestNamespaceProxy 经过命名空间代理的写入、事务和 Watch 在原始客户端看来都位于前缀下，返回给调用方的 key 和服务名不带前缀
+func TestNamespaceProxy(t *testing.T) {
+	const ns, name = "/namespace_proxy_test/", "proxied_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithNamespace(ns), WithServiceIndex())
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithNamespace(ns))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := discovery.WatchServiceEvents(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+
+	if ok, err := registry.TryRegistry(context.Background(), &OrderService{name: name, addr: "localhost:9621"}); err != nil || !ok {
+		t.Fatalf("Failed to register: %v, %v", ok, err)
+	}
+	var key string
+	select {
+	case ev := <-events:
+		key = ev.Key
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the registration event")
+	}
+	if !strings.HasPrefix(key, name+"/") {
+		t.Errorf("Expected an unprefixed key in the watch event, got %s", key)
+	}
+
+	cli := newTestClient(t)
+	defer cli.Close()
+	resp, err := cli.Get(context.Background(), ns, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Failed to read raw keys: %v", err)
+	}
+	raw := make(map[string]int64)
+	for _, kv := range resp.Kvs {
+		raw[string(kv.Key)] = kv.Lease
+	}
+	if lease, ok := raw[ns+key]; !ok || lease == 0 {
+		t.Errorf("Expected instance key %s bound to a lease, raw keys %v", ns+key, raw)
+	}
+	if _, ok := raw[ns+serviceIndexKey(name)]; !ok {
+		t.Errorf("Expected index key under the namespace, raw keys %v", raw)
+	}
+	if lease, ok := raw[ns+instanceClaimKey(name, "localhost:9621")]; !ok || lease != raw[ns+key] {
+		t.Errorf("Expected claim key on the instance lease, raw keys %v", raw)
+	}
+	if resp, err := cli.Get(context.Background(), name+"/", clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil || resp.Count != 0 {
+		t.Errorf("Expected nothing outside the namespace, got %v keys (%v)", resp.Count, err)
+	}
+
+	names, err := discovery.ListServices(context.Background())
+	if err != nil {
+		t.Fatalf("ListServices failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != name {
+		t.Errorf("Expected unprefixed service names, got %v", names)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Option 配置 RegistryEtcd 和 DiscoveryEtcd，两端共用同一套选项
+type Option func(*options)
+
+type options struct {
+	// 全部 key 的命名空间前缀，例如 /prod/，为空时不加前缀
+	namespace string
+	// 连接 etcd 的 TLS 证书和用户名密码，为空时使用明文连接、不认证
+	tlsCertFile string
+	tlsKeyFile  string
+	tlsCAFile   string
+	username    string
+	password    string
+	// 构造时确认至少一个 endpoint 可达，见 WithEagerConnect
+	eagerConnect bool
+	// gRPC 连接的 keepalive 探测间隔和超时，见 WithDialKeepAlive
+	dialKeepAliveTime    time.Duration
+	dialKeepAliveTimeout time.Duration
+	// 从集群同步 endpoint 列表的间隔，0 表示不同步
+	autoSyncInterval time.Duration
+	// 注册端编码和发现端解码记录的 Codec，默认 StringCodec
+	codec Codec
+	// 记录（key + value）允许的最大字节数，应与 etcd 的 --max-request-bytes 一致
+	maxRecordBytes int
+	// 注册时维护 /index/{name} 索引 key
+	serviceIndex bool
+	// 注册前是否检查服务地址格式，默认检查
+	addrValidation bool
+	// 注册端写入记录的地址，为 nil 时使用 Service.Addr()，见 WithAdvertiseAddr
+	advertiseAddr func(Service) string
+	// 发现端读取记录后对地址的改写，为 nil 时不改写，见 WithAddrRewrite
+	addrRewrite func(string) string
+	// 写入记录元数据的健康检查地址，为空时不写
+	healthCheckURL string
+	// 单个 etcd 操作的重试策略和总超时
+	opRetry        RetryPolicy
+	requestTimeout time.Duration
+	// Registry 在 etcd 暂时不可达时持续重试的最长时间，0 表示不重试，见 WithConnectRetry
+	connectRetry time.Duration
+	// 租约丢失后重新注册的重试策略，MaxAttempts 为 0 时不重新注册
+	reRegister RetryPolicy
+	// 租约丢失后重新注册的随机抖动比例，见 WithKeepAliveJitter
+	keepAliveJitter float64
+	// 多久收不到续约响应就认为续约卡住，0 表示使用租约 TTL 的两倍，见 WithKeepAliveStallTimeout
+	keepAliveStall time.Duration
+	// 注册端是否让全部实例共用一个租约，见 WithSharedLease
+	sharedLease bool
+	// RunUntilSignal 注销全部服务的最长时间
+	shutdownTimeout time.Duration
+	// 结束时注销通过 Registry 注册的实例，见 WithDeregisterOnContextDone
+	deregisterCtx context.Context
+	// 发现端是否跳过正在排空的实例，见 WithDrainAware
+	drainAware bool
+	// 发现端返回实例前的健康探测及探测结果的缓存时间
+	healthCheck    func(addr string) bool
+	healthCheckTTL time.Duration
+	// 日志输出，默认丢弃
+	logger Logger
+	// 注册和发现操作的指标收集器，为 nil 时不统计
+	metrics Metrics
+	// 发现端是否用 Watch 维护本地实例缓存
+	instanceCache bool
+	// 缓存的实例列表最长使用多久，超过后下一次查询重新读取，0 表示只依赖 Watch，见 WithCacheTTL
+	cacheTTL time.Duration
+	// 发现端选择实例的负载均衡策略
+	balancer LoadBalancer
+	// 每个服务的订阅者上限及超出上限时的处理方式，0 表示不限制
+	maxSubscribers   int
+	subscriberPolicy SubscriberPolicy
+	// WatchService 返回的通道的缓冲大小，见 WithWatchBuffer
+	watchBuffer int
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		codec:                StringCodec{},
+		dialKeepAliveTime:    DefaultDialKeepAliveTime,
+		dialKeepAliveTimeout: DefaultDialKeepAliveTimeout,
+		addrValidation:       true,
+		maxRecordBytes:       DefaultMaxRecordBytes,
+		balancer:             RandomBalancer{},
+		logger:               noopLogger{},
+		healthCheckTTL:       DefaultHealthCheckTTL,
+		shutdownTimeout:      DefaultShutdownTimeout,
+		watchBuffer:          1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithTLS 使用 TLS 连接 etcd：certFile 和 keyFile 是客户端证书（双向认证时需要，可以为空），
+// caFile 是校验服务端证书的 CA（为空时使用系统 CA）。证书无法加载时构造函数返回错误
+func WithTLS(certFile, keyFile, caFile string) Option {
+	return func(o *options) {
+		o.tlsCertFile = certFile
+		o.tlsKeyFile = keyFile
+		o.tlsCAFile = caFile
+	}
+}
+
+// WithAuth 使用 etcd 的用户名密码认证
+func WithAuth(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithEagerConnect 让构造函数在 dialTimeout 内对每个 endpoint 发送 Status 请求，至少一个响应才返回客户端
+// 全部失败时构造函数返回的错误列出每个 endpoint 的失败原因，endpoint 写错时启动即失败，而不是等到第一次注册或查询
+func WithEagerConnect() Option {
+	return func(o *options) {
+		o.eagerConnect = true
+	}
+}
+
+// WithDialKeepAlive 设置 gRPC 连接的 keepalive：连接空闲 interval 后发送探测，timeout 内没有响应就断开重连
+// 默认 DefaultDialKeepAliveTime 和 DefaultDialKeepAliveTimeout，避免 NAT 悄悄丢弃空闲连接后下一次操作一直卡到超时；
+// interval 为 0 时关闭 keepalive。interval 不应小于 etcd 的 --grpc-keepalive-min-time（默认 5s），否则连接会被服务端断开
+func WithDialKeepAlive(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		o.dialKeepAliveTime = interval
+		o.dialKeepAliveTimeout = timeout
+	}
+}
+
+// WithAutoSyncInterval 每隔 d 从集群读取成员列表并更新客户端的 endpoint，集群扩缩容后不需要修改配置
+// 默认不同步：通过代理或负载均衡访问 etcd 时，成员公布的地址可能无法从客户端直接访问，常用的间隔是 30s 到 1min
+func WithAutoSyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.autoSyncInterval = d
+	}
+}
+
+// WithNamespace 给注册端和发现端的全部 key 加上前缀，用来隔离共用一个 etcd 集群的不同环境
+// 客户端的 KV、Watcher 和 Lease 用 etcd 的 namespace 代理包装，读写和 Watch 自动加上前缀，
+// 返回的 key 自动去掉前缀；两端需要使用相同的命名空间才能互相看到
+func WithNamespace(prefix string) Option {
+	return func(o *options) {
+		o.namespace = prefix
+	}
+}
+
+// WithRecordFormat 设置注册记录的编码格式，默认为纯字符串地址；等价于 WithCodec 对应格式的内置 Codec
+func WithRecordFormat(format RecordFormat) Option {
+	return func(o *options) {
+		o.codec = codecForFormat(format)
+	}
+}
+
+// WithCodec 设置注册端和发现端使用的 Codec，两端必须一致，见 Codec
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithMaxRecordBytes 设置注册记录允许的最大字节数，etcd 调整过 --max-request-bytes 时同步修改
+// 超过限制的注册在写入前返回 ErrRecordTooLarge，而不是 etcd 返回的 gRPC 错误
+func WithMaxRecordBytes(n int) Option {
+	return func(o *options) {
+		o.maxRecordBytes = n
+	}
+}
+
+// WithServiceIndex 让注册端为每个服务名维护一个索引 key（/index/{name}），
+// 发现端可以通过 ListIndexedServices 按服务数量而不是实例数量的代价列出服务名
+func WithServiceIndex() Option {
+	return func(o *options) {
+		o.serviceIndex = true
+	}
+}
+
+// WithAddrValidation 控制注册前是否检查服务地址是 host:port 或带 scheme 的 URL，默认检查
+// 地址格式特殊的传输方式可以用 WithAddrValidation(false) 关闭
+func WithAddrValidation(enabled bool) Option {
+	return func(o *options) {
+		o.addrValidation = enabled
+	}
+}
+
+// WithAdvertiseAddr 让注册端写入 fn 返回的地址，而不是 Service.Addr()，
+// 例如 Kubernetes 中的 pod 对外公布 NodePort 或负载均衡地址，不需要修改 Service 的实现
+// 公布的地址同样经过 WithAddrValidation 的检查
+func WithAdvertiseAddr(fn func(service Service) string) Option {
+	return func(o *options) {
+		o.advertiseAddr = fn
+	}
+}
+
+// WithAddrRewrite 让发现端在读取记录后用 fn 改写地址，查询、缓存和各种 Watch 看到的都是改写后的地址
+// 适合消费方所在网络需要把注册的地址映射成另一个地址的场景
+func WithAddrRewrite(fn func(addr string) string) Option {
+	return func(o *options) {
+		o.addrRewrite = fn
+	}
+}
+
+// WithHealthCheckURL 在注册记录的元数据中写入健康检查地址，供外部负载均衡器探测
+// 可以是完整的 http(s) URL，也可以是以 / 开头的路径（相对于服务地址）
+// 元数据需要 JSON 或 protobuf 格式承载
+func WithHealthCheckURL(u string) Option {
+	return func(o *options) {
+		o.healthCheckURL = u
+	}
+}
+
+// WithOpRetry 设置单个 etcd 操作的重试策略，默认不重试
+func WithOpRetry(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.opRetry = policy
+	}
+}
+
+// WithRetry 是 WithOpRetry 的简写：最多尝试 maxAttempts 次，等待时间从 baseDelay 开始指数增长并带 ±50% 抖动
+// 只有连接不可用、leader 选举中这类临时错误会重试，ctx 结束后立即返回
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return WithOpRetry(RetryPolicy{MaxAttempts: maxAttempts, BaseDelay: baseDelay, Jitter: 0.5})
+}
+
+// WithRequestTimeout 限制单个 etcd 操作的总耗时，包括 WithOpRetry 的全部重试和退避等待
+// 超时后不再重试，返回的错误同时包含超时和最后一次失败的原因
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithConnectRetry 让 Registry 在 etcd 暂时不可达时带退避地重试申请租约和写入记录，最多等待 maxWait
+// 适合 docker-compose 这类 etcd 和服务同时启动的场景：服务先于 etcd 就绪时不会在第一次失败时直接退出
+// 只有连接不可用这类临时错误会重试，重试也受 Registry 的 ctx 约束
+func WithConnectRetry(maxWait time.Duration) Option {
+	return func(o *options) {
+		o.connectRetry = maxWait
+	}
+}
+
+// WithReRegister 在租约意外丢失时自动重新申请租约并写回同一个 key，policy 控制尝试次数和间隔
+// 每次丢失都会先在 KeepAliveErrors 上报 ErrLeaseLost；默认不重新注册
+func WithReRegister(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.reRegister = policy
+	}
+}
+
+// WithSharedLease 让 RegistryEtcd 的全部实例挂在一个由 LeaseManager 管理的共享租约上，只运行一个续约 goroutine
+// 共享租约使用注册中心的 leaseTTL，TTLAware 的单独 TTL 不再生效；DeRegistry 撤销共享租约，原子地删除全部实例
+func WithSharedLease() Option {
+	return func(o *options) {
+		o.sharedLease = true
+	}
+}
+
+// WithShutdownTimeout 设置 RunUntilSignal 注销全部服务的最长时间，默认 DefaultShutdownTimeout
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// WithDeregisterOnContextDone 让之后通过 Registry 注册的每个实例在 ctx 结束时立即注销（显式删除 key 并撤销租约），
+// 不必等租约过期；注销在 WithShutdownTimeout 设置的时间内完成。适合自己管理 context、不使用 RunUntilSignal 的调用方
+func WithDeregisterOnContextDone(ctx context.Context) Option {
+	return func(o *options) {
+		o.deregisterCtx = ctx
+	}
+}
+
+// WithKeepAliveJitter 给租约丢失后的重新注册加上随机抖动，避免 etcd 节点重启后全部客户端同时重新注册
+// 第一次重新注册前随机等待 [0, fraction*TTL/3]，之后每次退避在 [1-fraction, 1+fraction] 倍之间抖动
+// fraction 限制在 [0, 0.5]：第一次等待不超过 TTL/6，远小于一个续约周期
+// 续约请求本身由 clientv3 按 TTL/3 的周期发送，不受这个选项影响
+func WithKeepAliveJitter(fraction float64) Option {
+	return func(o *options) {
+		o.keepAliveJitter = math.Max(0, math.Min(fraction, maxKeepAliveJitter))
+	}
+}
+
+// WithKeepAliveStallTimeout 设置续约卡住的判定时间：续约通道没有关闭，但超过 timeout 没有收到任何响应时，
+// 按租约丢失处理（上报 ErrLeaseLost，配置了 WithReRegister 时重新注册），并撤销旧租约
+// 正常情况下每 TTL/3 收到一次响应，默认的 TTL*2 足以容忍几次请求失败
+func WithKeepAliveStallTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.keepAliveStall = timeout
+	}
+}
+
+// WithDrainAware 让发现端选择实例时跳过正在排空的实例（见 RegistryEtcd.DeRegistryWithDrain），
+// GetServiceAddr、GetAllServiceAddrs 和 WatchService 都不再返回它们；全部实例都在排空时返回 ErrNoHealthyInstances
+func WithDrainAware() Option {
+	return func(o *options) {
+		o.drainAware = true
+	}
+}
+
+// WithHealthCheck 让发现端在返回实例前用 fn 探测地址，跳过探测失败的实例
+// 用于过滤进程已经崩溃但租约尚未过期的实例；探测结果缓存 WithHealthCheckTTL 指定的时间
+func WithHealthCheck(fn func(addr string) bool) Option {
+	return func(o *options) {
+		o.healthCheck = fn
+	}
+}
+
+// WithHealthCheckTTL 设置健康探测结果的缓存时间，默认 DefaultHealthCheckTTL
+func WithHealthCheckTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.healthCheckTTL = ttl
+	}
+}
+
+// WithLogger 设置日志输出，记录租约申请、续约丢失、重新注册和 Watch 错误，默认不输出
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithMetrics 收集注册、续约和查询的次数与耗时，可以使用 NewPrometheusMetrics 创建的实现
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithInstanceCache 让发现端在第一次查询某个服务时启动后台 Watch，之后的查询直接读取本地缓存
+// 缓存随 Watch 事件更新，刚注册的实例可能要稍后才能查到；Close 停止全部后台 Watch
+func WithInstanceCache() Option {
+	return func(o *options) {
+		o.instanceCache = true
+	}
+}
+
+// WithCacheTTL 给实例缓存加一层兜底：缓存的实例列表超过 d 之后，下一次查询重新从 etcd 读取，
+// 不管 Watch 是否还在工作，Watch 悄悄失效时缓存最多陈旧 d。每次刷新的有效期有 ±10% 的随机抖动，
+// 避免大量客户端同时刷新。设置后自动开启 WithInstanceCache
+func WithCacheTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.instanceCache = true
+		o.cacheTTL = d
+	}
+}
+
+// WithBalancer 设置发现端选择实例的负载均衡策略，默认为 RandomBalancer
+func WithBalancer(b LoadBalancer) Option {
+	return func(o *options) {
+		o.balancer = b
+	}
+}
+
+// WithPreferNewest 让发现端以 bias（0~1）的概率选择 CreateRevision 最大的实例，其余情况均匀随机
+// 部署期间新旧实例并存时，可以把流量逐步偏向新实例；等价于 WithBalancer(PreferNewestBalancer{Bias: bias})
+func WithPreferNewest(bias float64) Option {
+	return WithBalancer(PreferNewestBalancer{Bias: bias})
+}
+
+// WithMaxSubscribers 限制每个服务通过 Subscribe 建立的订阅数量，防止调用方泄漏订阅导致资源无限增长
+// 超过上限时按 policy 拒绝新订阅，或挤掉空闲最久的订阅：积压着未读变更且积压时间最长的订阅，
+// 全部订阅都已读完时挤掉创建最早的订阅
+func WithMaxSubscribers(n int, policy SubscriberPolicy) Option {
+	return func(o *options) {
+		o.maxSubscribers = n
+		o.subscriberPolicy = policy
+	}
+}
+
+// WithWatchBuffer 设置 WatchService 返回的通道最多缓冲 n 个尚未读取的地址，默认为 1，只保留最新的地址
+// 缓冲满时丢弃最旧的地址再放入新地址，消费慢时 Watch 不会因为发送而阻塞；n 小于 1 时按 1 处理
+func WithWatchBuffer(n int) Option {
+	return func(o *options) {
+		o.watchBuffer = max(n, 1)
+	}
+}
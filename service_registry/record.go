@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RecordFormat 是记录的编码格式，带标识的格式会把标识写在记录第一个字节
+// 标识使用不可打印字符，不会和旧版本写入的纯字符串地址冲突
+type RecordFormat byte
+
+const (
+	// FormatPlain 表示不带标识的旧格式：value 就是地址本身
+	// 它只用来在注册端选择格式，0x00 不是合法的格式标识，不会被写进记录
+	FormatPlain RecordFormat = 0x00
+	FormatJSON  RecordFormat = 0x01
+	FormatProto RecordFormat = 0x02
+)
+
+// 第一个字节小于该值的记录被视为带标识的记录，地址不会以控制字符开头
+const minPrintableByte = 0x20
+
+// DefaultMaxRecordBytes 是 etcd 默认的最大请求大小（--max-request-bytes，1.5MiB）
+const DefaultMaxRecordBytes = 1536 * 1024
+
+// ErrRecordTooLarge 表示编码后的记录超过了 etcd 允许的请求大小
+var ErrRecordTooLarge = errors.New("service record too large")
+
+// ServiceRecord 是 etcd 中一条服务记录的内容
+type ServiceRecord struct {
+	Addr     string            `json:"addr"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+const (
+	// MetadataHealthCheckURL 是记录元数据中健康检查地址的键
+	MetadataHealthCheckURL = "health_check_url"
+	// MetadataWeight 是记录元数据中实例权重的键，供 WeightedBalancer 使用
+	MetadataWeight = "weight"
+	// MetadataLoad 是记录元数据中实例当前负载的键，由 RegistryEtcd.UpdateLoad 写入，供 LeastLoadedBalancer 使用
+	MetadataLoad = "load"
+	// MetadataDraining 是记录元数据中排空标记的键，由 RegistryEtcd.DeRegistryWithDrain 写入，值为 true
+	MetadataDraining = "draining"
+	// MetadataTier 是记录元数据中实例优先级层级的键，0 为主，1 为备，依次类推，供 TieredBalancer 使用
+	MetadataTier = "tier"
+)
+
+// Weight 返回记录中的实例权重，没有登记或不是正整数时为 1
+func (r ServiceRecord) Weight() int {
+	w, err := strconv.Atoi(r.Metadata[MetadataWeight])
+	if err != nil || w <= 0 {
+		return 1
+	}
+	return w
+}
+
+// Load 返回记录中登记的实例负载，没有登记或无法解析时为 0
+func (r ServiceRecord) Load() float64 {
+	load, err := strconv.ParseFloat(r.Metadata[MetadataLoad], 64)
+	if err != nil || math.IsNaN(load) || load < 0 {
+		return 0
+	}
+	return load
+}
+
+// Tier 返回记录中的优先级层级，数值越小越优先，没有登记或不是非负整数时为 0
+func (r ServiceRecord) Tier() int {
+	tier, err := strconv.Atoi(r.Metadata[MetadataTier])
+	if err != nil || tier < 0 {
+		return 0
+	}
+	return tier
+}
+
+// Draining 判断实例是否正在排空，排空中的实例即将注销，不应再接收新请求
+func (r ServiceRecord) Draining() bool {
+	return r.Metadata[MetadataDraining] == "true"
+}
+
+// HealthCheckURL 返回记录中的健康检查地址，路径形式的地址会补全为 http://{Addr}{path}
+// 没有登记健康检查地址时返回空字符串
+func (r ServiceRecord) HealthCheckURL() string {
+	u := r.Metadata[MetadataHealthCheckURL]
+	if strings.HasPrefix(u, "/") {
+		return "http://" + r.Addr + u
+	}
+	return u
+}
+
+// validateHealthCheckURL 检查健康检查地址是以 / 开头的路径或带主机名的 http(s) URL
+func validateHealthCheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid health check url %q: %w", raw, err)
+	}
+	if u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/") {
+		return nil
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return nil
+	}
+	return fmt.Errorf("invalid health check url %q: want an http(s) url or an absolute path", raw)
+}
+
+// serviceKeySeparator 分隔实例 key 中的服务名和 uuid
+// 服务名不允许包含它，所以 order_service/ 这个前缀不会匹配到 order_service_v2 或 order_service-v2 的实例
+const serviceKeySeparator = "/"
+
+// newServiceKey 为服务实例生成 key：服务名/uuid
+func newServiceKey(name string) string {
+	return serviceKeyPrefix(name) + uuid.New().String()
+}
+
+// serviceKeyPrefix 返回服务全部实例 key 的公共前缀
+func serviceKeyPrefix(name string) string {
+	return name + serviceKeySeparator
+}
+
+// serviceNameFromKey 从实例 key（服务名/uuid）中取出服务名，不是实例 key 时返回 false
+func serviceNameFromKey(key string) (string, bool) {
+	i := strings.LastIndex(key, serviceKeySeparator)
+	if i < 0 {
+		return "", false
+	}
+	name := key[:i]
+	if validateServiceName(name) != nil {
+		return "", false
+	}
+	if _, err := uuid.Parse(key[i+len(serviceKeySeparator):]); err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// validateServiceName 检查服务名非空且不含 key 分隔符
+func validateServiceName(name string) error {
+	if name == "" {
+		return errors.New("invalid service name: empty")
+	}
+	if strings.Contains(name, serviceKeySeparator) {
+		return fmt.Errorf("invalid service name %q: must not contain %q", name, serviceKeySeparator)
+	}
+	return nil
+}
+
+// validateAddr 检查服务地址是 host:port 或带 scheme 的 URL（如 grpc://host:port、unix:///tmp/app.sock）
+func validateAddr(addr string) error {
+	if addr == "" {
+		return errors.New("invalid service address: empty")
+	}
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("invalid service address %q: %w", addr, err)
+		}
+		if u.Host == "" && u.Path == "" {
+			return fmt.Errorf("invalid service address %q: missing host or path after scheme", addr)
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid service address %q: want host:port: %w", addr, err)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid service address %q: missing host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid service address %q: bad port %q", addr, port)
+	}
+	return nil
+}
+
+// protobuf 字段编号，对应：
+//
+//	message ServiceRecord {
+//	    string addr = 1;
+//	    map<string, string> metadata = 2;
+//	}
+const (
+	protoFieldAddr     protowire.Number = 1
+	protoFieldMetadata protowire.Number = 2
+	protoFieldMapKey   protowire.Number = 1
+	protoFieldMapValue protowire.Number = 2
+)
+
+// EncodeRecord 按指定格式编码记录，JSON 和 protobuf 格式会带上格式标识前缀
+func EncodeRecord(format RecordFormat, rec ServiceRecord) ([]byte, error) {
+	switch format {
+	case FormatPlain:
+		if len(rec.Metadata) > 0 {
+			return nil, errors.New("plain record format cannot carry metadata")
+		}
+		return []byte(rec.Addr), nil
+	case FormatJSON:
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{byte(FormatJSON)}, data...), nil
+	case FormatProto:
+		return append([]byte{byte(FormatProto)}, encodeProtoRecord(rec)...), nil
+	default:
+		return nil, fmt.Errorf("unknown record format: %#x", byte(format))
+	}
+}
+
+// DecodeRecord 根据第一个字节的格式标识选择解码器，没有标识的按旧格式当作纯地址处理
+// 以控制字符开头但标识未知的记录（例如更新版本的注册端写入的新格式）返回错误，而不是被当成地址
+func DecodeRecord(data []byte) (ServiceRecord, error) {
+	if len(data) == 0 {
+		return ServiceRecord{}, errors.New("empty service record")
+	}
+	switch RecordFormat(data[0]) {
+	case FormatJSON:
+		var rec ServiceRecord
+		if err := json.Unmarshal(data[1:], &rec); err != nil {
+			return ServiceRecord{}, fmt.Errorf("decode json record: %w", err)
+		}
+		return rec, nil
+	case FormatProto:
+		rec, err := decodeProtoRecord(data[1:])
+		if err != nil {
+			return ServiceRecord{}, fmt.Errorf("decode protobuf record: %w", err)
+		}
+		return rec, nil
+	default:
+		if data[0] < minPrintableByte {
+			return ServiceRecord{}, fmt.Errorf("unknown record format: %#x", data[0])
+		}
+		return decodePlainRecord(string(data)), nil
+	}
+}
+
+// decodePlainRecord 解析纯字符串记录，地址后可以用 | 附加 key=value 属性，例如 host:port|weight=3
+// 属性放进元数据，没有 = 的属性被忽略
+func decodePlainRecord(s string) ServiceRecord {
+	addr, attrs, ok := strings.Cut(s, "|")
+	rec := ServiceRecord{Addr: addr}
+	if !ok {
+		return rec
+	}
+	for _, attr := range strings.Split(attrs, "|") {
+		k, v, ok := strings.Cut(attr, "=")
+		if !ok || k == "" {
+			continue
+		}
+		if rec.Metadata == nil {
+			rec.Metadata = make(map[string]string)
+		}
+		rec.Metadata[k] = v
+	}
+	return rec
+}
+
+// encodePlainRecord 是 decodePlainRecord 的逆过程，属性按 key 排序附加在地址后面
+// 只在更新旧格式记录的属性时使用，注册时带元数据的记录总是编码为 JSON
+func encodePlainRecord(rec ServiceRecord) []byte {
+	keys := make([]string, 0, len(rec.Metadata))
+	for k := range rec.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(rec.Addr)
+	for _, k := range keys {
+		b.WriteString("|" + k + "=" + rec.Metadata[k])
+	}
+	return []byte(b.String())
+}
+
+func encodeProtoRecord(rec ServiceRecord) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, protoFieldAddr, protowire.BytesType)
+	b = protowire.AppendString(b, rec.Addr)
+	for k, v := range rec.Metadata {
+		// map 在 protobuf 中编码为重复的 entry 消息
+		var entry []byte
+		entry = protowire.AppendTag(entry, protoFieldMapKey, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, protoFieldMapValue, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+		b = protowire.AppendTag(b, protoFieldMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func decodeProtoRecord(b []byte) (ServiceRecord, error) {
+	var rec ServiceRecord
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ServiceRecord{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == protoFieldAddr && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return ServiceRecord{}, protowire.ParseError(n)
+			}
+			rec.Addr = v
+			b = b[n:]
+		case num == protoFieldMetadata && typ == protowire.BytesType:
+			entry, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return ServiceRecord{}, protowire.ParseError(n)
+			}
+			k, v, err := decodeProtoMapEntry(entry)
+			if err != nil {
+				return ServiceRecord{}, err
+			}
+			if rec.Metadata == nil {
+				rec.Metadata = make(map[string]string)
+			}
+			rec.Metadata[k] = v
+			b = b[n:]
+		default:
+			// 跳过未知字段，兼容新版本增加的字段
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return ServiceRecord{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return rec, nil
+}
+
+func decodeProtoMapEntry(b []byte) (key, value string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != protoFieldMapKey && num != protoFieldMapValue) {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeString(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		if num == protoFieldMapKey {
+			key = v
+		} else {
+			value = v
+		}
+		b = b[n:]
+	}
+	return key, value, nil
+}
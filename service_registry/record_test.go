@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMixedRecords(t *testing.T) {
+	meta := map[string]string{"version": "v2", "region": "cn-east"}
+	jsonRec, err := EncodeRecord(FormatJSON, ServiceRecord{Addr: "10.0.0.1:8080", Metadata: meta})
+	if err != nil {
+		t.Fatalf("Failed to encode json record: %v", err)
+	}
+	protoRec, err := EncodeRecord(FormatProto, ServiceRecord{Addr: "10.0.0.2:8080", Metadata: meta})
+	if err != nil {
+		t.Fatalf("Failed to encode protobuf record: %v", err)
+	}
+	// 旧版本注册端直接写入的地址
+	legacyRec := []byte("10.0.0.3:8080")
+
+	cases := []struct {
+		name string
+		data []byte
+		want ServiceRecord
+	}{
+		{"json", jsonRec, ServiceRecord{Addr: "10.0.0.1:8080", Metadata: meta}},
+		{"protobuf", protoRec, ServiceRecord{Addr: "10.0.0.2:8080", Metadata: meta}},
+		{"legacy", legacyRec, ServiceRecord{Addr: "10.0.0.3:8080"}},
+	}
+	for _, c := range cases {
+		got, err := DecodeRecord(c.data)
+		if err != nil {
+			t.Fatalf("Failed to decode %s record: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s record decoded as %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestEncodePlainRecordRejectsMetadata(t *testing.T) {
+	_, err := EncodeRecord(FormatPlain, ServiceRecord{Addr: "localhost:8080", Metadata: map[string]string{"k": "v"}})
+	if err == nil {
+		t.Fatalf("Expected error when encoding metadata with plain format")
+	}
+}
+
+func TestDecodeUnknownTaggedRecord(t *testing.T) {
+	// 更新版本的注册端可能写入当前版本不认识的格式标识
+	for _, data := range [][]byte{{0x03, 'x'}, {0x00, '1', '0'}} {
+		if rec, err := DecodeRecord(data); err == nil {
+			t.Errorf("Expected error for record %q, got %+v", data, rec)
+		}
+	}
+}
+
+func TestDecodePlainRecordAttributes(t *testing.T) {
+	rec, err := DecodeRecord([]byte("localhost:9531|weight=3|zone=a"))
+	if err != nil {
+		t.Fatalf("DecodeRecord failed: %v", err)
+	}
+	if rec.Addr != "localhost:9531" || rec.Weight() != 3 || rec.Metadata["zone"] != "a" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	if w := (ServiceRecord{Addr: "localhost:9532"}).Weight(); w != 1 {
+		t.Errorf("Default weight = %d, want 1", w)
+	}
+}
+
+func TestValidateAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr  string
+		valid bool
+	}{
+		{"localhost:8080", true},
+		{"10.0.0.1:9000", true},
+		{"[::1]:8080", true},
+		{"grpc://orders.internal:9000", true},
+		{"unix:///tmp/app.sock", true},
+		{"localhost8080", false},
+		{"localhost:", false},
+		{":8080", false},
+		{"localhost:http", false},
+		{"localhost:70000", false},
+		{"", false},
+		{"grpc://", false},
+	} {
+		if err := validateAddr(tc.addr); (err == nil) != tc.valid {
+			t.Errorf("validateAddr(%q) = %v, want valid %v", tc.addr, err, tc.valid)
+		}
+	}
+}
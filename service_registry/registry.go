@@ -3,31 +3,82 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const LeaseTTL = 5 // 租约时间5秒
+
+// maxKeepAliveJitter 是 WithKeepAliveJitter 允许的最大抖动比例
+const maxKeepAliveJitter = 0.5
+
 // 服务信息接口
 type Service interface {
 	Name() string
 	Addr() string
 }
 
+// MetadataAware 是 Service 的可选扩展，实现它的服务会把元数据（版本、区域、协议等）一起写入记录
+// 纯字符串格式无法携带元数据，默认格式下带元数据的记录自动改用 JSON 编码
+type MetadataAware interface {
+	Metadata() map[string]string
+}
+
+// TTLAware 是 Service 的可选扩展，实现它的服务使用自己的租约 TTL（秒），而不是注册中心的默认值
+// 需要更长宽限期（例如 GC 停顿较长）的服务可以实现它；TTL 小于等于 0 时使用默认值
+type TTLAware interface {
+	TTL() int64
+}
+
 // 服务注册的通用接口
 type Registry interface {
-	// 注册服务
-	Registry(service Service) error
-	// 注销服务
-	DeRegistry() error
+	// 注册服务，返回生成的服务 key
+	Registry(ctx context.Context, service Service) (string, error)
+	// 注销全部服务
+	DeRegistry(ctx context.Context) error
 }
 
 type RegistryEtcd struct {
-	client   *clientv3.Client
-	leaseID  clientv3.LeaseID
+	client *clientv3.Client
+	// 申请、续约和撤销租约使用的 Lease，默认就是 client，测试中可以替换成假实现
+	lease    clientv3.Lease
 	leaseTTL int64
+	opts     options
+
+	// 已注册的服务实例，key 为生成的服务 key，每个实例有独立的租约
+	mu       sync.Mutex
+	services map[string]*registeredService
+	// 续约失败的通知，见 KeepAliveErrors
+	keepAliveErrs chan error
+	// 开启 WithSharedLease 时全部实例共用的租约，第一次注册时创建
+	shared *LeaseManager
+	// 续约 goroutine，DeRegistry 和 Close 等待它们全部退出
+	keepAlives sync.WaitGroup
+}
+
+// ErrLeaseLost 表示服务实例的租约在注销之前丢失，实例已经从发现端消失
+var ErrLeaseLost = errors.New("service lease lost")
+
+// ErrServiceNotRegistered 表示 key 不是这个注册中心注册的实例，或者实例已经注销
+var ErrServiceNotRegistered = errors.New("service not registered")
+
+// registeredService 是通过 RegistryEtcd 注册的一个服务实例
+type registeredService struct {
+	name  string
+	value string // 编码后的记录，重新注册时原样写回
+	ttl   int64  // 租约 TTL（秒）
+	// 最近一次续约响应中的剩余 TTL（秒），续约 goroutine 在 r.mu 下更新，见 Registered
+	ttlLeft int64
+
+	leaseID clientv3.LeaseID
 	// LeaseKeepAliveResponse wraps the protobuf message LeaseKeepAliveResponse.
 	// type LeaseKeepAliveResponse struct {
 	// 	*pb.ResponseHeader
@@ -35,22 +86,233 @@ type RegistryEtcd struct {
 	// 	TTL int64
 	// }
 	leaseKeepAliveRespCh <-chan *clientv3.LeaseKeepAliveResponse
+	cancelKeepAlive      context.CancelFunc
+	// 实例注销或注册端关闭时关闭，续约 goroutine 和等待中的重新注册随之退出，第一次启动续约时创建
+	stop chan struct{}
+	// 挂在 LeaseManager 上的实例（WithSharedLease 或 RegistryBatch）没有自己的续约，租约由 lease 管理
+	lease *LeaseManager
+	// 取消 WithDeregisterOnContextDone 注册的回调，实例注销后调用
+	stopDeregister func() bool
+	// TryRegistry 注册的实例在同一个租约上持有的占位 key，见 instanceClaimKey
+	claim string
+}
+
+// halt 停止续约并关闭 stop，调用方持有 r.mu
+func (svc *registeredService) halt() {
+	svc.cancelKeepAlive()
+	if svc.stop == nil {
+		return
+	}
+	select {
+	case <-svc.stop:
+	default:
+		close(svc.stop)
+	}
+}
+
+// stopOnDone 取消 WithDeregisterOnContextDone 的回调，没有开启时什么也不做
+func (svc *registeredService) stopOnDone() {
+	if svc.stopDeregister != nil {
+		svc.stopDeregister()
+	}
 }
 
-func (r *RegistryEtcd) Registry(service Service) error {
+// Registry 注册服务实例并返回生成的 key（服务名/uuid），可以传给 DeRegistryService 单独注销
+// ctx 只约束注册过程中的 etcd 调用，注册成功后的续约不受 ctx 影响
+func (r *RegistryEtcd) Registry(ctx context.Context, service Service) (key string, err error) {
+	if m := r.opts.metrics; m != nil {
+		start := time.Now()
+		defer func() { m.ObserveRegistry(time.Since(start), err) }()
+	}
 	// etcd注册逻辑
+	// 先构造记录，记录不合法时不申请租约
+	serviceName, value, err := r.encode(service)
+	if err != nil {
+		return "", err
+	}
+	svc := &registeredService{name: service.Name(), value: value, ttl: r.leaseTTL}
+	if t, ok := service.(TTLAware); ok && t.TTL() > 0 {
+		svc.ttl = t.TTL()
+	}
+	err = r.connect(ctx, func(ctx context.Context) error {
+		return r.add(ctx, serviceName, svc)
+	})
+	if err != nil {
+		return "", err
+	}
+	return serviceName, nil
+}
+
+// connectRetryPolicy 是 WithConnectRetry 的退避策略，总时间由 maxWait 限制
+var connectRetryPolicy = RetryPolicy{MaxAttempts: math.MaxInt32, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 0.5}
+
+// connect 在开启 WithConnectRetry 时重试 op，直到成功、遇到非临时性错误或超过等待时间
+// op 失败时已经撤销了申请的租约，重试从申请租约重新开始
+func (r *RegistryEtcd) connect(ctx context.Context, op func(ctx context.Context) error) error {
+	if r.opts.connectRetry <= 0 {
+		return op(ctx)
+	}
+	return withRetry(ctx, connectRetryPolicy, r.opts.connectRetry, func(ctx context.Context) error {
+		err := op(ctx)
+		if isRetryable(err) {
+			r.opts.logger.Warnf("etcd unavailable, retrying registration: %v", err)
+		}
+		return err
+	})
+}
+
+// add 注册实例并记录到 r.services，Registry 和 TryRegistry 共用
+func (r *RegistryEtcd) add(ctx context.Context, serviceName string, svc *registeredService) error {
+	register := r.register
+	if r.opts.sharedLease {
+		register = r.registerShared
+	}
+	if err := register(ctx, serviceName, svc); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.services[serviceName] = svc
+	if done := r.opts.deregisterCtx; done != nil {
+		svc.stopDeregister = context.AfterFunc(done, func() { r.deregisterOnDone(serviceName) })
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// instanceClaimPrefix 是 TryRegistry 占位 key 的前缀
+const instanceClaimPrefix = "/claims/"
+
+// errAlreadyClaimed 表示 TryRegistry 的占位 key 已被其他实例持有
+var errAlreadyClaimed = errors.New("service instance already claimed")
+
+// instanceClaimKey 返回服务名和地址确定的占位 key，同名同地址的实例对应同一个 key
+func instanceClaimKey(name, addr string) string {
+	return instanceClaimPrefix + name + "/" + addr
+}
+
+// TryRegistry 在服务名下还没有相同地址的实例时注册，返回是否注册了新实例，用于幂等的部署
+// 先读取服务名下的实例比较地址，已存在时返回 false 且不写入任何 key；
+// 读取和注册之间的竞争由占位 key 解决：实例和绑定同一租约的占位 key 在同一个事务中写入，
+// 占位 key 已存在时事务不执行，两个调用方同时注册同一地址时只有一个返回 true
+// 注册的实例随 DeRegistry 或 DeRegistryByName 注销；挂在共享租约上的注册端（WithSharedLease）不支持
+func (r *RegistryEtcd) TryRegistry(ctx context.Context, service Service) (bool, error) {
+	if r.opts.sharedLease {
+		return false, errors.New("try registry: not supported with a shared lease")
+	}
+	serviceName, value, err := r.encode(service)
+	if err != nil {
+		return false, err
+	}
+	// 比较的是写入 etcd 的地址（WithAdvertiseAddr 之后的地址）
+	rec, err := r.opts.codec.Decode([]byte(value))
+	if err != nil {
+		return false, err
+	}
+	var resp *clientv3.GetResponse
+	err = r.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = r.client.Get(ctx, serviceKeyPrefix(service.Name()), clientv3.WithPrefix())
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, kv := range resp.Kvs {
+		if existing, err := r.opts.codec.Decode(kv.Value); err == nil && existing.Addr == rec.Addr {
+			return false, nil
+		}
+	}
+	svc := &registeredService{name: service.Name(), value: value, ttl: r.leaseTTL, claim: instanceClaimKey(service.Name(), rec.Addr)}
+	if t, ok := service.(TTLAware); ok && t.TTL() > 0 {
+		svc.ttl = t.TTL()
+	}
+	if err := r.add(ctx, serviceName, svc); err != nil {
+		if errors.Is(err, errAlreadyClaimed) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// deregisterOnDone 在 WithDeregisterOnContextDone 的 ctx 结束时注销实例，实例已经注销时什么也不做
+func (r *RegistryEtcd) deregisterOnDone(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.shutdownTimeout)
+	defer cancel()
+	r.mu.Lock()
+	_, ok := r.services[key]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := r.DeRegistryService(ctx, key); err != nil {
+		r.opts.logger.Warnf("deregister %s on context done: %v", key, err)
+		return
+	}
+	r.opts.logger.Infof("deregistered %s on context done", key)
+}
+
+// encode 为服务生成 key（服务名/uuid）并编码记录，检查地址、健康检查地址和记录大小
+func (r *RegistryEtcd) encode(service Service) (serviceName, value string, err error) {
+	rec, err := newRecord(r.opts, service)
+	if err != nil {
+		return "", "", err
+	}
+	encoded, err := r.opts.codec.Encode(recordService{name: service.Name(), rec: rec})
+	if err != nil {
+		return "", "", err
+	}
+	serviceName = newServiceKey(service.Name())
+	if size := len(serviceName) + len(encoded); size > r.opts.maxRecordBytes {
+		return "", "", fmt.Errorf("%w: %s is %d bytes, max %d", ErrRecordTooLarge, serviceName, size, r.opts.maxRecordBytes)
+	}
+	return serviceName, string(encoded), nil
+}
+
+// register 为实例申请租约、写入记录并启动续约，成功后填充 svc 的租约字段
+func (r *RegistryEtcd) register(ctx context.Context, serviceName string, svc *registeredService) error {
 	// 申请租约
-	grantResp, err := r.client.Grant(context.Background(), r.leaseTTL)
+	var grantResp *clientv3.LeaseGrantResponse
+	err := r.do(ctx, func(ctx context.Context) error {
+		var err error
+		grantResp, err = r.lease.Grant(ctx, svc.ttl)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	r.leaseID = grantResp.ID
-	serviceName := service.Name() + "-" + uuid.New().String()
+	leaseID := grantResp.ID
+	r.opts.logger.Debugf("granted lease %x (ttl %ds) for %s", leaseID, svc.ttl, serviceName)
 	// 注册服务并绑定租约
-	_, err = r.client.Put(context.Background(), serviceName, service.Addr(), clientv3.WithLease(r.leaseID))
+	// 开启服务名索引时，实例和索引在同一个事务中写入
+	ops := []clientv3.Op{clientv3.OpPut(serviceName, svc.value, clientv3.WithLease(leaseID))}
+	if r.opts.serviceIndex {
+		ops = append(ops, clientv3.OpPut(serviceIndexKey(svc.name), ""))
+	}
+	// TryRegistry 注册的实例只在占位 key 不存在时写入，占位 key 绑定同一个租约，随实例一起消失
+	var cmps []clientv3.Cmp
+	if svc.claim != "" {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(svc.claim), "=", 0))
+		ops = append(ops, clientv3.OpPut(svc.claim, "", clientv3.WithLease(leaseID)))
+	}
+	claimed := true
+	err = r.do(ctx, func(ctx context.Context) error {
+		resp, err := r.client.Txn(ctx).If(cmps...).Then(ops...).Else(r.claimOwner(svc)...).Commit()
+		if err == nil && !resp.Succeeded {
+			// 重试时上一次提交可能已经成功，占位 key 在自己的租约上同样视为成功
+			kvs := resp.Responses[0].GetResponseRange().Kvs
+			claimed = len(kvs) > 0 && clientv3.LeaseID(kvs[0].Lease) == leaseID
+		}
+		return err
+	})
 	if err != nil {
+		r.revoke(leaseID)
 		return err
 	}
+	if !claimed {
+		r.revoke(leaseID)
+		return fmt.Errorf("%w: %s", errAlreadyClaimed, svc.claim)
+	}
 	// 启动续约
 	/*
 			时间轴：  0s      1.6s     3.2s     4.8s     6.4s
@@ -72,48 +334,886 @@ func (r *RegistryEtcd) Registry(service Service) error {
 	//                   ID: 1234567890,    // 租约 ID
 	//                   TTL: 5,            // 剩余生存时间(秒)
 	//               }
-	r.leaseKeepAliveRespCh, err = r.client.KeepAlive(context.Background(), r.leaseID)
+	if err := r.startKeepAlive(serviceName, svc, leaseID); err != nil {
+		r.revoke(leaseID)
+		return err
+	}
+	return nil
+}
+
+// claimOwner 返回读取实例占位 key 的操作，没有占位 key 时为空
+func (r *RegistryEtcd) claimOwner(svc *registeredService) []clientv3.Op {
+	if svc.claim == "" {
+		return nil
+	}
+	return []clientv3.Op{clientv3.OpGet(svc.claim)}
+}
+
+// startKeepAlive 为实例的租约启动续约及其监听 goroutine，续约丢失或卡住时按租约丢失处理
+// 注册和 ResumeSession 共用
+func (r *RegistryEtcd) startKeepAlive(serviceName string, svc *registeredService, leaseID clientv3.LeaseID) error {
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	keepAliveCh, err := r.lease.KeepAlive(keepAliveCtx, leaseID)
 	if err != nil {
+		cancel()
 		return err
 	}
+	// 重新注册时 DeRegistryService 可能同时读取这些字段
+	r.mu.Lock()
+	svc.leaseID = leaseID
+	svc.leaseKeepAliveRespCh = keepAliveCh
+	svc.cancelKeepAlive = cancel
+	svc.ttlLeft = svc.ttl
+	if svc.stop == nil {
+		svc.stop = make(chan struct{})
+	}
+	stop := svc.stop
+	r.mu.Unlock()
 
 	// 启动续约监听 goroutine
+	r.keepAlives.Add(1)
 	go func() {
+		defer r.keepAlives.Done()
 		// 处理续约响应
-		for resp := range r.leaseKeepAliveRespCh {
-			_ = resp
+		stalled := consumeKeepAlive(keepAliveCh, stop, stallTimeout(r.opts.keepAliveStall, svc.ttl), func(ka *clientv3.LeaseKeepAliveResponse) {
+			r.mu.Lock()
+			if svc.leaseID == ka.ID {
+				svc.ttlLeft = ka.TTL
+			}
+			r.mu.Unlock()
+			if r.opts.metrics != nil {
+				r.opts.metrics.IncKeepAlive()
+			}
+		})
+		if stalled {
+			// 通道还开着但响应已经停了，停止这次续约，按租约丢失处理
+			// 重新注册把 key 写到新租约上之后再撤销旧租约，避免撤销时删掉 key
+			r.opts.logger.Warnf("keepalive for %s stalled, no response for %v", serviceName, stallTimeout(r.opts.keepAliveStall, svc.ttl))
+			cancel()
+			r.onLeaseLost(serviceName, svc)
+			r.revoke(leaseID)
+			return
+		}
+		// 续约通道在主动注销之外关闭，说明租约已经丢失（被撤销、过期或连接长时间中断）
+		if keepAliveCtx.Err() == nil {
+			r.onLeaseLost(serviceName, svc)
 		}
 	}()
+	return nil
+}
 
+// registerShared 把实例挂到共享租约上，共享租约不存在时先申请
+func (r *RegistryEtcd) registerShared(ctx context.Context, serviceName string, svc *registeredService) error {
+	r.mu.Lock()
+	if r.shared == nil {
+		m, err := newLeaseManager(ctx, r.client, r.leaseTTL, r.opts.keepAliveStall, &r.keepAlives, r.onManagedLeaseLost)
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		r.opts.logger.Debugf("granted shared lease %x (ttl %ds)", m.LeaseID(), r.leaseTTL)
+		r.shared = m
+	}
+	m := r.shared
+	r.mu.Unlock()
+	err := r.do(ctx, func(ctx context.Context) error {
+		return m.attach(ctx, serviceName, svc.value, r.indexOps(svc.name)...)
+	})
+	if errors.Is(err, ErrLeaseManagerClosed) {
+		// 共享租约在挂载前随最后一个实例的注销被撤销，重新申请
+		r.mu.Lock()
+		if r.shared == m {
+			r.shared = nil
+		}
+		r.mu.Unlock()
+		return r.registerShared(ctx, serviceName, svc)
+	}
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	svc.leaseID = m.LeaseID()
+	svc.cancelKeepAlive = func() {}
+	svc.lease = m
+	r.mu.Unlock()
 	return nil
 }
-func (r *RegistryEtcd) DeRegistry() error {
-	// etcd注销逻辑
-	// 停止续约
-	if _, err := r.client.Revoke(context.Background(), r.leaseID); err != nil {
+
+// RegistryBatch 在一个事务中注册一组服务，全部实例绑定同一个租约，要么全部出现，要么一个也不注册
+// 成功后每个实例都可以用 DeRegistryService 单独注销，组内最后一个实例注销时撤销租约
+// 组内实例共用注册中心的 leaseTTL，TTLAware 的单独 TTL 不生效
+func (r *RegistryEtcd) RegistryBatch(ctx context.Context, services []Service) error {
+	if len(services) == 0 {
+		return nil
+	}
+	kvs := make(map[string]string, len(services))
+	svcs := make(map[string]*registeredService, len(services))
+	var names []string
+	for _, service := range services {
+		serviceName, value, err := r.encode(service)
+		if err != nil {
+			return fmt.Errorf("register %s: %w", service.Name(), err)
+		}
+		kvs[serviceName] = value
+		svcs[serviceName] = &registeredService{name: service.Name(), value: value, ttl: r.leaseTTL}
+		names = append(names, service.Name())
+	}
+	m, err := newLeaseManager(ctx, r.client, r.leaseTTL, r.opts.keepAliveStall, &r.keepAlives, r.onManagedLeaseLost)
+	if err != nil {
+		return err
+	}
+	r.opts.logger.Debugf("granted lease %x (ttl %ds) for a batch of %d services", m.LeaseID(), r.leaseTTL, len(services))
+	if err := r.do(ctx, func(ctx context.Context) error {
+		return m.attachAll(ctx, kvs, r.indexOps(names...)...)
+	}); err != nil {
+		// 事务没有提交，撤销刚申请的租约，etcd 中不会留下任何实例
+		m.Revoke(context.Background())
+		return err
+	}
+	r.mu.Lock()
+	for serviceName, svc := range svcs {
+		svc.leaseID = m.LeaseID()
+		svc.cancelKeepAlive = func() {}
+		svc.lease = m
+		r.services[serviceName] = svc
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// indexOps 返回写入服务名索引的操作，同名服务只写一次（同一事务中不能重复写同一个 key）
+// 未开启 WithServiceIndex 时返回 nil
+func (r *RegistryEtcd) indexOps(names ...string) []clientv3.Op {
+	if !r.opts.serviceIndex {
+		return nil
+	}
+	seen := make(map[string]bool, len(names))
+	var ops []clientv3.Op
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			ops = append(ops, clientv3.OpPut(serviceIndexKey(name), ""))
+		}
+	}
+	return ops
+}
+
+// onManagedLeaseLost 上报 LeaseManager 租约丢失，配置了 WithReRegister 时申请新租约并写回挂载的全部实例
+func (r *RegistryEtcd) onManagedLeaseLost(m *LeaseManager, leaseID clientv3.LeaseID) {
+	r.opts.logger.Warnf("keepalive lost for managed lease %x", leaseID)
+	r.mu.Lock()
+	var keys []string
+	for key, svc := range r.services {
+		if svc.lease == m {
+			keys = append(keys, key)
+		}
+	}
+	r.mu.Unlock()
+	for _, key := range keys {
+		r.reportKeepAliveError(fmt.Errorf("%w: %s", ErrLeaseLost, key))
+	}
+	policy := r.opts.reRegister
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(r.reRegisterDelay(attempt, r.leaseTTL)):
+		case <-m.done:
+			// 等待重试期间已被注销或关闭
+			return
+		}
+		r.opts.logger.Infof("re-granting managed lease, attempt %d/%d", attempt, policy.MaxAttempts)
+		if err = m.grant(context.Background()); err == nil {
+			r.mu.Lock()
+			for _, svc := range r.services {
+				if svc.lease == m {
+					svc.leaseID = m.LeaseID()
+				}
+			}
+			r.mu.Unlock()
+			return
+		}
+		if errors.Is(err, ErrLeaseManagerClosed) {
+			// 等待重试期间已被注销或关闭
+			return
+		}
+		r.opts.logger.Warnf("re-grant managed lease attempt %d failed: %v", attempt, err)
+	}
+	// 放弃这个租约，共享租约被放弃后之后的注册重新申请
+	m.stop()
+	r.mu.Lock()
+	if r.shared == m {
+		r.shared = nil
+	}
+	for key, svc := range r.services {
+		if svc.lease == m {
+			delete(r.services, key)
+		}
+	}
+	r.mu.Unlock()
+	if policy.MaxAttempts > 0 {
+		r.opts.logger.Errorf("giving up re-granting managed lease after %d attempts: %v", policy.MaxAttempts, err)
+		r.reportKeepAliveError(fmt.Errorf("re-grant managed lease after %d attempts: %w", policy.MaxAttempts, err))
+	}
+}
+
+// onLeaseLost 上报租约丢失，配置了 WithReRegister 时重新申请租约并写入同一个 key
+func (r *RegistryEtcd) onLeaseLost(serviceName string, svc *registeredService) {
+	r.opts.logger.Warnf("keepalive lost for %s, lease %x", serviceName, svc.leaseID)
+	r.reportKeepAliveError(fmt.Errorf("%w: %s", ErrLeaseLost, serviceName))
+	policy := r.opts.reRegister
+	if policy.MaxAttempts <= 0 {
+		r.mu.Lock()
+		if r.services[serviceName] == svc {
+			delete(r.services, serviceName)
+		}
+		r.mu.Unlock()
+		return
+	}
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		select {
+		case <-svc.stop:
+			// 等待重试期间被注销或注册端已关闭
+			return
+		case <-time.After(r.reRegisterDelay(attempt, svc.ttl)):
+		}
+		r.mu.Lock()
+		active := r.services[serviceName] == svc
+		r.mu.Unlock()
+		if !active {
+			// 等待重试期间已被注销
+			return
+		}
+		r.opts.logger.Infof("re-registering %s, attempt %d/%d", serviceName, attempt, policy.MaxAttempts)
+		if err = r.register(context.Background(), serviceName, svc); err == nil {
+			r.mu.Lock()
+			active = r.services[serviceName] == svc
+			leaseID := svc.leaseID
+			if !active {
+				svc.cancelKeepAlive()
+			}
+			r.mu.Unlock()
+			if !active {
+				// 重新注册期间已被注销，撤销刚申请的租约
+				r.revoke(leaseID)
+			}
+			return
+		}
+		r.opts.logger.Warnf("re-register %s attempt %d failed: %v", serviceName, attempt, err)
+	}
+	r.mu.Lock()
+	if r.services[serviceName] == svc {
+		delete(r.services, serviceName)
+	}
+	r.mu.Unlock()
+	r.opts.logger.Errorf("giving up re-registering %s after %d attempts: %v", serviceName, policy.MaxAttempts, err)
+	r.reportKeepAliveError(fmt.Errorf("re-register %s after %d attempts: %w", serviceName, policy.MaxAttempts, err))
+}
+
+// reRegisterDelay 返回第 attempt 次重新注册前的等待时间，ttl 是租约 TTL（秒）
+// 第一次默认立即重试，配置 WithKeepAliveJitter 时随机等待不超过 fraction*TTL/3；之后按策略退避并抖动
+func (r *RegistryEtcd) reRegisterDelay(attempt int, ttl int64) time.Duration {
+	jitter := r.opts.keepAliveJitter
+	if attempt <= 1 {
+		if jitter <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Float64() * jitter * float64(time.Duration(ttl)*time.Second/3))
+	}
+	policy := r.opts.reRegister
+	if jitter > policy.Jitter {
+		policy.Jitter = jitter
+	}
+	return policy.backoff(attempt - 1)
+}
+
+// stallTimeout 返回续约卡住的判定时间，timeout 为 0 时是 TTL 的两倍
+func stallTimeout(timeout time.Duration, ttl int64) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return 2 * time.Duration(ttl) * time.Second
+}
+
+// consumeKeepAlive 读取续约响应直到通道关闭或 stop 关闭（stop 可以为 nil），每收到一个响应调用一次 onResponse（可以为 nil）
+// 超过 stall 没有收到响应时提前返回 true，此时通道仍然打开，调用方负责取消续约
+func consumeKeepAlive(ch <-chan *clientv3.LeaseKeepAliveResponse, stop <-chan struct{}, stall time.Duration, onResponse func(*clientv3.LeaseKeepAliveResponse)) (stalled bool) {
+	timer := time.NewTimer(stall)
+	defer timer.Stop()
+	for {
+		select {
+		case ka, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if onResponse != nil {
+				onResponse(ka)
+			}
+			timer.Reset(stall)
+		case <-timer.C:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// reportKeepAliveError 把错误发送到 KeepAliveErrors，没有人读取且缓冲已满时丢弃
+func (r *RegistryEtcd) reportKeepAliveError(err error) {
+	select {
+	case r.keepAliveErrs <- err:
+	default:
+	}
+}
+
+// KeepAliveErrors 返回续约失败的通知：租约在注销之前丢失时收到 ErrLeaseLost，
+// 开启 WithReRegister 且重新注册最终失败时再收到一条描述失败原因的错误
+// 通道带缓冲且不会关闭，缓冲满时新的错误被丢弃
+func (r *RegistryEtcd) KeepAliveErrors() <-chan error {
+	return r.keepAliveErrs
+}
+
+// revoke 撤销注册失败时已经申请的租约，尽力而为
+func (r *RegistryEtcd) revoke(leaseID clientv3.LeaseID) {
+	r.do(context.Background(), func(ctx context.Context) error {
+		_, err := r.lease.Revoke(ctx, leaseID)
 		return err
+	})
+}
+
+// do 按注册选项中的重试策略和超时执行一次 etcd 操作
+func (r *RegistryEtcd) do(ctx context.Context, op func(ctx context.Context) error) error {
+	return withRetry(ctx, r.opts.opRetry, r.opts.requestTimeout, op)
+}
+
+// newRecord 由服务信息和注册选项构造写入 etcd 的记录，MemoryRegistry 用同样的规则构造记录
+func newRecord(o options, service Service) (ServiceRecord, error) {
+	if err := validateServiceName(service.Name()); err != nil {
+		return ServiceRecord{}, err
+	}
+	rec := ServiceRecord{Addr: service.Addr()}
+	if o.advertiseAddr != nil {
+		rec.Addr = o.advertiseAddr(service)
+	}
+	if o.addrValidation {
+		if err := validateAddr(rec.Addr); err != nil {
+			return ServiceRecord{}, err
+		}
+	}
+	meta := make(map[string]string)
+	if m, ok := service.(MetadataAware); ok {
+		for k, v := range m.Metadata() {
+			meta[k] = v
+		}
+	}
+	if o.healthCheckURL != "" {
+		if err := validateHealthCheckURL(o.healthCheckURL); err != nil {
+			return ServiceRecord{}, err
+		}
+		meta[MetadataHealthCheckURL] = o.healthCheckURL
+	}
+	if len(meta) > 0 {
+		rec.Metadata = meta
+	}
+	return rec, nil
+}
+
+// DeRegistry 注销全部服务实例，部分实例注销失败时仍会尝试其余实例
+// 客户端连接保持打开，之后可以继续注册，不再使用时调用 Close；返回前等待全部续约 goroutine 退出
+func (r *RegistryEtcd) DeRegistry(ctx context.Context) error {
+	// etcd注销逻辑
+	var errs []error
+	if err := r.revokeManaged(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.services))
+	for key := range r.services {
+		keys = append(keys, key)
+	}
+	r.mu.Unlock()
+	for _, key := range keys {
+		if err := r.DeRegistryService(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// 返回时续约 goroutine 已经全部退出，不会在注销之后重新注册
+	r.keepAlives.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// revokeManaged 撤销全部 LeaseManager 的租约，挂在同一个租约上的实例在同一个 revision 中被删除
+func (r *RegistryEtcd) revokeManaged(ctx context.Context) error {
+	r.mu.Lock()
+	managers := make(map[*LeaseManager]bool)
+	if r.shared != nil {
+		managers[r.shared] = true
+		r.shared = nil
+	}
+	names := make(map[string]bool)
+	for key, svc := range r.services {
+		if svc.lease != nil {
+			managers[svc.lease] = true
+			names[svc.name] = true
+			delete(r.services, key)
+			svc.stopOnDone()
+		}
+	}
+	r.mu.Unlock()
+	var errs []error
+	for m := range managers {
+		err := r.do(ctx, func(ctx context.Context) error {
+			return m.Revoke(ctx)
+		})
+		if err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for name := range names {
+		errs = append(errs, r.pruneIndex(ctx, name))
 	}
+	return errors.Join(errs...)
+}
+
+// UpdateLoad 把实例当前的负载写进它的记录，记录仍挂在原来的租约上
+// JSON 和 protobuf 记录写在元数据 load 中，旧格式记录写成 host:port|load=0.8
+// 重新注册时写回的也是更新后的记录
+func (r *RegistryEtcd) UpdateLoad(ctx context.Context, key string, load float64) error {
+	if math.IsNaN(load) || math.IsInf(load, 0) || load < 0 {
+		return fmt.Errorf("invalid load %v: want a finite non-negative number", load)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	svc, ok := r.services[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotRegistered, key)
+	}
+	return r.setMetadata(ctx, key, svc, MetadataLoad, strconv.FormatFloat(load, 'f', -1, 64))
+}
 
-	// 关闭客户端连接
-	if err := r.client.Close(); err != nil {
+// setMetadata 在实例记录的元数据中写入 k=v，按记录原来的格式重新编码后写回原来的租约，调用方持有 r.mu
+func (r *RegistryEtcd) setMetadata(ctx context.Context, key string, svc *registeredService, k, v string) error {
+	// 直接用 Codec 解码，不经过发现端的地址改写
+	inst, err := r.opts.codec.Decode([]byte(svc.value))
+	if err != nil {
 		return err
 	}
+	rec := inst.ServiceRecord
+	if rec.Metadata == nil {
+		rec.Metadata = make(map[string]string)
+	}
+	rec.Metadata[k] = v
+	var encoded []byte
+	switch {
+	case !isBuiltinCodec(r.opts.codec):
+		// 自定义 Codec 自己决定元数据的写法
+		encoded, err = r.opts.codec.Encode(recordService{name: svc.name, rec: rec})
+	case svc.value[0] < minPrintableByte:
+		encoded, err = EncodeRecord(RecordFormat(svc.value[0]), rec)
+	default:
+		encoded = encodePlainRecord(rec)
+	}
+	if err != nil {
+		return err
+	}
+	value := string(encoded)
+	if svc.lease != nil {
+		err = svc.lease.attach(ctx, key, value)
+	} else {
+		leaseID := svc.leaseID
+		err = r.do(ctx, func(ctx context.Context) error {
+			_, err := r.client.Put(ctx, key, value, clientv3.WithLease(leaseID))
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	svc.value = value
 	return nil
 }
 
-func NewEtcdRegistry(endpoints []string, timeout time.Duration, leaseTTL int64) (*RegistryEtcd, error) {
-	if len(endpoints) == 0 {
-		return nil, errors.New("etcd endpoints cannot be empty")
+// DeRegistryWithDrain 先在全部实例的记录中写入排空标记，等待 drain 之后再注销
+// 开启 WithDrainAware 的发现端看到标记后不再选择这些实例，已经拿到地址的调用方有 drain 的时间完成请求
+// 排空期间续约照常进行；ctx 在等待期间结束时返回 ctx.Err()，实例保持注册并带着排空标记
+func (r *RegistryEtcd) DeRegistryWithDrain(ctx context.Context, drain time.Duration) error {
+	r.mu.Lock()
+	var errs []error
+	for key, svc := range r.services {
+		if err := r.setMetadata(ctx, key, svc, MetadataDraining, "true"); err != nil {
+			errs = append(errs, fmt.Errorf("mark %s draining: %w", key, err))
+		}
+	}
+	r.mu.Unlock()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	r.opts.logger.Infof("draining for %s before deregister", drain)
+	timer := time.NewTimer(drain)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return r.DeRegistry(ctx)
+}
+
+// UpdateValueCAS 只有在 etcd 中实例的当前记录等于 expected 时才把它替换成 value，返回是否替换成功
+// 比较和写入在同一个事务中完成，并发的改写（UpdateLoad、重新注册或其他进程）不会被悄悄覆盖；
+// 返回 false 时调用方应重新读取记录再决定是否重试。记录仍挂在原来的租约上，重新注册时写回的也是新记录
+func (r *RegistryEtcd) UpdateValueCAS(ctx context.Context, key, expected, value string) (bool, error) {
+	if size := len(key) + len(value); size > r.opts.maxRecordBytes {
+		return false, fmt.Errorf("%w: %s is %d bytes, max %d", ErrRecordTooLarge, key, size, r.opts.maxRecordBytes)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	svc, ok := r.services[key]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrServiceNotRegistered, key)
+	}
+	var swapped bool
+	if svc.lease != nil {
+		var err error
+		if swapped, err = svc.lease.swap(ctx, key, expected, value); err != nil {
+			return false, err
+		}
+	} else {
+		leaseID := svc.leaseID
+		err := r.do(ctx, func(ctx context.Context) error {
+			resp, err := r.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.Value(key), "=", expected)).
+				Then(clientv3.OpPut(key, value, clientv3.WithLease(leaseID))).
+				Commit()
+			if err == nil {
+				swapped = resp.Succeeded
+			}
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	if swapped {
+		svc.value = value
+	}
+	return swapped, nil
+}
+
+// MoveInstance 把实例从原来的服务名移到 newName 下，返回新的 key（newName/新 uuid）
+// 删除旧 key 和写入新 key 在同一个事务中完成，记录和租约不变，发现端不会看到实例同时在两个服务下或都不在
+// 旧 key 已经不在 etcd 中（例如租约已经过期）时返回 ErrServiceNotFound；旧 key 之后不能再用于注销等操作
+func (r *RegistryEtcd) MoveInstance(ctx context.Context, oldKey, newName string) (string, error) {
+	if err := validateServiceName(newName); err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	svc, ok := r.services[oldKey]
+	var (
+		value   string
+		oldName string
+		leaseID clientv3.LeaseID
+	)
+	if ok {
+		value, oldName, leaseID = svc.value, svc.name, svc.leaseID
+	}
+	r.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrServiceNotRegistered, oldKey)
 	}
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: timeout,
+	newKey := newServiceKey(newName)
+	var extra []clientv3.Op
+	if r.opts.serviceIndex {
+		extra = append(extra, clientv3.OpPut(serviceIndexKey(newName), ""))
+	}
+	var moved bool
+	if m := svc.lease; m != nil {
+		var err error
+		if moved, err = m.move(ctx, oldKey, newKey, value, extra...); err != nil {
+			return "", err
+		}
+	} else {
+		err := r.do(ctx, func(ctx context.Context) error {
+			resp, err := r.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.CreateRevision(oldKey), ">", 0)).
+				Then(append([]clientv3.Op{
+					clientv3.OpDelete(oldKey),
+					clientv3.OpPut(newKey, value, clientv3.WithLease(leaseID)),
+				}, extra...)...).
+				Commit()
+			if err == nil {
+				moved = resp.Succeeded
+			}
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if !moved {
+		return "", fmt.Errorf("%w: %s", ErrServiceNotFound, oldKey)
+	}
+
+	r.mu.Lock()
+	delete(r.services, oldKey)
+	svc.name = newName
+	r.services[newKey] = svc
+	svc.stopOnDone()
+	if done := r.opts.deregisterCtx; done != nil {
+		svc.stopDeregister = context.AfterFunc(done, func() { r.deregisterOnDone(newKey) })
+	}
+	stopOld := svc.cancelKeepAlive
+	r.mu.Unlock()
+	if svc.lease == nil {
+		// 续约 goroutine 按 key 处理租约丢失，在同一个租约上为新 key 启动续约后再停止旧的
+		if err := r.startKeepAlive(newKey, svc, leaseID); err != nil {
+			return newKey, err
+		}
+		stopOld()
+	}
+	return newKey, r.pruneIndex(ctx, oldName)
+}
+
+// TimeToLive 返回已注册实例租约的剩余时间（秒），多个实例时返回最小的剩余时间
+// 开启 WithSharedLease 时就是共享租约的剩余时间；没有已注册的实例时返回错误
+// 续约正常时剩余时间在 TTL*2/3 到 TTL 之间波动
+func (r *RegistryEtcd) TimeToLive(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	leases := make(map[clientv3.LeaseID]bool)
+	for _, svc := range r.services {
+		leases[svc.leaseID] = true
+	}
+	r.mu.Unlock()
+	if len(leases) == 0 {
+		return 0, ErrServiceNotRegistered
+	}
+	remaining := int64(-1)
+	for leaseID := range leases {
+		ttl, err := r.leaseTimeToLive(ctx, leaseID)
+		if err != nil {
+			return 0, err
+		}
+		if remaining < 0 || ttl < remaining {
+			remaining = ttl
+		}
+	}
+	return remaining, nil
+}
+
+// RegistrationInfo 描述注册端的一个实例，见 Registered
+type RegistrationInfo struct {
+	Key     string
+	Name    string
+	Addr    string
+	LeaseID clientv3.LeaseID
+	// TTL 是最近一次续约响应中的剩余时间（秒），不是实时查询的结果
+	TTL int64
+}
+
+// Registered 返回注册端当前持有的全部实例，按 key 排序
+// 只读取本地状态，不访问 etcd，适合在调试接口中频繁调用；需要准确的剩余时间时用 ServiceTimeToLive
+func (r *RegistryEtcd) Registered() []RegistrationInfo {
+	r.mu.Lock()
+	infos := make([]RegistrationInfo, 0, len(r.services))
+	leases := make([]*LeaseManager, 0, len(r.services))
+	for key, svc := range r.services {
+		info := RegistrationInfo{Key: key, Name: svc.name, LeaseID: svc.leaseID, TTL: svc.ttlLeft}
+		if inst, err := r.opts.codec.Decode([]byte(svc.value)); err == nil {
+			info.Addr = inst.Addr
+		}
+		infos = append(infos, info)
+		leases = append(leases, svc.lease)
+	}
+	r.mu.Unlock()
+	// 挂在共享租约上的实例由 LeaseManager 续约，在 r.mu 之外读取它的剩余时间
+	for i, m := range leases {
+		if m != nil {
+			infos[i].TTL = m.remainingTTL()
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
+
+// ServiceTimeToLive 返回 key 对应实例租约的剩余时间（秒）
+func (r *RegistryEtcd) ServiceTimeToLive(ctx context.Context, key string) (int64, error) {
+	r.mu.Lock()
+	svc, ok := r.services[key]
+	var leaseID clientv3.LeaseID
+	if ok {
+		leaseID = svc.leaseID
+	}
+	r.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrServiceNotRegistered, key)
+	}
+	return r.leaseTimeToLive(ctx, leaseID)
+}
+
+// leaseTimeToLive 查询租约的剩余时间，租约已经过期或被撤销时返回 ErrLeaseLost
+func (r *RegistryEtcd) leaseTimeToLive(ctx context.Context, leaseID clientv3.LeaseID) (int64, error) {
+	var resp *clientv3.LeaseTimeToLiveResponse
+	err := r.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = r.lease.TimeToLive(ctx, leaseID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.TTL < 0 {
+		return 0, fmt.Errorf("%w: lease %x", ErrLeaseLost, leaseID)
+	}
+	return resp.TTL, nil
+}
+
+// Ping 检查 etcd 集群是否可达，可以用作就绪探针；错误中包含失败的 endpoint
+func (r *RegistryEtcd) Ping(ctx context.Context) error {
+	return ping(ctx, r.client)
+}
+
+// Close 关闭 etcd 客户端连接；DeRegistry 不再关闭连接，同一个 RegistryEtcd 注销后可以继续注册
+// 没有注销的实例停止续约，在租约过期后从 etcd 中消失；返回前等待全部续约 goroutine 退出
+func (r *RegistryEtcd) Close() error {
+	r.mu.Lock()
+	for _, svc := range r.services {
+		svc.halt()
+		if svc.lease != nil {
+			svc.lease.stop()
+		}
+	}
+	if r.shared != nil {
+		r.shared.stop()
+	}
+	r.mu.Unlock()
+	// 续约 goroutine 可能正在重新注册，等它们退出后再关闭客户端
+	r.keepAlives.Wait()
+	return r.client.Close()
+}
+
+// DeRegistryService 注销 key 对应的一个服务实例，其余实例和客户端连接不受影响
+func (r *RegistryEtcd) DeRegistryService(ctx context.Context, key string) error {
+	r.mu.Lock()
+	svc, ok := r.services[key]
+	var leaseID clientv3.LeaseID
+	if ok {
+		delete(r.services, key)
+		leaseID = svc.leaseID
+		// 停止续约
+		svc.halt()
+		svc.stopOnDone()
+	}
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotRegistered, key)
+	}
+	if m := svc.lease; m != nil {
+		// 租约上还有其他实例时只删除这一个 key，最后一个实例注销时撤销租约
+		if err := r.do(ctx, func(ctx context.Context) error {
+			return m.Detach(ctx, key)
+		}); err != nil {
+			return err
+		}
+		if m.empty() {
+			r.mu.Lock()
+			if r.shared == m {
+				r.shared = nil
+			}
+			r.mu.Unlock()
+			if err := m.Revoke(ctx); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+				return err
+			}
+		}
+		return r.pruneIndex(ctx, svc.name)
+	}
+	// 先显式删除 key，不依赖撤销租约时的级联删除
+	delErr := r.do(ctx, func(ctx context.Context) error {
+		_, err := r.client.Delete(ctx, key)
+		return err
+	})
+	err := r.do(ctx, func(ctx context.Context) error {
+		_, err := r.lease.Revoke(ctx, leaseID)
+		return err
 	})
+	// 租约已经丢失时实例早已从 etcd 中消失，视为注销成功
+	if err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return errors.Join(delErr, err)
+	}
+	// 删除失败但租约已撤销时，key 随租约一起删除
+	return r.pruneIndex(ctx, svc.name)
+}
+
+// DeRegistryByName 删除服务 name 的全部实例（包括其他进程注册的实例），返回删除的 key 数量
+// 用于清理部署失败后残留的实例：前缀删除只匹配 name/，不会误删 name_v2 之类的服务
+// 其他进程的租约不受影响，但它们的 key 已经删除，直到重新注册之前都不会被发现；
+// 本注册中心自己注册的同名实例同时停止续约并撤销租约
+func (r *RegistryEtcd) DeRegistryByName(ctx context.Context, name string) (int, error) {
+	if err := validateServiceName(name); err != nil {
+		return 0, err
+	}
+	var resp *clientv3.DeleteResponse
+	err := r.do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = r.client.Delete(ctx, serviceKeyPrefix(name), clientv3.WithPrefix())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	var local []string
+	for key, svc := range r.services {
+		if svc.name == name {
+			local = append(local, key)
+		}
+	}
+	r.mu.Unlock()
+	var errs []error
+	for _, key := range local {
+		if err := r.DeRegistryService(ctx, key); err != nil && !errors.Is(err, ErrServiceNotRegistered) {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, r.pruneIndex(ctx, name))
+	return int(resp.Deleted), errors.Join(errs...)
+}
+
+// pruneIndex 在服务 name 已经没有实例时删除它的索引，未开启 WithServiceIndex 时什么也不做
+func (r *RegistryEtcd) pruneIndex(ctx context.Context, name string) error {
+	if !r.opts.serviceIndex {
+		return nil
+	}
+	return r.do(ctx, func(ctx context.Context) error {
+		resp, err := r.client.Get(ctx, serviceIndexKey(name))
+		if err != nil || len(resp.Kvs) == 0 {
+			return err
+		}
+		_, err = pruneServiceIndex(ctx, r.client, name, resp.Kvs[0].ModRevision)
+		return err
+	})
+}
+
+func NewEtcdRegistry(endpoints []string, timeout time.Duration, leaseTTL int64, opts ...Option) (*RegistryEtcd, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrEmptyEndpoints
+	}
+	o := newOptions(opts)
+	cli, err := newClient(endpoints, timeout, o)
 	if err != nil {
 		return nil, err
 	}
 	return &RegistryEtcd{
-		client:   cli,
-		leaseTTL: leaseTTL,
+		client:        cli,
+		lease:         cli,
+		leaseTTL:      leaseTTL,
+		opts:          o,
+		services:      make(map[string]*registeredService),
+		keepAliveErrs: make(chan error, 16),
 	}, nil
 }
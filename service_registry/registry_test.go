@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type OrderService struct { // 应该是server
@@ -33,22 +43,1062 @@ func TestRegistry(t *testing.T) { // 实际在main函数中运行
 		name: "order_service",
 		addr: "localhost:8080",
 	}
-	err = registry.Registry(service1)
+	_, err = registry.Registry(context.Background(), service1)
 	if err != nil {
 		log.Fatalf("Failed to register service1: %v", err)
 	}
 	log.Printf("Service %s registered at %s", service1.Name(), service1.Addr())
 
-	err = registry.Registry(service2)
+	_, err = registry.Registry(context.Background(), service2)
 	if err != nil {
 		log.Fatalf("Failed to register service2: %v", err)
 	}
 	log.Printf("Service %s registered at %s", service2.Name(), service2.Addr())
 
-	ChInt := make(chan os.Signal, 1)
-	signal.Notify(ChInt, os.Interrupt)
-	<-ChInt // ❌ 永远等待，直到手动按 Ctrl+C
-	if err := registry.DeRegistry(); err != nil {
+	// 阻塞直到手动按 Ctrl+C 或收到 SIGTERM，然后注销全部服务
+	if err := RunUntilSignal(context.Background(), registry); err != nil {
 		log.Fatalf("Failed to deregister services: %v", err)
 	}
+	registry.Close()
+}
+
+func TestRegistryInvalidHealthCheckURL(t *testing.T) {
+	for _, u := range []string{"healthz", "ftp://localhost/healthz", "http://"} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+			WithRecordFormat(FormatJSON), WithHealthCheckURL(u))
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		if _, err := registry.Registry(context.Background(), &OrderService{name: "invalid_health_service", addr: "localhost:9102"}); err == nil {
+			t.Errorf("Expected error for health check url %q", u)
+		}
+		registry.Close()
+	}
+}
+
+func TestRegistryRecordTooLarge(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+		WithRecordFormat(FormatJSON), WithMaxRecordBytes(1024))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	service := metadataService{
+		OrderService: &OrderService{name: "large_record_service", addr: "localhost:9401"},
+		meta:         map[string]string{"blob": strings.Repeat("x", 2048)},
+	}
+	_, err = registry.Registry(context.Background(), service)
+	if !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Expected ErrRecordTooLarge, got %v", err)
+	}
+	t.Logf("Registry error: %v", err)
+}
+
+func TestRegistryMultipleServices(t *testing.T) {
+	const name = "multi_instance_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	var keys []string
+	for _, addr := range []string{"localhost:9801", "localhost:9802"} {
+		key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: addr})
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", addr, err)
+		}
+		if !strings.HasPrefix(key, name+"/") {
+			t.Errorf("Unexpected service key %q", key)
+		}
+		keys = append(keys, key)
+	}
+	resp, err := registry.client.Get(context.Background(), name, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Failed to get services: %v", err)
+	}
+	if len(resp.Kvs) != 2 {
+		t.Fatalf("Expected 2 registered instances, got %d", len(resp.Kvs))
+	}
+	for _, key := range keys {
+		if resp, err := registry.client.Get(context.Background(), key); err != nil || len(resp.Kvs) != 1 {
+			t.Errorf("Expected returned key %s to exist in etcd, err %v", key, err)
+		}
+	}
+
+	// 只注销第一个实例
+	first := keys[0]
+	if err := registry.DeRegistryService(context.Background(), first); err != nil {
+		t.Fatalf("Failed to deregister %s: %v", first, err)
+	}
+	if err := registry.DeRegistryService(context.Background(), first); err == nil {
+		t.Errorf("Expected error deregistering %s twice", first)
+	}
+	resp, err = registry.client.Get(context.Background(), name, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Failed to get services: %v", err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Key) == first {
+		t.Fatalf("Expected only the second instance to remain, got %d keys", len(resp.Kvs))
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	resp, err = cli.Get(context.Background(), name, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Failed to get services: %v", err)
+	}
+	if len(resp.Kvs) != 0 {
+		t.Errorf("Expected all instances removed, got %d keys", len(resp.Kvs))
+	}
+}
+
+func TestRegistryKeepAliveLost(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, 2,
+		WithReRegister(RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	key, err := registry.Registry(context.Background(), &OrderService{name: "lease_lost_service", addr: "localhost:9901"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	// 用另一个客户端撤销租约，模拟 etcd 端租约丢失
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	resp, err := cli.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("Failed to get registered key: %v", err)
+	}
+	oldLease := clientv3.LeaseID(resp.Kvs[0].Lease)
+	if _, err := cli.Revoke(context.Background(), oldLease); err != nil {
+		t.Fatalf("Failed to revoke lease: %v", err)
+	}
+
+	select {
+	case err := <-registry.KeepAliveErrors():
+		if !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("Expected ErrLeaseLost, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for keepalive error")
+	}
+
+	// 重新注册后 key 以新租约重新出现
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		resp, err := cli.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Failed to get key: %v", err)
+		}
+		if len(resp.Kvs) == 1 && clientv3.LeaseID(resp.Kvs[0].Lease) != oldLease {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Service was not re-registered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+type ttlService struct {
+	*OrderService
+	ttl int64
+}
+
+func (s ttlService) TTL() int64 {
+	return s.ttl
+}
+
+func TestRegistryPerServiceTTL(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	services := []struct {
+		service Service
+		ttl     int64
+	}{
+		{&OrderService{name: "default_ttl_service", addr: "localhost:9671"}, LeaseTTL},
+		{ttlService{&OrderService{name: "long_ttl_service", addr: "localhost:9672"}, 30}, 30},
+	}
+	for _, s := range services {
+		key, err := registry.Registry(context.Background(), s.service)
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", s.service.Name(), err)
+		}
+		resp, err := registry.client.Get(context.Background(), key)
+		if err != nil || len(resp.Kvs) != 1 {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		ttlResp, err := registry.client.TimeToLive(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease))
+		if err != nil {
+			t.Fatalf("Failed to get lease TTL: %v", err)
+		}
+		if ttlResp.GrantedTTL != s.ttl {
+			t.Errorf("%s granted TTL = %d, want %d", s.service.Name(), ttlResp.GrantedTTL, s.ttl)
+		}
+	}
+}
+
+func TestDeRegistryKeepsClientOpen(t *testing.T) {
+	const name = "reusable_registry_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9811"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if resp, err := registry.client.Get(context.Background(), key); err != nil || len(resp.Kvs) != 0 {
+		t.Fatalf("Expected %s to be deleted, got %v, err %v", key, resp, err)
+	}
+
+	// 注销后客户端仍然可用，可以继续注册
+	key, err = registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9812"})
+	if err != nil {
+		t.Fatalf("Failed to register after DeRegistry: %v", err)
+	}
+	if err := registry.DeRegistryService(context.Background(), key); err != nil {
+		t.Fatalf("Failed to deregister %s: %v", key, err)
+	}
+	if err := registry.Close(); err != nil {
+		t.Fatalf("Failed to close registry: %v", err)
+	}
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9813"}); err == nil {
+		t.Errorf("Expected error registering on a closed registry")
+	}
+}
+
+func TestRegistryAddrValidation(t *testing.T) {
+	const name = "addr_validation_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	for _, addr := range []string{"localhost8080", ""} {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: addr}); err == nil {
+			t.Errorf("Expected error registering address %q", addr)
+		} else {
+			t.Logf("Registry(%q): %v", addr, err)
+		}
+	}
+	// 地址不合法时不会申请租约，也不会写入任何 key
+	if resp, err := registry.client.Get(context.Background(), name, clientv3.WithPrefix()); err != nil || len(resp.Kvs) != 0 {
+		t.Errorf("Expected no keys for rejected addresses, err %v", err)
+	}
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9831"}); err != nil {
+		t.Errorf("Failed to register valid address: %v", err)
+	}
+
+	raw, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithAddrValidation(false))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer raw.Close()
+	defer raw.DeRegistry(context.Background())
+	if _, err := raw.Registry(context.Background(), &OrderService{name: name, addr: "inproc-queue-7"}); err != nil {
+		t.Errorf("Expected unusual address to be accepted with validation disabled: %v", err)
+	}
+}
+
+func TestReRegisterDelayJitter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond}
+	const instances = 50
+	ttl := int64(LeaseTTL)
+	maxFirst := time.Duration(float64(time.Duration(ttl)*time.Second/3) * 0.5)
+	var first, second []time.Duration
+	for i := 0; i < instances; i++ {
+		r := &RegistryEtcd{opts: newOptions([]Option{WithReRegister(policy), WithKeepAliveJitter(0.5)})}
+		d := r.reRegisterDelay(1, ttl)
+		if d < 0 || d > maxFirst {
+			t.Fatalf("First re-register delay %v outside [0, %v]", d, maxFirst)
+		}
+		first = append(first, d)
+		second = append(second, r.reRegisterDelay(2, ttl))
+	}
+	// 抖动后各实例的重试时间应当分散，而不是对齐在同一时刻
+	for _, delays := range [][]time.Duration{first, second} {
+		distinct := make(map[time.Duration]bool)
+		lo, hi := delays[0], delays[0]
+		for _, d := range delays {
+			distinct[d] = true
+			lo, hi = min(lo, d), max(hi, d)
+		}
+		if len(distinct) < instances/2 || hi-lo < 100*time.Millisecond {
+			t.Errorf("Retry times not spread out: %d distinct values in [%v, %v]", len(distinct), lo, hi)
+		}
+	}
+
+	// 没有抖动时第一次立即重试，之后严格按退避对齐
+	r := &RegistryEtcd{opts: newOptions([]Option{WithReRegister(policy)})}
+	if d := r.reRegisterDelay(1, ttl); d != 0 {
+		t.Errorf("First delay without jitter = %v, want 0", d)
+	}
+	if d := r.reRegisterDelay(2, ttl); d != policy.BaseDelay {
+		t.Errorf("Second delay without jitter = %v, want %v", d, policy.BaseDelay)
+	}
+	// 超出上限的比例被截断
+	if o := newOptions([]Option{WithKeepAliveJitter(3)}); o.keepAliveJitter != maxKeepAliveJitter {
+		t.Errorf("Jitter fraction = %v, want clamped to %v", o.keepAliveJitter, maxKeepAliveJitter)
+	}
+}
+
+func TestRegistryBatch(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithServiceIndex())
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+
+	services := []Service{
+		&OrderService{name: "batch_order_service", addr: "localhost:9861"},
+		&OrderService{name: "batch_order_service", addr: "localhost:9862"},
+		&OrderService{name: "batch_payment_service", addr: "localhost:9863"},
+	}
+	if err := registry.RegistryBatch(context.Background(), services); err != nil {
+		t.Fatalf("Failed to register batch: %v", err)
+	}
+	resp, err := registry.client.Get(context.Background(), "batch_", clientv3.WithPrefix())
+	if err != nil || len(resp.Kvs) != len(services) {
+		t.Fatalf("Expected %d instances, got %d, err %v", len(services), len(resp.Kvs), err)
+	}
+	for _, kv := range resp.Kvs {
+		if kv.Lease != resp.Kvs[0].Lease || kv.ModRevision != resp.Kvs[0].ModRevision {
+			t.Fatalf("Batch instances were not written in one transaction with one lease")
+		}
+	}
+
+	// 单独注销组内一个实例不影响其余实例
+	var key string
+	registry.mu.Lock()
+	for k := range registry.services {
+		key = k
+		break
+	}
+	registry.mu.Unlock()
+	if err := registry.DeRegistryService(context.Background(), key); err != nil {
+		t.Fatalf("Failed to deregister %s: %v", key, err)
+	}
+	if resp, err := registry.client.Get(context.Background(), "batch_", clientv3.WithPrefix()); err != nil || len(resp.Kvs) != len(services)-1 {
+		t.Errorf("Expected %d instances after deregistering one, got %d, err %v", len(services)-1, len(resp.Kvs), err)
+	}
+}
+
+func TestRegistryBatchAtomicFailure(t *testing.T) {
+	const name = "batch_atomic_service"
+	logger := &recordingLogger{}
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+		WithRecordFormat(FormatJSON), WithMaxRecordBytes(4<<20), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	// 超过 etcd 单个请求大小上限（默认 1.5MiB）的记录让整个事务失败
+	services := []Service{
+		&OrderService{name: name, addr: "localhost:9871"},
+		metadataService{&OrderService{name: name, addr: "localhost:9872"}, map[string]string{"blob": strings.Repeat("x", 1600<<10)}},
+	}
+	if err := registry.RegistryBatch(context.Background(), services); err == nil {
+		t.Fatalf("Expected the oversized transaction to fail")
+	} else {
+		t.Logf("RegistryBatch error: %v", err)
+	}
+	if resp, err := registry.client.Get(context.Background(), name, clientv3.WithPrefix()); err != nil || len(resp.Kvs) != 0 {
+		t.Errorf("Expected no instances after a failed batch, got %d, err %v", len(resp.Kvs), err)
+	}
+	var leaseID clientv3.LeaseID
+	for _, line := range logger.lines {
+		fmt.Sscanf(line, "DEBUG granted lease %x", &leaseID)
+	}
+	if leaseID == 0 {
+		t.Fatalf("Batch lease was not logged: %v", logger.lines)
+	}
+	if ttl, err := registry.client.TimeToLive(context.Background(), leaseID); err != nil || ttl.TTL != -1 {
+		t.Errorf("Expected the batch lease %x to be revoked, ttl %v, err %v", leaseID, ttl, err)
+	}
+	if len(registry.services) != 0 {
+		t.Errorf("Expected no tracked services after a failed batch, got %d", len(registry.services))
+	}
+}
+
+func TestRegistryTimeToLive(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		opts []Option
+	}{
+		{"per-service lease", nil},
+		{"shared lease", []Option{WithSharedLease()}},
+	} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, 5, tc.opts...)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		if _, err := registry.TimeToLive(context.Background()); err == nil {
+			t.Errorf("%s: expected error before registration", tc.desc)
+		}
+		key, err := registry.Registry(context.Background(), &OrderService{name: "ttl_countdown_service", addr: "localhost:9881"})
+		if err != nil {
+			t.Fatalf("%s: failed to register: %v", tc.desc, err)
+		}
+		time.Sleep(2 * time.Second)
+		ttl, err := registry.TimeToLive(context.Background())
+		if err != nil {
+			t.Fatalf("%s: TimeToLive failed: %v", tc.desc, err)
+		}
+		// 续约每 TTL/3 刷新一次，剩余时间不会低于 TTL 的三分之二左右
+		if ttl < 2 || ttl > 5 {
+			t.Errorf("%s: remaining ttl %ds outside [2, 5]", tc.desc, ttl)
+		}
+		if svcTTL, err := registry.ServiceTimeToLive(context.Background(), key); err != nil || svcTTL < 2 || svcTTL > 5 {
+			t.Errorf("%s: ServiceTimeToLive = %d, %v", tc.desc, svcTTL, err)
+		}
+		t.Logf("%s: %ds remaining", tc.desc, ttl)
+		registry.DeRegistry(context.Background())
+		registry.Close()
+	}
+}
+
+// stalledLease 的前 stalls 次 KeepAlive 返回一个一直打开却不会收到响应的通道，模拟续约卡住
+type stalledLease struct {
+	clientv3.Lease
+	stalls int32
+}
+
+func (l *stalledLease) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	if atomic.AddInt32(&l.stalls, -1) >= 0 {
+		ch := make(chan *clientv3.LeaseKeepAliveResponse)
+		go func() {
+			<-ctx.Done()
+			close(ch)
+		}()
+		return ch, nil
+	}
+	return l.Lease.KeepAlive(ctx, id)
+}
+
+func TestRegistryKeepAliveStall(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+		WithKeepAliveStallTimeout(500*time.Millisecond), WithReRegister(RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	registry.lease = &stalledLease{Lease: registry.client, stalls: 1}
+	key, err := registry.Registry(context.Background(), &OrderService{name: "stalled_keepalive_service", addr: "localhost:9891"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	registry.mu.Lock()
+	stalledID := registry.services[key].leaseID
+	registry.mu.Unlock()
+
+	select {
+	case err := <-registry.KeepAliveErrors():
+		if !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("Expected ErrLeaseLost, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Stalled keepalive was not detected")
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	var leaseID clientv3.LeaseID
+	for time.Now().Before(deadline) {
+		registry.mu.Lock()
+		leaseID = registry.services[key].leaseID
+		registry.mu.Unlock()
+		if leaseID != stalledID {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if leaseID == stalledID {
+		t.Fatalf("Service was not re-registered on a new lease")
+	}
+	// 旧租约被撤销，key 留在新租约上并正常续约
+	time.Sleep(200 * time.Millisecond)
+	if resp, err := registry.client.TimeToLive(context.Background(), stalledID); err != nil || resp.TTL != -1 {
+		t.Errorf("Expected stalled lease revoked, got %+v, %v", resp, err)
+	}
+	resp, err := registry.client.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 || clientv3.LeaseID(resp.Kvs[0].Lease) != leaseID {
+		t.Fatalf("Expected key attached to the new lease %x, got %v, %v", leaseID, resp, err)
+	}
+	time.Sleep(LeaseTTL * time.Second)
+	if ttl, err := registry.ServiceTimeToLive(context.Background(), key); err != nil || ttl <= 0 {
+		t.Errorf("New lease is not kept alive: ttl %d, %v", ttl, err)
+	}
+}
+
+func TestDeRegistryByName(t *testing.T) {
+	const name = "purged_order_service"
+	// 两个注册中心各自注册一个实例，另有名字以 name 开头的其他服务
+	var registries []*RegistryEtcd
+	for _, s := range []Service{
+		&OrderService{name: name, addr: "localhost:9901"},
+		&OrderService{name: name, addr: "localhost:9902"},
+		&OrderService{name: name + "_v2", addr: "localhost:9903"},
+		&OrderService{name: name + "-v2", addr: "localhost:9904"},
+	} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+		if _, err := registry.Registry(context.Background(), s); err != nil {
+			t.Fatalf("Failed to register %s: %v", s.Addr(), err)
+		}
+		registries = append(registries, registry)
+	}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	addrs, err := discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if strings.Join(addrs, ",") != "localhost:9901,localhost:9902" {
+		t.Errorf("Lookup of %s matched other services: %v", name, addrs)
+	}
+
+	n, err := registries[0].DeRegistryByName(context.Background(), name)
+	if err != nil {
+		t.Fatalf("DeRegistryByName failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("DeRegistryByName removed %d keys, want 2", n)
+	}
+	if _, err := discovery.GetServiceAddr(context.Background(), name); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Expected %s to be gone, got %v", name, err)
+	}
+	for _, other := range []string{name + "_v2", name + "-v2"} {
+		if _, err := discovery.GetServiceAddr(context.Background(), other); err != nil {
+			t.Errorf("%s was removed by the purge: %v", other, err)
+		}
+	}
+	// 发起清理的注册中心自己的实例同时停止续约
+	registries[0].mu.Lock()
+	remaining := len(registries[0].services)
+	registries[0].mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected local instance to be dropped, %d left", remaining)
+	}
+	if _, err := registries[0].Registry(context.Background(), &OrderService{name: "bad/name", addr: "localhost:9905"}); err == nil {
+		t.Errorf("Expected error for service name containing a separator")
+	}
+}
+
+func TestRegistryAdvertiseAddr(t *testing.T) {
+	const name = "advertised_service"
+	advertise := func(s Service) string {
+		return strings.Replace(s.Addr(), "10.0.0.7", "203.0.113.7", 1)
+	}
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithAdvertiseAddr(advertise))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "10.0.0.7:9931"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	resp, err := registry.client.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if got := string(resp.Kvs[0].Value); got != "203.0.113.7:9931" {
+		t.Errorf("Stored address = %q, want the advertised address", got)
+	}
+
+	// 公布的地址同样需要通过格式检查
+	bad, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+		WithAdvertiseAddr(func(Service) string { return "not an address" }))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer bad.Close()
+	if _, err := bad.Registry(context.Background(), &OrderService{name: name, addr: "10.0.0.7:9932"}); err == nil {
+		bad.DeRegistry(context.Background())
+		t.Errorf("Expected invalid advertised address to be rejected")
+	}
+}
+
+func TestUpdateValueCAS(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		var opts []Option
+		if shared {
+			opts = append(opts, WithSharedLease())
+		}
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, opts...)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+		key, err := registry.Registry(context.Background(), &OrderService{name: "cas_service", addr: "localhost:9951"})
+		if err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+
+		// 两个 goroutine 基于同一个旧值竞争，只有一个能成功
+		var wg sync.WaitGroup
+		results := make([]bool, 2)
+		for i, value := range []string{"localhost:9952", "localhost:9953"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := registry.UpdateValueCAS(context.Background(), key, "localhost:9951", value)
+				if err != nil {
+					t.Errorf("UpdateValueCAS failed: %v", err)
+				}
+				results[i] = ok
+			}()
+		}
+		wg.Wait()
+		if results[0] == results[1] {
+			t.Fatalf("shared=%v: expected exactly one CAS to succeed, got %v", shared, results)
+		}
+		want := "localhost:9952"
+		if results[1] {
+			want = "localhost:9953"
+		}
+		resp, err := registry.client.Get(context.Background(), key)
+		if err != nil || len(resp.Kvs) != 1 {
+			t.Fatalf("Failed to read record: %v", err)
+		}
+		if got := string(resp.Kvs[0].Value); got != want {
+			t.Errorf("shared=%v: stored value = %q, want %q", shared, got, want)
+		}
+		// 记录仍然挂在实例的租约上
+		registry.mu.Lock()
+		leaseID := registry.services[key].leaseID
+		registry.mu.Unlock()
+		if lease := clientv3.LeaseID(resp.Kvs[0].Lease); lease != leaseID {
+			t.Errorf("shared=%v: record moved to lease %x", shared, lease)
+		}
+		if _, err := registry.UpdateValueCAS(context.Background(), "cas_service/unknown", want, "x"); !errors.Is(err, ErrServiceNotRegistered) {
+			t.Errorf("Expected ErrServiceNotRegistered, got %v", err)
+		}
+	}
+}
+
+func TestMoveInstance(t *testing.T) {
+	const oldName, newName = "move_blue_service", "move_green_service"
+	for _, shared := range []bool{false, true} {
+		var opts []Option
+		if shared {
+			opts = append(opts, WithSharedLease())
+		}
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, opts...)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+		oldKey, err := registry.Registry(context.Background(), &OrderService{name: oldName, addr: "localhost:9961"})
+		if err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+		ttl, err := registry.ServiceTimeToLive(context.Background(), oldKey)
+		if err != nil {
+			t.Fatalf("ServiceTimeToLive failed: %v", err)
+		}
+
+		// 并发读取两个服务，任何一个修订版本下实例都恰好出现一次
+		cli := newTestClient(t)
+		defer cli.Close()
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := cli.Txn(context.Background()).Then(
+					clientv3.OpGet(oldName+"/", clientv3.WithPrefix(), clientv3.WithCountOnly()),
+					clientv3.OpGet(newName+"/", clientv3.WithPrefix(), clientv3.WithCountOnly()),
+				).Commit()
+				if err != nil {
+					t.Errorf("Read failed: %v", err)
+					return
+				}
+				if n := resp.Responses[0].GetResponseRange().Count + resp.Responses[1].GetResponseRange().Count; n != 1 {
+					t.Errorf("Saw the instance %d times at revision %d", n, resp.Header.Revision)
+					return
+				}
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		newKey, err := registry.MoveInstance(context.Background(), oldKey, newName)
+		time.Sleep(50 * time.Millisecond)
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("MoveInstance failed: %v", err)
+		}
+		if !strings.HasPrefix(newKey, newName+"/") {
+			t.Errorf("Expected new key under %s, got %s", newName, newKey)
+		}
+
+		discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Failed to create etcd discovery: %v", err)
+		}
+		defer discovery.Close()
+		if addrs, _ := discovery.GetAllServiceAddrs(context.Background(), oldName); len(addrs) != 0 {
+			t.Errorf("Expected %s to be empty after move, got %v", oldName, addrs)
+		}
+		addrs, err := discovery.GetAllServiceAddrs(context.Background(), newName)
+		if err != nil || len(addrs) != 1 || addrs[0] != "localhost:9961" {
+			t.Errorf("Expected the instance under %s, got %v (%v)", newName, addrs, err)
+		}
+		// 租约不变：剩余时间不会超过移动前
+		if moved, err := registry.ServiceTimeToLive(context.Background(), newKey); err != nil || moved > ttl {
+			t.Errorf("Expected the same lease after move, ttl %d -> %d (%v)", ttl, moved, err)
+		}
+		if _, err := registry.ServiceTimeToLive(context.Background(), oldKey); !errors.Is(err, ErrServiceNotRegistered) {
+			t.Errorf("Expected old key to be forgotten, got %v", err)
+		}
+
+		// 旧 key 在 etcd 中已经不存在时返回 ErrServiceNotFound
+		if _, err := cli.Delete(context.Background(), newKey); err != nil {
+			t.Fatalf("Failed to delete key: %v", err)
+		}
+		if _, err := registry.MoveInstance(context.Background(), newKey, oldName); !errors.Is(err, ErrServiceNotFound) {
+			t.Errorf("Expected ErrServiceNotFound, got %v", err)
+		}
+		if err := registry.DeRegistryService(context.Background(), newKey); err != nil {
+			t.Errorf("Failed to deregister moved instance: %v", err)
+		}
+	}
+}
+
+func TestTryRegistry(t *testing.T) {
+	const name = "try_registry_service"
+	registries := make([]*RegistryEtcd, 4)
+	for i := range registries {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		defer registry.DeRegistry(context.Background())
+		registries[i] = registry
+	}
+	ok, err := registries[0].TryRegistry(context.Background(), &OrderService{name: name, addr: "localhost:9981"})
+	if err != nil || !ok {
+		t.Fatalf("Expected first TryRegistry to register, got %v, %v", ok, err)
+	}
+	// 相同服务名和地址不会重复注册
+	ok, err = registries[1].TryRegistry(context.Background(), &OrderService{name: name, addr: "localhost:9981"})
+	if err != nil || ok {
+		t.Fatalf("Expected duplicate TryRegistry to be skipped, got %v, %v", ok, err)
+	}
+
+	// 同时注册同一个新地址，只有一个成功
+	var wg sync.WaitGroup
+	var registered atomic.Int32
+	for _, registry := range registries[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := registry.TryRegistry(context.Background(), &OrderService{name: name, addr: "localhost:9982"})
+			if err != nil {
+				t.Errorf("TryRegistry failed: %v", err)
+			}
+			if ok {
+				registered.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := registered.Load(); n != 1 {
+		t.Errorf("Expected exactly one concurrent TryRegistry to register, got %d", n)
+	}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	addrs, err := discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if len(addrs) != 2 {
+		t.Errorf("Expected one instance per address, got %v", addrs)
+	}
+
+	// 注销后占位 key 随租约释放，可以再次注册
+	if err := registries[0].DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	ok, err = registries[1].TryRegistry(context.Background(), &OrderService{name: name, addr: "localhost:9981"})
+	if err != nil || !ok {
+		t.Errorf("Expected TryRegistry after deregister to register, got %v, %v", ok, err)
+	}
+}
+
+// keepAliveGoroutines 统计仍在运行的注册端续约 goroutine，包括 LeaseManager（WithSharedLease、RegistryBatch）的续约
+func keepAliveGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	stacks := string(buf)
+	return strings.Count(stacks, "(*RegistryEtcd).startKeepAlive.func") + strings.Count(stacks, "(*LeaseManager).grant.func")
+}
+
+func TestRegistryKeepAliveGoroutinesStop(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		opts  []Option
+		batch bool
+	}{
+		{"per-service lease", nil, false},
+		{"shared lease", []Option{WithSharedLease()}, false},
+		{"registry batch", nil, true},
+	} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, tc.opts...)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		// 每轮注册两个实例
+		register := func(i int) {
+			t.Helper()
+			services := []Service{
+				&OrderService{name: "keepalive_leak_service", addr: fmt.Sprintf("localhost:%d", 10100+i)},
+				&OrderService{name: "keepalive_leak_service", addr: fmt.Sprintf("localhost:%d", 10150+i)},
+			}
+			if tc.batch {
+				if err := registry.RegistryBatch(context.Background(), services); err != nil {
+					t.Fatalf("%s: failed to register batch: %v", tc.desc, err)
+				}
+				return
+			}
+			for _, svc := range services {
+				if _, err := registry.Registry(context.Background(), svc); err != nil {
+					t.Fatalf("%s: failed to register: %v", tc.desc, err)
+				}
+			}
+		}
+		// 第一轮注册启动客户端内部的续约循环，之后再记录基线
+		register(0)
+		if err := registry.DeRegistry(context.Background()); err != nil {
+			t.Fatalf("%s: failed to deregister: %v", tc.desc, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < 50; i++ {
+			register(i)
+			if err := registry.DeRegistry(context.Background()); err != nil {
+				t.Fatalf("%s: failed to deregister: %v", tc.desc, err)
+			}
+			// DeRegistry 返回时续约 goroutine 已经退出，不需要等待
+			if n := keepAliveGoroutines(); n != 0 {
+				t.Fatalf("%s: round %d: %d keepalive goroutines still running after DeRegistry", tc.desc, i, n)
+			}
+		}
+		// 客户端内部的 goroutine 异步退出，给它们一点时间
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: goroutines grew from %d to %d after register/deregister cycles", tc.desc, before, runtime.NumGoroutine())
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		register(0)
+		if keepAliveGoroutines() == 0 {
+			t.Fatalf("%s: expected a keepalive goroutine for the registered instances", tc.desc)
+		}
+		if err := registry.Close(); err != nil {
+			t.Fatalf("%s: Close failed: %v", tc.desc, err)
+		}
+		if n := keepAliveGoroutines(); n != 0 {
+			t.Errorf("%s: %d keepalive goroutines still running after Close", tc.desc, n)
+		}
+	}
+}
+
+// TestRegistrySharedLeaseRegrantStopsOnClose 共享租约丢失后等待重新申请时，Close 立即结束等待，
+// 返回后不会再有 goroutine 在关闭的客户端上申请租约
+func TestRegistrySharedLeaseRegrantStopsOnClose(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL,
+		WithSharedLease(), WithReRegister(RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Second}))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	if _, err := registry.Registry(context.Background(), &OrderService{name: "regrant_close_service", addr: "localhost:10201"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	// 外部撤销共享租约，续约 goroutine 进入重新申请前的退避等待
+	if _, err := registry.client.Revoke(context.Background(), registry.shared.LeaseID()); err != nil {
+		t.Fatalf("Failed to revoke shared lease: %v", err)
+	}
+	select {
+	case <-registry.KeepAliveErrors():
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected the lease loss to be reported")
+	}
+
+	start := time.Now()
+	if err := registry.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Close took %v, expected it to interrupt the re-grant backoff", elapsed)
+	}
+	if n := keepAliveGoroutines(); n != 0 {
+		t.Errorf("%d keepalive goroutines still running after Close", n)
+	}
+}
+
+func TestRegistryRegistered(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	if infos := registry.Registered(); len(infos) != 0 {
+		t.Fatalf("Expected no registrations before Registry, got %v", infos)
+	}
+
+	want := map[string]string{}
+	for _, svc := range []*OrderService{
+		{name: "registered_order_service", addr: "localhost:9891"},
+		{name: "registered_user_service", addr: "localhost:9892"},
+	} {
+		key, err := registry.Registry(context.Background(), svc)
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", svc.name, err)
+		}
+		want[key] = svc.addr
+	}
+
+	// 等到至少收到一次续约响应，缓存的 TTL 来自续约而不是注册时的默认值
+	time.Sleep(2 * time.Second)
+	infos := registry.Registered()
+	if len(infos) != 2 {
+		t.Fatalf("Registered returned %d instances, want 2: %v", len(infos), infos)
+	}
+	if infos[0].Key > infos[1].Key {
+		t.Errorf("Registered not sorted by key: %v", infos)
+	}
+	for _, info := range infos {
+		addr, ok := want[info.Key]
+		if !ok {
+			t.Errorf("Unexpected key %s in Registered", info.Key)
+			continue
+		}
+		if info.Addr != addr {
+			t.Errorf("Registered[%s].Addr = %q, want %q", info.Key, info.Addr, addr)
+		}
+		if !strings.HasPrefix(info.Key, info.Name+"/") {
+			t.Errorf("Registered[%s].Name = %q does not match key", info.Key, info.Name)
+		}
+		if info.LeaseID == clientv3.NoLease || info.TTL <= 0 || info.TTL > LeaseTTL {
+			t.Errorf("Registered[%s] lease %x ttl %d, want an active lease", info.Key, info.LeaseID, info.TTL)
+		}
+	}
+
+	if err := registry.DeRegistryService(context.Background(), infos[0].Key); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if infos := registry.Registered(); len(infos) != 1 || infos[0].Key == "" {
+		t.Errorf("Expected one registration after DeRegistryService, got %v", infos)
+	}
+}
+
+// refusingLease 的前 refusals 次 Grant 返回连接被拒绝，模拟 etcd 还没有启动
+type refusingLease struct {
+	clientv3.Lease
+	refusals int32
+	grants   int32
+}
+
+func (l *refusingLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	atomic.AddInt32(&l.grants, 1)
+	if atomic.AddInt32(&l.refusals, -1) >= 0 {
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	}
+	return l.Lease.Grant(ctx, ttl)
+}
+
+func TestRegistryConnectRetry(t *testing.T) {
+	const name = "connect_retry_service"
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithConnectRetry(5*time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	lease := &refusingLease{Lease: registry.lease, refusals: 2}
+	registry.lease = lease
+
+	key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9901"})
+	if err != nil {
+		t.Fatalf("Expected Registry to retry until etcd is reachable, got %v", err)
+	}
+	if n := atomic.LoadInt32(&lease.grants); n != 3 {
+		t.Errorf("Expected 3 Grant attempts, got %d", n)
+	}
+	resp, err := registry.client.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("Expected %s to be written after retries, got %v, %v", key, resp, err)
+	}
+
+	// 超过 maxWait 后返回最后一次的错误
+	short, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithConnectRetry(300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer short.Close()
+	short.lease = &refusingLease{Lease: short.lease, refusals: math.MaxInt32}
+	if _, err := short.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9902"}); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Registry after maxWait = %v, want deadline exceeded with the last error", err)
+	}
+
+	// 不开启 WithConnectRetry 时第一次失败直接返回
+	plain, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer plain.Close()
+	plainLease := &refusingLease{Lease: plain.lease, refusals: 1}
+	plain.lease = plainLease
+	if _, err := plain.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9903"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Registry without connect retry = %v, want Unavailable", err)
+	}
+	if n := atomic.LoadInt32(&plainLease.grants); n != 1 {
+		t.Errorf("Expected a single Grant attempt without connect retry, got %d", n)
+	}
 }
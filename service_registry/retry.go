@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy 描述单个 etcd 操作（Grant、Put、Get 等）的重试策略
+// 它和 clientv3 内部的 gRPC 重试相互独立：连接层的重试处理建连，这里处理单次调用的临时失败
+type RetryPolicy struct {
+	MaxAttempts int           // 总尝试次数，小于等于 1 表示不重试
+	BaseDelay   time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxDelay    time.Duration // 单次等待的上限，0 表示不设上限
+	Jitter      float64       // 每次等待在 [1-Jitter, 1+Jitter] 倍之间随机抖动，避免多个客户端同时重试
+}
+
+// backoff 返回第 attempt 次失败后的等待时间
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			delay = p.MaxDelay
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// withRetry 按策略执行 op，只有临时性错误才会重试
+// timeout 大于 0 时限制的是包括所有重试和等待在内的总时间，而不是单次调用的时间
+func withRetry(ctx context.Context, policy RetryPolicy, timeout time.Duration, op func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w after %d attempts, last error: %v", ctx.Err(), attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable 判断错误是否是临时性的：连接不可用（包括连接被拒绝）、没有 leader、leader 切换、请求过多等
+// 服务不存在、记录无法解码这类结果不是 etcd 调用的错误，不会进入重试
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var code codes.Code
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		code = etcdErr.Code()
+	} else if s, ok := status.FromError(err); ok {
+		code = s.Code()
+	} else {
+		return false
+	}
+	return code == codes.Unavailable || code == codes.ResourceExhausted
+}
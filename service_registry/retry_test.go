@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithRetryTransientThenSuccess(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond}
+	calls := 0
+	err := withRetry(context.Background(), policy, time.Second, func(ctx context.Context) error {
+		calls++
+		if calls <= 2 {
+			return rpctypes.ErrNoLeader
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success after transient failures, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestWithRetryTimeoutBoundsAllAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 100, BaseDelay: 20 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	start := time.Now()
+	err := withRetry(context.Background(), policy, 150*time.Millisecond, func(ctx context.Context) error {
+		return status.Error(codes.Unavailable, "connection refused")
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Retries took %v, should be bounded by the request timeout", elapsed)
+	}
+}
+
+func TestWithRetryNonRetryable(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), RetryPolicy{MaxAttempts: 5}, 0, func(ctx context.Context) error {
+		calls++
+		return rpctypes.ErrLeaseNotFound
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected a single failed attempt, got %d attempts, err %v", calls, err)
+	}
+}
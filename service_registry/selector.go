@@ -0,0 +1,75 @@
+package main
+
+import "sort"
+
+// selector 是发现端从实例中选择地址的公共逻辑：排空和健康探测过滤、负载均衡
+// DiscoveryEtcd 和 MemoryDiscovery 共用它，两者对同一组实例的选择行为一致
+type selector struct {
+	balancer LoadBalancer
+	// GetServiceAddrForKey 使用的负载均衡器
+	keyed KeyedBalancer
+	// 配置 WithHealthCheck 时的健康探测
+	health *healthChecker
+	// 配置 WithDrainAware 时跳过正在排空的实例
+	drainAware bool
+}
+
+func newSelector(o options) selector {
+	s := selector{balancer: o.balancer, drainAware: o.drainAware}
+	if kb, ok := o.balancer.(KeyedBalancer); ok {
+		s.keyed = kb
+	} else {
+		s.keyed = NewConsistentHashBalancer(DefaultHashReplicas)
+	}
+	if o.healthCheck != nil {
+		s.health = newHealthChecker(o.healthCheck, o.healthCheckTTL)
+	}
+	return s
+}
+
+// healthy 过滤正在排空和探测失败的实例，都没有配置时原样返回；全部被过滤时返回 ErrNoHealthyInstances
+func (s *selector) healthy(instances []ServiceInstance) ([]ServiceInstance, error) {
+	if instances = s.serving(instances); len(instances) == 0 {
+		return nil, ErrNoHealthyInstances
+	}
+	if s.health == nil {
+		return instances, nil
+	}
+	if instances = s.health.filter(instances); len(instances) == 0 {
+		return nil, ErrNoHealthyInstances
+	}
+	return instances, nil
+}
+
+// serving 在配置了 WithDrainAware 时去掉正在排空的实例
+func (s *selector) serving(instances []ServiceInstance) []ServiceInstance {
+	if !s.drainAware {
+		return instances
+	}
+	filtered := make([]ServiceInstance, 0, len(instances))
+	for _, inst := range instances {
+		if !inst.Draining() {
+			filtered = append(filtered, inst)
+		}
+	}
+	return filtered
+}
+
+// pick 用配置的负载均衡器从非空的实例列表中选择一个
+func (s *selector) pick(name string, instances []ServiceInstance) ServiceInstance {
+	return s.balancer.Pick(name, instances)
+}
+
+// uniqueAddrs 返回实例的地址，去重并排序
+func uniqueAddrs(instances []ServiceInstance) []string {
+	seen := make(map[string]bool, len(instances))
+	addrs := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		if !seen[inst.Addr] {
+			seen[inst.Addr] = true
+			addrs = append(addrs, inst.Addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// serviceIndexPrefix 是服务名索引 key 的前缀，索引 key 不绑定租约，由读取方清理过期条目
+const serviceIndexPrefix = "/index/"
+
+func serviceIndexKey(name string) string {
+	return serviceIndexPrefix + name
+}
+
+// ListIndexedServices 通过注册端维护的索引（见 WithServiceIndex）列出已注册的服务名，结果已排序
+// 实例因租约过期消失时索引不会同步删除，读取时发现某个服务已没有实例就顺带清理该索引
+func (d *DiscoveryEtcd) ListIndexedServices(ctx context.Context) ([]string, error) {
+	resp, err := d.client.Get(ctx, serviceIndexPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), serviceIndexPrefix)
+		live, err := pruneServiceIndex(ctx, d.client, name, kv.ModRevision)
+		if err != nil {
+			return nil, err
+		}
+		if live {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// pruneServiceIndex 检查服务是否还有实例，没有时删除它的索引 key，返回服务是否仍然存在
+// 删除在事务中比较索引的 ModRevision，检查之后有新实例注册（重新写入索引）时不会误删
+func pruneServiceIndex(ctx context.Context, client *clientv3.Client, name string, indexRev int64) (bool, error) {
+	countResp, err := client.Get(ctx, serviceKeyPrefix(name), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	if countResp.Count > 0 {
+		return true, nil
+	}
+	key := serviceIndexKey(name)
+	_, err = client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", indexRev)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return false, err
+}
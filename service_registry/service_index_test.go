@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestServiceIndex(t *testing.T) {
+	services := []Service{
+		&OrderService{name: "indexed_order", addr: "localhost:9601"},
+		&OrderService{name: "indexed_order", addr: "localhost:9602"},
+		&OrderService{name: "indexed_user", addr: "localhost:9603"},
+	}
+	var registries []*RegistryEtcd
+	for _, s := range services {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithServiceIndex())
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		defer registry.Close()
+		if _, err := registry.Registry(context.Background(), s); err != nil {
+			t.Fatalf("Failed to register %s: %v", s.Addr(), err)
+		}
+		registries = append(registries, registry)
+	}
+
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	names, err := discovery.ListIndexedServices(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	if want := []string{"indexed_order", "indexed_user"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListIndexedServices = %v, want %v", names, want)
+	}
+
+	// 同名服务还有实例时注销不删除索引
+	if err := registries[0].DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	names, err = discovery.ListIndexedServices(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	if want := []string{"indexed_order", "indexed_user"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListIndexedServices after partial deregister = %v, want %v", names, want)
+	}
+
+	// 最后一个实例注销后索引被删除
+	for _, r := range registries[1:] {
+		if err := r.DeRegistry(context.Background()); err != nil {
+			t.Fatalf("Failed to deregister: %v", err)
+		}
+	}
+	names, err = discovery.ListIndexedServices(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("Expected index entries to be removed, got %v", names)
+	}
+	resp, err := discovery.client.Get(context.Background(), serviceIndexPrefix+"indexed_", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Failed to get index keys: %v", err)
+	}
+	if len(resp.Kvs) != 0 {
+		t.Errorf("Expected stale index keys deleted, found %d", len(resp.Kvs))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrSessionExpired 表示会话中的租约已经过期，实例已经从 etcd 中消失，调用方需要重新注册
+var ErrSessionExpired = errors.New("session lease expired")
+
+// sessionVersion 是会话令牌的格式版本，格式不兼容的修改需要递增
+const sessionVersion = 1
+
+// sessionToken 是 ExportSession 导出的内容
+type sessionToken struct {
+	Version  int              `json:"version"`
+	LeaseTTL int64            `json:"lease_ttl"`
+	Services []sessionService `json:"services"`
+}
+
+// sessionService 是会话中的一个实例，Value 可能是二进制记录，按 []byte 编码为 base64
+type sessionService struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+	TTL   int64  `json:"ttl"`
+	Lease int64  `json:"lease"`
+}
+
+// ExportSession 把已注册实例的 key、记录和租约 ID 导出为令牌，进程重启后可以用 ResumeSession 接管这些实例
+// 导出后应当用 Close 而不是 DeRegistry 退出，Close 只停止续约，租约在 TTL 内仍然有效
+// 挂在共享租约上的实例（WithSharedLease、RegistryBatch）不支持导出
+func (r *RegistryEtcd) ExportSession() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.services) == 0 {
+		return nil, ErrServiceNotRegistered
+	}
+	token := sessionToken{Version: sessionVersion, LeaseTTL: r.leaseTTL}
+	for key, svc := range r.services {
+		if svc.lease != nil {
+			return nil, fmt.Errorf("export session: %s is on a shared lease", key)
+		}
+		token.Services = append(token.Services, sessionService{
+			Key:   key,
+			Name:  svc.name,
+			Value: []byte(svc.value),
+			TTL:   svc.ttl,
+			Lease: int64(svc.leaseID),
+		})
+	}
+	return json.Marshal(token)
+}
+
+// ResumeSession 用 ExportSession 导出的令牌创建 RegistryEtcd，确认每个租约仍然有效后在原租约上恢复续约，
+// 不重新写入记录，发现端看到的实例保持不变。opts 需要和导出时使用相同的命名空间
+// 任意一个租约已经过期时返回 ErrSessionExpired，调用方应改为重新注册；成功后 client 由返回的 RegistryEtcd 负责关闭
+// 开启 WithNamespace 时 client 会被包装为命名空间客户端，传入的 client 不能已经带有命名空间
+func ResumeSession(client *clientv3.Client, token []byte, opts ...Option) (*RegistryEtcd, error) {
+	var t sessionToken
+	if err := json.Unmarshal(token, &t); err != nil {
+		return nil, fmt.Errorf("decode session token: %w", err)
+	}
+	if t.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session token version %d", t.Version)
+	}
+	r := &RegistryEtcd{
+		client:        client,
+		lease:         client,
+		leaseTTL:      t.LeaseTTL,
+		opts:          newOptions(opts),
+		services:      make(map[string]*registeredService),
+		keepAliveErrs: make(chan error, 16),
+	}
+	applyNamespace(client, r.opts.namespace)
+	// 先确认全部租约有效，再恢复续约，避免只接管一部分实例
+	ctx := context.Background()
+	for _, s := range t.Services {
+		leaseID := clientv3.LeaseID(s.Lease)
+		if _, err := r.leaseTimeToLive(ctx, leaseID); err != nil {
+			if errors.Is(err, ErrLeaseLost) {
+				return nil, fmt.Errorf("%w: %s (lease %x)", ErrSessionExpired, s.Key, leaseID)
+			}
+			return nil, err
+		}
+	}
+	for _, s := range t.Services {
+		svc := &registeredService{name: s.Name, value: string(s.Value), ttl: s.TTL}
+		if err := r.startKeepAlive(s.Key, svc, clientv3.LeaseID(s.Lease)); err != nil {
+			r.stopKeepAlives()
+			return nil, err
+		}
+		r.mu.Lock()
+		r.services[s.Key] = svc
+		if done := r.opts.deregisterCtx; done != nil {
+			key := s.Key
+			svc.stopDeregister = context.AfterFunc(done, func() { r.deregisterOnDone(key) })
+		}
+		r.mu.Unlock()
+		r.opts.logger.Debugf("resumed %s on lease %x", s.Key, s.Lease)
+	}
+	return r, nil
+}
+
+// stopKeepAlives 停止已经恢复的续约，租约不撤销，留给下一次 ResumeSession
+func (r *RegistryEtcd) stopKeepAlives() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, svc := range r.services {
+		svc.halt()
+		svc.stopOnDone()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func newTestClient(t *testing.T) *clientv3.Client {
+	t.Helper()
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create etcd client: %v", err)
+	}
+	return cli
+}
+
+func TestResumeSession(t *testing.T) {
+	const name = "resumed_session_service"
+	const ttl = 2
+	old, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, ttl)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	keys := make([]string, 0, 2)
+	for _, addr := range []string{"localhost:9981", "localhost:9982"} {
+		key, err := old.Registry(context.Background(), &OrderService{name: name, addr: addr})
+		if err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	token, err := old.ExportSession()
+	if err != nil {
+		t.Fatalf("ExportSession failed: %v", err)
+	}
+	// 模拟进程退出：停止续约但不撤销租约
+	old.Close()
+
+	resumed, err := ResumeSession(newTestClient(t), token)
+	if err != nil {
+		t.Fatalf("ResumeSession failed: %v", err)
+	}
+	defer resumed.Close()
+	defer resumed.DeRegistry(context.Background())
+
+	// 超过原租约的 TTL 后实例仍然存在，说明续约已经恢复
+	time.Sleep((ttl + 1) * time.Second)
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	addrs, err := discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if want := []string{"localhost:9981", "localhost:9982"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("After resume got %v, want %v", addrs, want)
+	}
+	for _, key := range keys {
+		if _, err := resumed.ServiceTimeToLive(context.Background(), key); err != nil {
+			t.Errorf("Resumed registry does not know %s: %v", key, err)
+		}
+	}
+
+	// 接管后可以正常注销
+	if err := resumed.DeRegistryService(context.Background(), keys[0]); err != nil {
+		t.Fatalf("Failed to deregister resumed instance: %v", err)
+	}
+	addrs, err = discovery.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if want := []string{"localhost:9982"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("After deregister got %v, want %v", addrs, want)
+	}
+}
+
+func TestResumeSessionExpired(t *testing.T) {
+	old, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, 1)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	if _, err := old.ExportSession(); !errors.Is(err, ErrServiceNotRegistered) {
+		t.Errorf("Expected ErrServiceNotRegistered for an empty registry, got %v", err)
+	}
+	if _, err := old.Registry(context.Background(), &OrderService{name: "expired_session_service", addr: "localhost:9983"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	token, err := old.ExportSession()
+	if err != nil {
+		t.Fatalf("ExportSession failed: %v", err)
+	}
+	old.Close()
+	time.Sleep(2500 * time.Millisecond)
+
+	cli := newTestClient(t)
+	defer cli.Close()
+	if _, err := ResumeSession(cli, token); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected ErrSessionExpired, got %v", err)
+	}
+	if _, err := ResumeSession(cli, []byte("not a token")); err == nil || errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected a decode error for a malformed token, got %v", err)
+	}
+}
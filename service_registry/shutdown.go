@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout 是 RunUntilSignal 注销全部服务的默认最长时间，可以用 WithShutdownTimeout 修改
+const DefaultShutdownTimeout = 5 * time.Second
+
+// RunUntilSignal 阻塞到 ctx 结束或收到 SIGINT/SIGTERM，然后在 WithShutdownTimeout 设置的时间内注销 r 的全部服务
+// 返回注销的错误；客户端连接不会被关闭，需要时调用方再调用 Close
+func RunUntilSignal(ctx context.Context, r *RegistryEtcd) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	// 原 ctx 可能已经取消，注销使用独立的超时
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), r.opts.shutdownTimeout)
+	defer cancel()
+	return r.DeRegistry(shutdownCtx)
+}
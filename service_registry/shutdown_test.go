@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunUntilSignal(t *testing.T) {
+	const name = "graceful_shutdown_service"
+	for _, tc := range []struct {
+		desc    string
+		trigger func(cancel context.CancelFunc)
+	}{
+		{"context cancelled", func(cancel context.CancelFunc) { cancel() }},
+		{"SIGTERM", func(context.CancelFunc) {
+			p, _ := os.FindProcess(os.Getpid())
+			p.Signal(syscall.SIGTERM)
+		}},
+	} {
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9821"})
+		if err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- RunUntilSignal(ctx, registry) }()
+		// 等待 RunUntilSignal 开始监听信号
+		time.Sleep(100 * time.Millisecond)
+		tc.trigger(cancel)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%s: RunUntilSignal returned %v", tc.desc, err)
+			}
+		case <-time.After(DefaultShutdownTimeout + time.Second):
+			t.Fatalf("%s: RunUntilSignal did not return", tc.desc)
+		}
+		if resp, err := registry.client.Get(context.Background(), key); err != nil || len(resp.Kvs) != 0 {
+			t.Errorf("%s: expected %s to be deregistered, err %v", tc.desc, key, err)
+		}
+		cancel()
+		registry.Close()
+	}
+}
+
+func TestRunUntilSignalDeRegistryError(t *testing.T) {
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, WithShutdownTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	// 连接关闭后注销必然失败，错误应当在超时内返回给调用方
+	registry.client.Close()
+	registry.services["graceful_shutdown_error_service-1"] = &registeredService{
+		name:            "graceful_shutdown_error_service",
+		cancelKeepAlive: func() {},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := RunUntilSignal(ctx, registry); err == nil {
+		t.Errorf("Expected deregistration error after the client was closed")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RunUntilSignal took %v, should be bounded by the shutdown timeout", elapsed)
+	}
+}
+
+func TestDeregisterOnContextDone(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		opts []Option
+	}{
+		{"per-service lease", nil},
+		{"shared lease", []Option{WithSharedLease()}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL, append(tc.opts, WithDeregisterOnContextDone(ctx))...)
+		if err != nil {
+			t.Fatalf("Failed to create etcd registry: %v", err)
+		}
+		key, err := registry.Registry(context.Background(), &OrderService{name: "context_bound_service", addr: "localhost:9822"})
+		if err != nil {
+			t.Fatalf("%s: failed to register: %v", tc.desc, err)
+		}
+		registry.mu.Lock()
+		leaseID := registry.services[key].leaseID
+		registry.mu.Unlock()
+
+		cancel()
+		deadline := time.Now().Add(time.Second)
+		for {
+			resp, err := registry.client.Get(context.Background(), key)
+			if err != nil {
+				t.Fatalf("%s: failed to get key: %v", tc.desc, err)
+			}
+			if len(resp.Kvs) == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: key still present 1s after context cancel", tc.desc)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		// 租约随后被撤销，不会留到 TTL 过期
+		time.Sleep(100 * time.Millisecond)
+		if resp, err := registry.client.TimeToLive(context.Background(), leaseID); err != nil || resp.TTL != -1 {
+			t.Errorf("%s: expected lease revoked, got %+v, %v", tc.desc, resp, err)
+		}
+		if err := registry.DeRegistry(context.Background()); err != nil {
+			t.Errorf("%s: DeRegistry after context done: %v", tc.desc, err)
+		}
+		registry.Close()
+	}
+}
+
+func TestDeRegistryWithDrain(t *testing.T) {
+	const name = "drained_service"
+	draining, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer draining.Close()
+	defer draining.DeRegistry(context.Background())
+	drainedKey, err := draining.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9961"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	staying, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer staying.Close()
+	defer staying.DeRegistry(context.Background())
+	if _, err := staying.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9962"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	aware, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithDrainAware())
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer aware.Close()
+	plain, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer plain.Close()
+	ch, err := aware.WatchService(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	receiveAddr(t, ch)
+
+	done := make(chan error, 1)
+	go func() { done <- draining.DeRegistryWithDrain(context.Background(), time.Second) }()
+
+	// 排空期间实例仍然注册，但带有排空标记
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		instances, err := plain.GetServiceInstances(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Failed to get instances: %v", err)
+		}
+		marked := false
+		for _, inst := range instances {
+			marked = marked || (inst.Key == drainedKey && inst.Draining())
+		}
+		if marked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Instance was not marked draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if addr := receiveAddr(t, ch); addr != "localhost:9962" {
+		t.Errorf("WatchService sent %q while draining, want the remaining instance", addr)
+	}
+	for i := 0; i < 10; i++ {
+		if addr, err := aware.GetServiceAddr(context.Background(), name); err != nil || addr != "localhost:9962" {
+			t.Fatalf("GetServiceAddr = %q, %v while draining, want the remaining instance", addr, err)
+		}
+	}
+	addrs, err := plain.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if want := []string{"localhost:9961", "localhost:9962"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("Discovery without WithDrainAware saw %v, want %v", addrs, want)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("DeRegistryWithDrain failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("DeRegistryWithDrain did not return after the drain period")
+	}
+	addrs, err = plain.GetAllServiceAddrs(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get addresses: %v", err)
+	}
+	if want := []string{"localhost:9962"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("After drain got %v, want %v", addrs, want)
+	}
+
+	// 剩下的实例也开始排空后，发现端没有可选的实例
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := staying.DeRegistryWithDrain(ctx, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected drain to stop with the context, got %v", err)
+	}
+	if _, err := aware.GetServiceAddr(context.Background(), name); !errors.Is(err, ErrNoHealthyInstances) {
+		t.Errorf("Expected ErrNoHealthyInstances with every instance draining, got %v", err)
+	}
+}
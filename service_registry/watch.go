@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ChangeType 是服务记录变更的类型，与 etcd 的事件类型一一对应
+type ChangeType int
+
+const (
+	ChangePut    ChangeType = iota // 新增或更新实例
+	ChangeDelete                   // 实例被删除（注销或租约过期）
+)
+
+// ServiceChange 描述一次服务记录变更
+type ServiceChange struct {
+	Type     ChangeType
+	Key      string
+	Record   ServiceRecord // 删除事件中为空
+	Revision int64
+}
+
+// ApplyChanges 按顺序把一批变更应用到以 key 为索引的实例集合上
+// 批量回调中的变更按 revision 排列，依次应用即可得到批次结束时的一致状态
+func ApplyChanges(state map[string]ServiceRecord, changes []ServiceChange) {
+	for _, c := range changes {
+		switch c.Type {
+		case ChangePut:
+			state[c.Key] = c.Record
+		case ChangeDelete:
+			delete(state, c.Key)
+		}
+	}
+}
+
+// WatchServiceBatched 监听服务的实例变化，把变更攒够 maxBatch 条或等待 maxDelay 后一次性交给 fn
+// 服务变化频繁时可以显著减少回调次数；fn 在同一个 goroutine 中串行调用
+// 监听在 ctx 取消或调用 Close 后结束，结束前会先交付尚未交付的变更
+func (d *DiscoveryEtcd) WatchServiceBatched(ctx context.Context, name string, maxBatch int, maxDelay time.Duration, fn func([]ServiceChange)) error {
+	if maxBatch <= 0 || maxDelay <= 0 {
+		return errors.New("maxBatch and maxDelay must be positive")
+	}
+	if fn == nil {
+		return errors.New("batch callback cannot be nil")
+	}
+	// Close 时同样结束监听
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.ctx, cancel)
+	watchCh := d.watcher.Watch(ctx, serviceKeyPrefix(name), clientv3.WithPrefix())
+	go func() {
+		defer stop()
+		defer cancel()
+		var (
+			batch []ServiceChange
+			timer *time.Timer
+			timeC <-chan time.Time
+		)
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, timeC = nil, nil
+			}
+			if len(batch) > 0 {
+				fn(batch)
+				batch = nil
+			}
+		}
+		defer flush()
+		for {
+			select {
+			case resp, ok := <-watchCh:
+				if !ok {
+					return
+				}
+				if err := resp.Err(); err != nil {
+					d.opts.logger.Warnf("batched watch for %s stopped: %v", name, err)
+					return
+				}
+				for _, ev := range resp.Events {
+					change, ok := newServiceChange(ev, d.opts)
+					if !ok {
+						continue
+					}
+					batch = append(batch, change)
+					if len(batch) >= maxBatch {
+						flush()
+					}
+				}
+				// 批次中的第一条变更开始计时
+				if len(batch) > 0 && timer == nil {
+					timer = time.NewTimer(maxDelay)
+					timeC = timer.C
+				}
+			case <-timeC:
+				timer, timeC = nil, nil
+				flush()
+			}
+		}
+	}()
+	return nil
+}
+
+// newServiceChange 把 etcd 事件转换为服务变更，无法解码的记录被跳过
+func newServiceChange(ev *clientv3.Event, o options) (ServiceChange, bool) {
+	change := ServiceChange{
+		Key:      string(ev.Kv.Key),
+		Revision: ev.Kv.ModRevision,
+	}
+	if ev.Type == clientv3.EventTypeDelete {
+		change.Type = ChangeDelete
+		return change, true
+	}
+	rec, err := o.decode(ev.Kv.Value)
+	if err != nil {
+		return ServiceChange{}, false
+	}
+	change.Type = ChangePut
+	change.Record = rec
+	return change, true
+}
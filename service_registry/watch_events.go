@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EventType 是 ServiceEvent 的类型
+type EventType int
+
+const (
+	EventAdded   EventType = iota // 实例注册或记录更新
+	EventRemoved                  // 实例注销或租约过期
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "Added"
+	case EventRemoved:
+		return "Removed"
+	default:
+		return "Unknown"
+	}
+}
+
+// ServiceEvent 描述一个服务实例的出现或消失
+type ServiceEvent struct {
+	Type EventType
+	// Name 是实例所属的服务名，WatchServices 合并多个服务的事件时用它区分来源
+	Name     string
+	Key      string
+	Addr     string
+	Metadata map[string]string
+}
+
+// WatchServiceEvents 监听服务实例的增删，把 etcd 的 PUT 事件转换为 EventAdded、DELETE 事件转换为 EventRemoved
+// 删除事件通过 WithPrevKV 取得被删除的记录，所以 Removed 事件同样带有地址和元数据
+// 只发送订阅之后发生的变化；取消 ctx 或调用 Close 后通道关闭
+func (d *DiscoveryEtcd) WatchServiceEvents(ctx context.Context, name string) (<-chan ServiceEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.ctx, cancel)
+	watchCh := d.client.Watch(ctx, serviceKeyPrefix(name), clientv3.WithPrefix(), clientv3.WithPrevKV())
+	ch := make(chan ServiceEvent, 16)
+	go func() {
+		defer close(ch)
+		defer stop()
+		defer cancel()
+		for resp := range watchCh {
+			if err := resp.Err(); err != nil {
+				d.opts.logger.Warnf("event watch for %s stopped: %v", name, err)
+				return
+			}
+			for _, ev := range resp.Events {
+				event, ok := newServiceEvent(name, ev, d.opts)
+				if !ok {
+					continue
+				}
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// WatchServices 同时监听多个服务，把它们的事件合并到一个通道，事件的 Name 标明所属的服务
+// 每个服务一个 WatchServiceEvents，各自的事件保持顺序，不同服务之间的先后不做保证
+// 重复的服务名只监听一次；取消 ctx、调用 Close 或任意一个服务的监听出错结束时，全部监听结束，通道关闭
+func (d *DiscoveryEtcd) WatchServices(ctx context.Context, names []string) (<-chan ServiceEvent, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no service names to watch")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	seen := make(map[string]bool, len(names))
+	inputs := make([]<-chan ServiceEvent, 0, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		ch, err := d.WatchServiceEvents(ctx, name)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		inputs = append(inputs, ch)
+	}
+	out := make(chan ServiceEvent, 16)
+	var wg sync.WaitGroup
+	for _, in := range inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 一个服务的监听结束后其余的也停止，调用方看到通道关闭后可以重新订阅
+			defer cancel()
+			for event := range in {
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+	return out, nil
+}
+
+// newServiceEvent 把 etcd 事件转换为 ServiceEvent，无法解码的记录被跳过
+func newServiceEvent(name string, ev *clientv3.Event, o options) (ServiceEvent, bool) {
+	event := ServiceEvent{Type: EventAdded, Name: name, Key: string(ev.Kv.Key)}
+	value := ev.Kv.Value
+	if ev.Type == clientv3.EventTypeDelete {
+		event.Type = EventRemoved
+		if ev.PrevKv == nil {
+			// 旧记录已被压缩，只能给出 key
+			return event, true
+		}
+		value = ev.PrevKv.Value
+	}
+	rec, err := o.decode(value)
+	if err != nil {
+		return ServiceEvent{}, false
+	}
+	event.Addr = rec.Addr
+	event.Metadata = rec.Metadata
+	return event, true
+}
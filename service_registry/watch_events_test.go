@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, ch <-chan ServiceEvent) ServiceEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatalf("Event channel closed unexpectedly")
+		}
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for event")
+	}
+	return ServiceEvent{}
+}
+
+func TestWatchServiceEvents(t *testing.T) {
+	const name = "event_watched_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := discovery.WatchServiceEvents(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to watch service events: %v", err)
+	}
+
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	meta := map[string]string{"version": "v1"}
+	key, err := registry.Registry(context.Background(), metadataService{&OrderService{name: name, addr: "localhost:9641"}, meta})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	ev := receiveEvent(t, ch)
+	if ev.Type != EventAdded || ev.Key != key || ev.Addr != "localhost:9641" || ev.Metadata["version"] != "v1" {
+		t.Errorf("Unexpected added event %+v", ev)
+	}
+
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	ev = receiveEvent(t, ch)
+	if ev.Type != EventRemoved || ev.Key != key || ev.Addr != "localhost:9641" {
+		t.Errorf("Unexpected removed event %+v", ev)
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	names := []string{"fan_in_order", "fan_in_user"}
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := discovery.WatchServices(ctx, append(names, names[0]))
+	if err != nil {
+		t.Fatalf("Failed to watch services: %v", err)
+	}
+
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	keys := make(map[string]string)
+	for i, name := range names {
+		key, err := registry.Registry(context.Background(), &OrderService{name: name, addr: fmt.Sprintf("localhost:%d", 9991+i)})
+		if err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+		keys[key] = name
+	}
+	// 不同服务的事件之间没有顺序保证，按 key 检查
+	for range names {
+		ev := receiveEvent(t, ch)
+		if ev.Type != EventAdded || keys[ev.Key] != ev.Name {
+			t.Errorf("Unexpected added event %+v", ev)
+		}
+	}
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	removed := make(map[string]bool)
+	for range names {
+		ev := receiveEvent(t, ch)
+		if ev.Type != EventRemoved || keys[ev.Key] != ev.Name {
+			t.Errorf("Unexpected removed event %+v", ev)
+		}
+		removed[ev.Name] = true
+	}
+	if len(removed) != len(names) {
+		t.Errorf("Expected removals for %v, got %v", names, removed)
+	}
+	// 重复的服务名只监听一次，不会收到重复事件
+	select {
+	case ev := <-ch:
+		t.Errorf("Unexpected extra event %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("Expected channel to be closed after ctx cancel")
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Channel not closed after ctx cancel")
+	}
+	if _, err := discovery.WatchServices(context.Background(), nil); err == nil {
+		t.Errorf("Expected error for empty name list")
+	}
+}
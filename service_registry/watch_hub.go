@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var (
+	// ErrTooManySubscribers 表示服务的订阅者数量已达上限，新的订阅被拒绝
+	ErrTooManySubscribers = errors.New("too many subscribers for service")
+	// ErrSubscriberEvicted 表示订阅因为超过上限被新的订阅者挤掉
+	ErrSubscriberEvicted = errors.New("subscriber evicted")
+	// ErrSubscriberTooSlow 表示订阅者没有及时读取，缓冲已满，订阅被关闭，之后的变更不再投递
+	ErrSubscriberTooSlow = errors.New("subscriber too slow")
+	// ErrWatchStopped 表示订阅共用的 etcd Watch 已经结束（etcd 不可达、DiscoveryEtcd 关闭等），需要重新订阅
+	ErrWatchStopped = errors.New("shared watch stopped")
+)
+
+// subscriptionBuffer 是每个订阅的缓冲大小，缓冲满时订阅以 ErrSubscriberTooSlow 结束
+const subscriptionBuffer = 16
+
+// SubscriberPolicy 决定订阅者数量超过上限时的处理方式
+type SubscriberPolicy int
+
+const (
+	RejectNewSubscriber   SubscriberPolicy = iota // 拒绝新的订阅，返回 ErrTooManySubscribers
+	EvictOldestSubscriber                         // 关闭空闲最久的订阅，接受新的订阅，见 WithMaxSubscribers
+)
+
+// Subscription 是对一个服务变更的订阅，同一服务的全部订阅共用一个 etcd Watch
+type Subscription struct {
+	C       <-chan ServiceChange
+	Created time.Time
+
+	ch     chan ServiceChange
+	done   chan struct{}
+	once   sync.Once
+	err    error
+	shared *sharedWatch
+	// 缓冲从空变为非空的时间，即最早一条未读变更的投递时间，缓冲为空时无意义；sharedWatch.mu 保护
+	pendingSince time.Time
+}
+
+// Close 取消订阅，Done 随后关闭；最后一个订阅者取消后底层 Watch 也会停止
+func (s *Subscription) Close() {
+	s.shared.remove(s, nil)
+}
+
+// Done 在订阅结束（主动 Close、ctx 结束、被挤掉、读取过慢或 Watch 结束）时关闭
+// C 不会被关闭，消费者应同时 select C 和 Done
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscription) finish(reason error) {
+	s.once.Do(func() {
+		s.err = reason
+		close(s.done)
+	})
+}
+
+// Err 返回订阅结束的原因，订阅仍有效或被主动 Close 时返回 nil
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// sharedWatch 是一个服务的共享 Watch 及其订阅者
+type sharedWatch struct {
+	hub    *DiscoveryEtcd
+	name   string
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	subs []*Subscription // 按创建时间排序
+}
+
+// Subscribe 订阅服务的实例变更，同一服务的多个订阅共用一个 etcd Watch
+// 订阅数量受 WithMaxSubscribers 限制；ctx 结束或 DiscoveryEtcd 关闭时订阅自动关闭
+// 变更以非阻塞方式投递，缓冲满的订阅以 ErrSubscriberTooSlow 结束，不会拖慢同一服务的其他订阅者
+func (d *DiscoveryEtcd) Subscribe(ctx context.Context, name string) (*Subscription, error) {
+	d.hubMu.Lock()
+	defer d.hubMu.Unlock()
+	if d.watches == nil {
+		d.watches = make(map[string]*sharedWatch)
+	}
+	sw, ok := d.watches[name]
+	if !ok {
+		watchCtx, cancel := context.WithCancel(d.ctx)
+		sw = &sharedWatch{hub: d, name: name, cancel: cancel}
+		d.watches[name] = sw
+		go sw.run(d.watcher.Watch(watchCtx, serviceKeyPrefix(name), clientv3.WithPrefix()))
+	}
+
+	sw.mu.Lock()
+	var evicted *Subscription
+	if max := d.opts.maxSubscribers; max > 0 && len(sw.subs) >= max {
+		if d.opts.subscriberPolicy != EvictOldestSubscriber {
+			sw.mu.Unlock()
+			return nil, ErrTooManySubscribers
+		}
+		i := sw.idlest()
+		evicted = sw.subs[i]
+		sw.subs = append(sw.subs[:i], sw.subs[i+1:]...)
+	}
+	ch := make(chan ServiceChange, subscriptionBuffer)
+	sub := &Subscription{C: ch, Created: time.Now(), ch: ch, done: make(chan struct{}), shared: sw}
+	sw.subs = append(sw.subs, sub)
+	sw.mu.Unlock()
+
+	if evicted != nil {
+		evicted.finish(ErrSubscriberEvicted)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Close()
+		case <-sub.done:
+		}
+	}()
+	return sub, nil
+}
+
+// idlest 返回空闲最久的订阅的下标：有未读变更的订阅中最早开始积压的一个，
+// 全部订阅都已读完时返回创建最早的一个；调用方持有 sw.mu
+func (sw *sharedWatch) idlest() int {
+	victim := 0
+	for i, s := range sw.subs {
+		if len(s.ch) == 0 {
+			continue
+		}
+		if v := sw.subs[victim]; len(v.ch) == 0 || s.pendingSince.Before(v.pendingSince) {
+			victim = i
+		}
+	}
+	return victim
+}
+
+// run 把 Watch 事件分发给全部订阅者，Watch 结束时关闭剩余的订阅
+func (sw *sharedWatch) run(watchCh clientv3.WatchChan) {
+	var err error
+	for resp := range watchCh {
+		if err = resp.Err(); err != nil {
+			sw.hub.opts.logger.Warnf("shared watch for %s: %v", sw.name, err)
+			continue
+		}
+		for _, ev := range resp.Events {
+			change, ok := newServiceChange(ev, sw.hub.opts)
+			if !ok {
+				continue
+			}
+			for _, sub := range sw.deliver(change) {
+				sw.remove(sub, ErrSubscriberTooSlow)
+			}
+		}
+	}
+	sw.stop(err)
+}
+
+// deliver 非阻塞地把变更投递给每个订阅者，返回缓冲已满的订阅者
+func (sw *sharedWatch) deliver(change ServiceChange) (slow []*Subscription) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	now := time.Now()
+	for _, sub := range sw.subs {
+		if len(sub.ch) == 0 {
+			sub.pendingSince = now
+		}
+		select {
+		case sub.ch <- change:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	return slow
+}
+
+// stop 在 Watch 结束后把共享 Watch 从 DiscoveryEtcd 中移除，并以 ErrWatchStopped 结束剩余的订阅
+// 之后同一服务的 Subscribe 会建立新的 Watch
+func (sw *sharedWatch) stop(err error) {
+	sw.hub.hubMu.Lock()
+	sw.mu.Lock()
+	subs := sw.subs
+	sw.subs = nil
+	if sw.hub.watches[sw.name] == sw {
+		delete(sw.hub.watches, sw.name)
+	}
+	sw.mu.Unlock()
+	sw.hub.hubMu.Unlock()
+	sw.cancel()
+	if len(subs) == 0 {
+		return
+	}
+	if err == nil {
+		err = sw.hub.ctx.Err()
+	}
+	reason := ErrWatchStopped
+	if err != nil {
+		reason = fmt.Errorf("%w: %v", ErrWatchStopped, err)
+	}
+	for _, sub := range subs {
+		sub.finish(reason)
+	}
+}
+
+// remove 以 reason 结束订阅（主动取消时为 nil）并把它从共享 Watch 中移除，最后一个订阅者移除后停止 Watch
+func (sw *sharedWatch) remove(sub *Subscription, reason error) {
+	sub.finish(reason)
+	sw.hub.hubMu.Lock()
+	defer sw.hub.hubMu.Unlock()
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for i, s := range sw.subs {
+		if s == sub {
+			sw.subs = append(sw.subs[:i], sw.subs[i+1:]...)
+			break
+		}
+	}
+	if len(sw.subs) == 0 && sw.hub.watches[sw.name] == sw {
+		sw.cancel()
+		delete(sw.hub.watches, sw.name)
+	}
+}
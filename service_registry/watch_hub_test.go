@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscribeRejectsOverLimit(t *testing.T) {
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second,
+		WithMaxSubscribers(2, RejectNewSubscriber))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := discovery.Subscribe(ctx, "subscribed_service"); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+	}
+	if _, err := discovery.Subscribe(ctx, "subscribed_service"); !errors.Is(err, ErrTooManySubscribers) {
+		t.Fatalf("Expected ErrTooManySubscribers, got %v", err)
+	}
+	// 其他服务的订阅不受影响
+	if _, err := discovery.Subscribe(ctx, "other_service"); err != nil {
+		t.Fatalf("Failed to subscribe other service: %v", err)
+	}
+}
+
+func TestSubscribeEvictsOldest(t *testing.T) {
+	const name = "evicting_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second,
+		WithMaxSubscribers(2, EvictOldestSubscriber))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oldest, err := discovery.Subscribe(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	second, _ := discovery.Subscribe(ctx, name)
+	newest, err := discovery.Subscribe(ctx, name)
+	if err != nil {
+		t.Fatalf("Expected eviction instead of rejection, got %v", err)
+	}
+	select {
+	case <-oldest.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Oldest subscriber was not evicted")
+	}
+	if !errors.Is(oldest.Err(), ErrSubscriberEvicted) {
+		t.Errorf("Expected ErrSubscriberEvicted, got %v", oldest.Err())
+	}
+
+	// 剩下的订阅者仍能收到变更
+	time.Sleep(100 * time.Millisecond)
+	if _, err := discovery.client.Put(context.Background(), name+"/1", "localhost:9501"); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	defer discovery.client.Delete(context.Background(), name+"/1")
+	for _, sub := range []*Subscription{second, newest} {
+		select {
+		case change := <-sub.C:
+			if change.Record.Addr != "localhost:9501" {
+				t.Errorf("Unexpected change %+v", change)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Subscriber did not receive change")
+		}
+	}
+}
+
+// TestSubscribeEvictsIdlest 挤掉的是积压着未读变更的订阅，而不是仍在读取的最早订阅
+func TestSubscribeEvictsIdlest(t *testing.T) {
+	const name = "evicting_idle_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second,
+		WithMaxSubscribers(2, EvictOldestSubscriber))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	active, err := discovery.Subscribe(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	idle, err := discovery.Subscribe(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := discovery.client.Put(context.Background(), name+"/1", "localhost:9502"); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	defer discovery.client.Delete(context.Background(), name+"/1")
+	// active 读取了变更，idle 的变更还在缓冲中
+	select {
+	case <-active.C:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Subscriber did not receive change")
+	}
+
+	if _, err := discovery.Subscribe(ctx, name); err != nil {
+		t.Fatalf("Expected eviction instead of rejection, got %v", err)
+	}
+	select {
+	case <-idle.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Idle subscriber was not evicted")
+	}
+	if !errors.Is(idle.Err(), ErrSubscriberEvicted) {
+		t.Errorf("Expected ErrSubscriberEvicted, got %v", idle.Err())
+	}
+	if err := active.Err(); err != nil {
+		t.Errorf("Active subscriber was closed: %v", err)
+	}
+}
+
+// TestSubscribeSlowSubscriber 不读取的订阅者在缓冲满后被关闭，其他订阅者照常收到全部变更
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	const name = "slow_subscriber_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slow, err := discovery.Subscribe(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	fast, err := discovery.Subscribe(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	const changes = 2 * subscriptionBuffer
+	received := make(chan error, 1)
+	go func() {
+		for i := 0; i < changes; i++ {
+			select {
+			case change := <-fast.C:
+				if want := fmt.Sprintf("localhost:%d", 9600+i); change.Record.Addr != want {
+					received <- fmt.Errorf("change %d = %s, want %s", i, change.Record.Addr, want)
+					return
+				}
+			case <-time.After(2 * time.Second):
+				received <- fmt.Errorf("fast subscriber blocked after %d changes (err %v)", i, fast.Err())
+				return
+			}
+		}
+		received <- nil
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < changes; i++ {
+		if _, err := discovery.client.Put(context.Background(), name+"/1", fmt.Sprintf("localhost:%d", 9600+i)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	defer discovery.client.Delete(context.Background(), name+"/1")
+	if err := <-received; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Slow subscriber was not closed")
+	}
+	if !errors.Is(slow.Err(), ErrSubscriberTooSlow) {
+		t.Errorf("Expected ErrSubscriberTooSlow, got %v", slow.Err())
+	}
+}
+
+// TestSubscribeWatchStopped Watch 结束时订阅以 ErrWatchStopped 结束，之后的订阅建立新的 Watch
+func TestSubscribeWatchStopped(t *testing.T) {
+	const name = "stopped_watch_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	w := newFlakyWatcher(discovery.client)
+	discovery.watcher = w
+	sub, err := discovery.Subscribe(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	close(w.drop)
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription was not closed when its watch stopped")
+	}
+	if !errors.Is(sub.Err(), ErrWatchStopped) {
+		t.Errorf("Expected ErrWatchStopped, got %v", sub.Err())
+	}
+
+	close(w.resume)
+	again, err := discovery.Subscribe(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to resubscribe: %v", err)
+	}
+	if n := len(w.calls()); n != 2 {
+		t.Fatalf("Expected resubscribing to start a new watch, got %d watches", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := discovery.client.Put(context.Background(), name+"/1", "localhost:9503"); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	select {
+	case change := <-again.C:
+		if change.Record.Addr != "localhost:9503" {
+			t.Errorf("Unexpected change %+v", change)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Resubscribed subscriber did not receive change")
+	}
+
+	if _, err := discovery.client.Delete(context.Background(), name+"/1"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	// 关闭 DiscoveryEtcd 结束全部订阅
+	discovery.Close()
+	select {
+	case <-again.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription was not closed by DiscoveryEtcd.Close")
+	}
+	if !errors.Is(again.Err(), ErrWatchStopped) {
+		t.Errorf("Expected ErrWatchStopped after Close, got %v", again.Err())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrWatchCompacted 表示 Watch 需要恢复的 revision 已被压缩，中间的事件无法重放，只能重新读取全量状态
+var ErrWatchCompacted = errors.New("watch revision compacted")
+
+// watchResumeDelay 是 Watch 通道意外关闭后重新建立 Watch 前的等待时间，避免连接断开期间空转
+const watchResumeDelay = 100 * time.Millisecond
+
+// WatchService 监听服务的实例变化，每当实例集合发生变化时把当前选中的地址发送到返回的通道
+// 订阅后立即发送一次当前地址；服务没有可用实例时发送空字符串
+// 通道默认只保留最新的地址，WithWatchBuffer 可以保留最近的多个；缓冲满时丢弃最旧的地址，
+// 消费慢时中间的地址会丢失，但 Watch 不会阻塞，最后读到的总是当前选中的地址
+// Watch 中断和 revision 被压缩的处理见 watchPrefix
+// 取消 ctx 或调用 Close 后监听结束并关闭通道
+func (d *DiscoveryEtcd) WatchService(ctx context.Context, name string) (<-chan string, error) {
+	// Close 时同样结束监听
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.ctx, cancel)
+	resp, err := d.client.Get(ctx, serviceKeyPrefix(name), clientv3.WithPrefix())
+	if err != nil {
+		stop()
+		cancel()
+		return nil, err
+	}
+	ch := make(chan string, d.opts.watchBuffer)
+	go func() {
+		defer close(ch)
+		defer stop()
+		defer cancel()
+		state := make(map[string]ServiceInstance)
+		load := func(resp *clientv3.GetResponse) {
+			clear(state)
+			for _, kv := range resp.Kvs {
+				if rec, err := d.opts.decode(kv.Value); err == nil {
+					key := string(kv.Key)
+					state[key] = ServiceInstance{ServiceRecord: rec, Key: key, CreateRevision: kv.CreateRevision}
+				}
+			}
+			d.sendLatest(ch, name, state)
+		}
+		load(resp)
+		d.watchPrefix(ctx, name, resp.Header.Revision, func(events []*clientv3.Event) {
+			if d.applyChanges(state, events) {
+				d.sendLatest(ch, name, state)
+			}
+		}, load)
+	}()
+	return ch, nil
+}
+
+// watchPrefix 监听服务名前缀下 rev 之后的变更，把每批事件交给 apply，直到 ctx 结束
+// Watch 通道关闭或出错时从最后观察到的 revision 之后重新建立 Watch，断开期间的事件会被重放；
+// 需要的 revision 已被压缩时中间的事件无法重放，记录日志后重新读取全量状态交给 resync，
+// 再从读取时的 revision 之后继续监听。返回 ctx.Err() 或重新读取失败的错误
+func (d *DiscoveryEtcd) watchPrefix(ctx context.Context, name string, rev int64, apply func([]*clientv3.Event), resync func(*clientv3.GetResponse)) error {
+	for {
+		var compacted error
+		watchCtx, cancel := context.WithCancel(ctx)
+		for resp := range d.watcher.Watch(watchCtx, serviceKeyPrefix(name), clientv3.WithPrefix(), clientv3.WithRev(rev+1)) {
+			if resp.CompactRevision != 0 {
+				compacted = fmt.Errorf("%w: need revision %d, compacted at %d", ErrWatchCompacted, rev+1, resp.CompactRevision)
+				break
+			}
+			if err := resp.Err(); err != nil {
+				d.opts.logger.Warnf("watch for %s failed at revision %d: %v", name, rev, err)
+				break
+			}
+			if len(resp.Events) == 0 {
+				continue
+			}
+			// rev 是最后一个已应用的事件，恢复时从它之后开始
+			rev = resp.Events[len(resp.Events)-1].Kv.ModRevision
+			apply(resp.Events)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if compacted != nil {
+			d.opts.logger.Warnf("watch for %s lost events, resyncing: %v", name, compacted)
+			resp, err := d.fetch(ctx, name)
+			if err != nil {
+				return err
+			}
+			resync(resp)
+			rev = resp.Header.Revision
+			continue
+		}
+		d.opts.logger.Warnf("watch for %s interrupted, resuming from revision %d", name, rev+1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(watchResumeDelay):
+		}
+	}
+}
+
+// applyChanges 把 Watch 事件应用到 state，返回 WatchService 选中的地址是否可能变化
+func (d *DiscoveryEtcd) applyChanges(state map[string]ServiceInstance, events []*clientv3.Event) bool {
+	changed := false
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		if ev.Type == clientv3.EventTypeDelete {
+			if _, ok := state[key]; ok {
+				delete(state, key)
+				changed = true
+			}
+			continue
+		}
+		rec, err := d.opts.decode(ev.Kv.Value)
+		if err != nil {
+			continue
+		}
+		// 开启 WithDrainAware 时实例进入排空同样会改变选中的地址
+		if old, ok := state[key]; !ok || old.Addr != rec.Addr || (d.drainAware && old.Draining() != rec.Draining()) {
+			changed = true
+		}
+		state[key] = ServiceInstance{ServiceRecord: rec, Key: key, CreateRevision: ev.Kv.CreateRevision}
+	}
+	return changed
+}
+
+// sendLatest 把当前选中的地址放入通道，缓冲已满时先丢弃最旧的地址
+func (d *DiscoveryEtcd) sendLatest(ch chan string, name string, state map[string]ServiceInstance) {
+	instances := make([]ServiceInstance, 0, len(state))
+	for _, inst := range state {
+		instances = append(instances, inst)
+	}
+	addr := ""
+	if instances = d.serving(instances); len(instances) > 0 {
+		// 与 instances 的结果保持一致，按 key 排序后再交给负载均衡器
+		sort.Slice(instances, func(i, j int) bool { return instances[i].Key < instances[j].Key })
+		addr = d.pick(name, instances).Addr
+	}
+	sendDropOldest(ch, addr)
+}
+
+// sendDropOldest 把地址放入通道，缓冲已满时先丢弃最旧的地址，DiscoveryEtcd 和 MemoryDiscovery 的 WatchService 共用
+// 调用方是通道唯一的发送方，腾出一个位置后发送不会阻塞；消费方同时读走一个时不必再丢弃
+func sendDropOldest(ch chan string, addr string) {
+	if len(ch) == cap(ch) {
+		select {
+		case <-ch:
+		default:
+		}
+	}
+	ch <- addr
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func receiveAddr(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case addr, ok := <-ch:
+		if !ok {
+			t.Fatalf("Watch channel closed unexpectedly")
+		}
+		return addr
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for address")
+	}
+	return ""
+}
+
+func TestWatchService(t *testing.T) {
+	const name = "watched_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	ch, err := discovery.WatchService(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "" {
+		t.Errorf("Expected empty initial address, got %q", addr)
+	}
+
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9701"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "localhost:9701" {
+		t.Errorf("Expected registered address, got %q", addr)
+	}
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "" {
+		t.Errorf("Expected empty address after deregister, got %q", addr)
+	}
+
+	if err := discovery.Close(); err != nil {
+		t.Fatalf("Failed to close discovery: %v", err)
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("Expected channel to be closed after Close")
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Channel not closed after Close")
+	}
+}
+
+func TestWatchServiceContextCancel(t *testing.T) {
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := discovery.WatchService(ctx, "cancelled_watch_service")
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	receiveAddr(t, ch)
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("Expected channel to be closed after ctx cancel")
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Channel not closed after ctx cancel")
+	}
+}
+
+// flakyWatcher 包装真实的 Watcher：关闭 drop 后第一个 Watch 通道被切断，
+// 之后的 Watch 要等 resume 关闭才开始；compact 为 true 时第二个 Watch 返回压缩错误
+type flakyWatcher struct {
+	clientv3.Watcher
+	drop    chan struct{}
+	resume  chan struct{}
+	compact bool
+
+	mu   sync.Mutex
+	revs []int64 // 每次 Watch 的起始 revision
+}
+
+func newFlakyWatcher(w clientv3.Watcher) *flakyWatcher {
+	return &flakyWatcher{Watcher: w, drop: make(chan struct{}), resume: make(chan struct{})}
+}
+
+func (w *flakyWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	w.mu.Lock()
+	w.revs = append(w.revs, clientv3.OpGet(key, opts...).Rev())
+	n := len(w.revs)
+	w.mu.Unlock()
+	out := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(out)
+		var drop <-chan struct{}
+		if n == 1 {
+			drop = w.drop
+		} else {
+			select {
+			case <-w.resume:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if n == 2 && w.compact {
+			select {
+			case out <- clientv3.WatchResponse{CompactRevision: w.revs[1]}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		inner := w.Watcher.Watch(ctx, key, opts...)
+		for {
+			select {
+			case resp, ok := <-inner:
+				if !ok {
+					return
+				}
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			case <-drop:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (w *flakyWatcher) calls() []int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]int64(nil), w.revs...)
+}
+
+// watchThroughGap 切断 WatchService 的 Watch，在重新建立之前把实例从 9711 换成 9712，
+// 恢复后等待通道给出新地址
+func watchThroughGap(t *testing.T, name string, w *flakyWatcher, logger *recordingLogger) {
+	t.Helper()
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	w.Watcher = discovery.client
+	discovery.watcher = w
+
+	oldRegistry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer oldRegistry.Close()
+	defer oldRegistry.DeRegistry(context.Background())
+	if _, err := oldRegistry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9711"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	ch, err := discovery.WatchService(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	if addr := receiveAddr(t, ch); addr != "localhost:9711" {
+		t.Fatalf("Expected initial address, got %q", addr)
+	}
+
+	close(w.drop)
+	deadline := time.Now().Add(3 * time.Second)
+	for len(w.calls()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Watch was not re-established after the channel closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 断开期间发生的变化
+	newRegistry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer newRegistry.Close()
+	defer newRegistry.DeRegistry(context.Background())
+	if _, err := newRegistry.Registry(context.Background(), &OrderService{name: name, addr: "localhost:9712"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := oldRegistry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	close(w.resume)
+	for {
+		if addr := receiveAddr(t, ch); addr == "localhost:9712" {
+			return
+		}
+	}
+}
+
+func TestWatchServiceResume(t *testing.T) {
+	w := newFlakyWatcher(nil)
+	logger := &recordingLogger{}
+	watchThroughGap(t, "resumed_watch_service", w, logger)
+	revs := w.calls()
+	if len(revs) != 2 {
+		t.Fatalf("Expected one resumed watch, got watches starting at %v", revs)
+	}
+	// 断开前没有新事件，恢复时应从同一个 revision 开始，而不是从当前 revision
+	if revs[1] != revs[0] {
+		t.Errorf("Watch resumed at revision %d, want %d", revs[1], revs[0])
+	}
+	if !logger.contains("WARN watch for resumed_watch_service interrupted") {
+		t.Errorf("Expected interruption to be logged, got %v", logger.lines)
+	}
+}
+
+func TestWatchServiceCompacted(t *testing.T) {
+	w := newFlakyWatcher(nil)
+	w.compact = true
+	logger := &recordingLogger{}
+	watchThroughGap(t, "compacted_watch_service", w, logger)
+	if revs := w.calls(); len(revs) != 3 {
+		t.Fatalf("Expected a fresh watch after resync, got watches starting at %v", revs)
+	}
+	if !logger.contains("WARN watch for compacted_watch_service lost events") {
+		t.Errorf("Expected compaction to be logged, got %v", logger.lines)
+	}
+}
+
+// TestWatchServiceSlowConsumer 消费方不读取时 Watch 继续处理事件，缓冲里保留最近的地址，ctx 取消后通道及时关闭
+func TestWatchServiceSlowConsumer(t *testing.T) {
+	const name = "slow_consumer_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second, WithWatchBuffer(3), WithPreferNewest(1))
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	defer discovery.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := discovery.WatchService(ctx, name)
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+
+	registry, err := NewEtcdRegistry([]string{"localhost:2379"}, 5*time.Second, LeaseTTL)
+	if err != nil {
+		t.Fatalf("Failed to create etcd registry: %v", err)
+	}
+	defer registry.Close()
+	defer registry.DeRegistry(context.Background())
+	// 每次注册都让选中的地址变成最新的实例，共 9 个地址，远超缓冲
+	for i := 1; i <= 8; i++ {
+		if _, err := registry.Registry(context.Background(), &OrderService{name: name, addr: fmt.Sprintf("localhost:980%d", i)}); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+	}
+	// 缓冲中依次是最近的地址，最后一个是当前选中的地址
+	var got []string
+	for len(got) == 0 || got[len(got)-1] != "localhost:9808" {
+		got = append(got, receiveAddr(t, ch))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("Expected addresses in registration order, got %v", got)
+		}
+	}
+	if len(got) == 9 {
+		t.Logf("Consumer kept up with every change: %v", got)
+	}
+
+	// 再次积压后取消，发送方没有阻塞，通道随即关闭
+	if err := registry.DeRegistry(context.Background()); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := len(ch); n > 3 {
+		t.Fatalf("Buffer holds %d addresses, want at most 3", n)
+	}
+	cancel()
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Watch channel not closed after cancel, watch goroutine is stuck")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestWatchServiceBatched(t *testing.T) {
+	const name = "batched_watch_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	cli := discovery.client
+	defer cli.Delete(context.Background(), name, clientv3.WithPrefix())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mu      sync.Mutex
+		batches [][]ServiceChange
+	)
+	const maxBatch = 4
+	err = discovery.WatchServiceBatched(ctx, name, maxBatch, 300*time.Millisecond, func(changes []ServiceChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, changes)
+	})
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+
+	// 6 次新增加 1 次删除，共 7 条变更
+	for i := 0; i < 6; i++ {
+		key := fmt.Sprintf("%s/%d", name, i)
+		if _, err := cli.Put(context.Background(), key, fmt.Sprintf("localhost:%d", 9200+i)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if _, err := cli.Delete(context.Background(), name+"/0"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	time.Sleep(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	state := make(map[string]ServiceRecord)
+	total := 0
+	for _, b := range batches {
+		if len(b) > maxBatch {
+			t.Errorf("Batch of %d changes exceeds maxBatch %d", len(b), maxBatch)
+		}
+		total += len(b)
+		ApplyChanges(state, b)
+	}
+	if total != 7 {
+		t.Errorf("Expected 7 changes, got %d", total)
+	}
+	if len(batches) > 3 {
+		t.Errorf("Expected changes to be batched into few callbacks, got %d", len(batches))
+	}
+	if len(state) != 5 || state[name+"/5"].Addr != "localhost:9205" {
+		t.Errorf("Unexpected final state %v", state)
+	}
+}
+
+// TestWatchServiceBatchedStopsOnClose Close 结束批量监听，结束前交付尚未到期的批次
+func TestWatchServiceBatchedStopsOnClose(t *testing.T) {
+	const name = "batched_close_service"
+	discovery, err := NewEtcdDiscovery([]string{"localhost:2379"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create etcd discovery: %v", err)
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer cli.Close()
+	defer cli.Delete(context.Background(), name, clientv3.WithPrefix())
+	// Watch 走另一个客户端，关闭 discovery 的客户端不会顺带切断它，只有 Close 取消 ctx 才能结束监听
+	discovery.watcher = cli
+
+	delivered := make(chan []ServiceChange, 1)
+	// 调用方的 ctx 一直有效，maxDelay 远大于测试时间，只有监听结束时才会交付
+	err = discovery.WatchServiceBatched(context.Background(), name, 100, time.Minute, func(changes []ServiceChange) {
+		delivered <- changes
+	})
+	if err != nil {
+		t.Fatalf("Failed to watch service: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cli.Put(context.Background(), name+"/1", "localhost:9251"); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	discovery.Close()
+	select {
+	case changes := <-delivered:
+		if len(changes) != 1 || changes[0].Record.Addr != "localhost:9251" {
+			t.Errorf("Unexpected final batch %+v", changes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Batched watch still running after Close")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SliceMismatchError 描述两个切片作为多重集合比较时的差异
+type SliceMismatchError struct {
+	Missing []interface{} // 在 a 中但不在 b 中的元素
+	Extra   []interface{} // 在 b 中但不在 a 中的元素
+}
+
+func (e *SliceMismatchError) Error() string {
+	return fmt.Sprintf("slices differ: missing %v, extra %v", e.Missing, e.Extra)
+}
+
+// SlicesEqualUnordered 忽略顺序比较两个同元素类型的切片，元素按 reflect.DeepEqual 计数比较
+// 不相等时返回 false 和 *SliceMismatchError，其中列出缺少和多出的元素；
+// 参数不是切片或元素类型不同时返回 false 和普通错误
+func SlicesEqualUnordered(a, b interface{}) (bool, error) {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() != reflect.Slice || vb.Kind() != reflect.Slice {
+		return false, fmt.Errorf("expected two slices, got %T and %T", a, b)
+	}
+	if va.Type().Elem() != vb.Type().Elem() {
+		return false, fmt.Errorf("element type mismatch: %s vs %s", va.Type().Elem(), vb.Type().Elem())
+	}
+	// 为 b 的每个元素记录是否已经和 a 中的某个元素配对
+	matched := make([]bool, vb.Len())
+	var mismatch SliceMismatchError
+	for i := 0; i < va.Len(); i++ {
+		x := va.Index(i).Interface()
+		found := false
+		for j := 0; j < vb.Len(); j++ {
+			if !matched[j] && reflect.DeepEqual(x, vb.Index(j).Interface()) {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			mismatch.Missing = append(mismatch.Missing, x)
+		}
+	}
+	for j, ok := range matched {
+		if !ok {
+			mismatch.Extra = append(mismatch.Extra, vb.Index(j).Interface())
+		}
+	}
+	if len(mismatch.Missing) > 0 || len(mismatch.Extra) > 0 {
+		return false, &mismatch
+	}
+	return true, nil
+}
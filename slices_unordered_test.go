@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSlicesEqualUnordered(t *testing.T) {
+	a := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.2:80"}
+	b := []string{"10.0.0.2:80", "10.0.0.1:80", "10.0.0.2:80"}
+	if ok, err := SlicesEqualUnordered(a, b); !ok || err != nil {
+		t.Errorf("Expected equal, got %v, %v", ok, err)
+	}
+
+	c := []string{"10.0.0.2:80", "10.0.0.1:80", "10.0.0.3:80"}
+	ok, err := SlicesEqualUnordered(a, c)
+	var mismatch *SliceMismatchError
+	if ok || !errors.As(err, &mismatch) {
+		t.Fatalf("Expected mismatch, got %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(mismatch.Missing, []interface{}{"10.0.0.2:80"}) || !reflect.DeepEqual(mismatch.Extra, []interface{}{"10.0.0.3:80"}) {
+		t.Errorf("Unexpected mismatch %v", mismatch)
+	}
+}
+
+func TestSlicesEqualUnorderedInvalid(t *testing.T) {
+	if _, err := SlicesEqualUnordered([]string{"a"}, []int{1}); err == nil {
+		t.Errorf("Expected error for mismatched element types")
+	}
+	if _, err := SlicesEqualUnordered("a", []string{"a"}); err == nil {
+		t.Errorf("Expected error for non-slice input")
+	}
+}
@@ -1,17 +1,139 @@
 package main
 
-import "sync/atomic"
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
 
+// spinCheckInterval 是两次检查 ctx 之间的自旋次数，避免每次自旋都读取 Done 通道
+const spinCheckInterval = 1024
+
+// 退避参数的默认值，对应 SpinLock 中为零的字段
+const (
+	defaultSpinIterations  = 64
+	defaultYieldIterations = 64
+	defaultMinSleep        = time.Microsecond
+	defaultMaxSleep        = time.Millisecond
+)
+
+// SpinLock 是自适应退避的自旋锁：
+// 先纯自旋 SpinIterations 次，再调用 runtime.Gosched 让出 YieldIterations 次，
+// 之后改为 time.Sleep，睡眠时间从 MinSleep 开始翻倍，最长 MaxSleep
+// 字段为零时使用默认值，零值的 SpinLock 可以直接使用
 type SpinLock struct {
 	flag int32
+	// LockWithStats 累计的失败 CAS 次数和等待时间，见 Stats
+	attempts  atomic.Uint64
+	waitNanos atomic.Uint64
+
+	SpinIterations  int
+	YieldIterations int
+	MinSleep        time.Duration
+	MaxSleep        time.Duration
 }
 
 func (sl *SpinLock) Lock() {
+	sl.LockContext(context.Background())
+}
+
+// LockContext 自旋直到获得锁，ctx 在获得锁之前结束时返回 ctx.Err()
+func (sl *SpinLock) LockContext(ctx context.Context) error {
+	b := newSpinBackoff(sl.SpinIterations, sl.YieldIterations, sl.MinSleep, sl.MaxSleep)
 	for !atomic.CompareAndSwapInt32(&sl.flag, 0, 1) {
-		// 自旋等待
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockWithStats 与 Lock 相同，同时把失败的 CAS 次数和获得锁之前的等待时间累加到 Stats
+// 第一次 CAS 就成功时不读取时钟、不写计数器；Lock 和 LockContext 不统计，快速路径不受影响
+func (sl *SpinLock) LockWithStats() {
+	if atomic.CompareAndSwapInt32(&sl.flag, 0, 1) {
+		return
 	}
+	start := time.Now()
+	failed := uint64(1)
+	b := newSpinBackoff(sl.SpinIterations, sl.YieldIterations, sl.MinSleep, sl.MaxSleep)
+	for {
+		b.wait(context.Background())
+		if atomic.CompareAndSwapInt32(&sl.flag, 0, 1) {
+			break
+		}
+		failed++
+	}
+	sl.attempts.Add(failed)
+	sl.waitNanos.Add(uint64(time.Since(start)))
+}
+
+// Stats 返回 LockWithStats 累计的失败 CAS 次数和等待的总纳秒数，两者持续增长时说明锁竞争激烈
+func (sl *SpinLock) Stats() (attempts, waitNanos uint64) {
+	return sl.attempts.Load(), sl.waitNanos.Load()
+}
+
+// TryLock 只尝试一次获取锁，不自旋，返回是否成功
+func (sl *SpinLock) TryLock() bool {
+	return atomic.CompareAndSwapInt32(&sl.flag, 0, 1)
 }
 
 func (sl *SpinLock) Unlock() {
 	atomic.StoreInt32(&sl.flag, 0)
 }
+
+// spinBackoff 是 SpinLock 的自适应退避，每次 CAS 失败后调用一次 wait
+// SpinLock 和 Gate 共用，参数为零时使用默认值
+type spinBackoff struct {
+	spin, yield     int
+	sleep, maxSleep time.Duration
+	attempts        int
+}
+
+func newSpinBackoff(spin, yield int, minSleep, maxSleep time.Duration) spinBackoff {
+	if spin <= 0 {
+		spin = defaultSpinIterations
+	}
+	if yield <= 0 {
+		yield = defaultYieldIterations
+	}
+	if minSleep <= 0 {
+		minSleep = defaultMinSleep
+	}
+	if maxSleep <= 0 {
+		maxSleep = defaultMaxSleep
+	}
+	return spinBackoff{spin: spin, yield: yield, sleep: minSleep, maxSleep: maxSleep}
+}
+
+// wait 按失败次数自旋、让出或睡眠一次，ctx 结束时返回 ctx.Err()
+func (b *spinBackoff) wait(ctx context.Context) error {
+	b.attempts++
+	switch {
+	case b.attempts <= b.spin:
+		// 自旋等待
+	case b.attempts <= b.spin+b.yield:
+		runtime.Gosched()
+	default:
+		// 进入睡眠阶段后每次都检查 ctx，睡眠本身远比检查昂贵
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		time.Sleep(b.sleep)
+		if b.sleep *= 2; b.sleep > b.maxSleep {
+			b.sleep = b.maxSleep
+		}
+		return nil
+	}
+	if b.attempts%spinCheckInterval == 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// naiveSpinLock 是没有退避的自旋锁，作为基准对照
+type naiveSpinLock struct {
+	flag int32
+}
+
+func (l *naiveSpinLock) Lock() {
+	for !atomic.CompareAndSwapInt32(&l.flag, 0, 1) {
+	}
+}
+
+func (l *naiveSpinLock) Unlock() {
+	atomic.StoreInt32(&l.flag, 0)
+}
+
+// cpuTime 返回进程已消耗的用户态和内核态 CPU 时间
+func cpuTime(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatalf("getrusage: %v", err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkContended 让 16 个 goroutine 争用同一把锁，临界区内做少量工作
+// 除了 ns/op 还报告 cpu-ns/op：等待者烧掉的 CPU 时间会体现在这里
+func benchmarkContended(b *testing.B, lock sync.Locker) {
+	const goroutines = 16
+	var counter int
+	per := b.N/goroutines + 1
+	b.ResetTimer()
+	start := cpuTime(b)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				lock.Lock()
+				for j := 0; j < 100; j++ {
+					counter++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+	b.ReportMetric(float64(cpuTime(b)-start)/float64(b.N), "cpu-ns/op")
+}
+
+func BenchmarkSpinLockNaiveContended(b *testing.B) {
+	benchmarkContended(b, &naiveSpinLock{})
+}
+
+func BenchmarkSpinLockBackoffContended(b *testing.B) {
+	benchmarkContended(b, &SpinLock{})
+}
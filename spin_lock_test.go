@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpinLockContextCancel(t *testing.T) {
+	var sl SpinLock
+	sl.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sl.LockContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("LockContext returned %v after the deadline", elapsed)
+	}
+	sl.Unlock()
+	if err := sl.LockContext(context.Background()); err != nil {
+		t.Fatalf("Expected lock to be acquired after Unlock, got %v", err)
+	}
+}
+
+func TestSpinLockTryLock(t *testing.T) {
+	var sl SpinLock
+	if !sl.TryLock() {
+		t.Fatalf("Expected TryLock on a free lock to succeed")
+	}
+	if sl.TryLock() {
+		t.Errorf("Expected TryLock on a held lock to fail")
+	}
+	sl.Unlock()
+
+	sl.Lock()
+	if sl.TryLock() {
+		t.Errorf("Expected TryLock after Lock to fail")
+	}
+	sl.Unlock()
+}
+
+func TestSpinLockTryLockContended(t *testing.T) {
+	var sl SpinLock
+	const goroutines = 8
+	var wins int32
+	start := make(chan struct{})
+	done := make(chan bool, goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			<-start
+			done <- sl.TryLock()
+		}()
+	}
+	close(start)
+	for i := 0; i < goroutines; i++ {
+		if <-done {
+			wins++
+		}
+	}
+	if wins != 1 {
+		t.Errorf("Expected exactly one TryLock to succeed, got %d", wins)
+	}
+}
+
+func TestSpinLockBackoffMutualExclusion(t *testing.T) {
+	sl := SpinLock{SpinIterations: 4, YieldIterations: 4, MinSleep: time.Microsecond, MaxSleep: 50 * time.Microsecond}
+	const goroutines, rounds = 16, 1000
+	counter := 0
+	done := make(chan struct{})
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < rounds; j++ {
+				sl.Lock()
+				counter++
+				sl.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < goroutines; i++ {
+		<-done
+	}
+	if counter != goroutines*rounds {
+		t.Errorf("counter = %d, want %d", counter, goroutines*rounds)
+	}
+}
+
+func TestSpinLockStats(t *testing.T) {
+	var sl SpinLock
+	sl.LockWithStats()
+	sl.Unlock()
+	if attempts, waitNanos := sl.Stats(); attempts != 0 || waitNanos != 0 {
+		t.Errorf("Expected no stats for an uncontended lock, got %d attempts, %dns", attempts, waitNanos)
+	}
+
+	// 持有锁一段时间，让另一个 goroutine 必须等待
+	sl.Lock()
+	done := make(chan struct{})
+	go func() {
+		sl.LockWithStats()
+		sl.Unlock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	sl.Unlock()
+	<-done
+	attempts, waitNanos := sl.Stats()
+	if attempts == 0 {
+		t.Errorf("Expected failed attempts under contention")
+	}
+	if time.Duration(waitNanos) < 10*time.Millisecond {
+		t.Errorf("Expected wait time to cover the hold period, got %v", time.Duration(waitNanos))
+	}
+
+	// 多个 goroutine 竞争时计数累加，Lock 本身不统计
+	var counter int
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				sl.LockWithStats()
+				counter++
+				sl.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 8000 {
+		t.Errorf("Expected 8000 increments, got %d", counter)
+	}
+	if after, _ := sl.Stats(); after < attempts {
+		t.Errorf("Expected cumulative attempts, got %d after %d", after, attempts)
+	}
+	before, _ := sl.Stats()
+	sl.Lock()
+	sl.Unlock()
+	if after, _ := sl.Stats(); after != before {
+		t.Errorf("Lock changed the stats from %d to %d", before, after)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// StructPool 是某个结构体类型的对象池，Get 返回该类型的指针，Put 时通过反射把导出字段重置为零值，
+// 复用的对象不会带着上一次的数据；反射无法设置未导出字段，它们保持放回时的值
+// 适合在热路径上反复创建、用完即弃的结构体，例如发现端每次查询构造的实例记录
+type StructPool struct {
+	typ  reflect.Type
+	pool sync.Pool
+}
+
+// NewStructPool 创建 sample 所属结构体类型的对象池，sample 可以是结构体或结构体指针
+func NewStructPool(sample interface{}) (*StructPool, error) {
+	typ := reflect.TypeOf(sample)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("struct pool: expected struct, got %T", sample)
+	}
+	p := &StructPool{typ: typ}
+	p.pool.New = func() interface{} {
+		return reflect.New(typ).Interface()
+	}
+	return p, nil
+}
+
+// Get 从池中取出一个导出字段均为零值的对象，类型为结构体指针，池为空时用 reflect.New 创建
+func (p *StructPool) Get() interface{} {
+	return p.pool.Get()
+}
+
+// Put 把导出字段重置为零值后放回池中，x 必须是 Get 返回的同类型非 nil 指针
+// 放回后调用方不能再使用 x
+func (p *StructPool) Put(x interface{}) error {
+	rv := reflect.ValueOf(x)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Type().Elem() != p.typ {
+		return fmt.Errorf("struct pool: expected non-nil *%s, got %T", p.typ, x)
+	}
+	resetExported(rv.Elem())
+	p.pool.Put(x)
+	return nil
+}
+
+// resetExported 把结构体的导出字段设置为各自类型的零值
+func resetExported(rv reflect.Value) {
+	for i := 0; i < rv.NumField(); i++ {
+		if f := rv.Field(i); f.CanSet() {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type pooledInstance struct {
+	Key      string
+	Addr     string
+	Metadata map[string]string
+	Tags     []string
+	Expires  time.Time
+	Next     *pooledInstance
+	hits     int
+}
+
+func TestStructPoolReset(t *testing.T) {
+	pool, err := NewStructPool(pooledInstance{})
+	if err != nil {
+		t.Fatalf("NewStructPool failed: %v", err)
+	}
+	inst := pool.Get().(*pooledInstance)
+	*inst = pooledInstance{
+		Key:      "order/1",
+		Addr:     "localhost:9001",
+		Metadata: map[string]string{"zone": "a"},
+		Tags:     []string{"canary"},
+		Expires:  time.Now(),
+		Next:     &pooledInstance{},
+		hits:     3,
+	}
+	if err := pool.Put(inst); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if inst.Key != "" || inst.Addr != "" || inst.Metadata != nil || inst.Tags != nil || !inst.Expires.IsZero() || inst.Next != nil {
+		t.Errorf("Expected exported fields to be zeroed on Put, got %+v", *inst)
+	}
+	if inst.hits != 3 {
+		t.Errorf("Expected unexported field to be left alone, got %d", inst.hits)
+	}
+
+	if _, err := NewStructPool(42); err == nil {
+		t.Errorf("Expected error for a non-struct sample")
+	}
+	if err := pool.Put(pooledInstance{}); err == nil {
+		t.Errorf("Expected error when putting a struct value")
+	}
+	if err := pool.Put((*pooledInstance)(nil)); err == nil {
+		t.Errorf("Expected error when putting a nil pointer")
+	}
+	if err := pool.Put(&struct{ Key string }{}); err == nil {
+		t.Errorf("Expected error when putting another type")
+	}
+}
+
+func TestStructPoolReuse(t *testing.T) {
+	pool, err := NewStructPool(&pooledInstance{})
+	if err != nil {
+		t.Fatalf("NewStructPool failed: %v", err)
+	}
+	first := pool.Get().(*pooledInstance)
+	first.Addr = "localhost:9002"
+	pool.Put(first)
+	second := pool.Get().(*pooledInstance)
+	if second != first {
+		t.Skip("sync.Pool dropped the object, reuse cannot be observed")
+	}
+	if second.Addr != "" {
+		t.Errorf("Reused object carries stale Addr %q", second.Addr)
+	}
+	pool.Put(second)
+
+	// 取出、填充、放回的循环不应每次都分配新对象
+	allocs := testing.AllocsPerRun(100, func() {
+		inst := pool.Get().(*pooledInstance)
+		inst.Key = "order/2"
+		pool.Put(inst)
+	})
+	if allocs >= 1 {
+		t.Errorf("Expected Get/Put to reuse objects, got %.1f allocations per round", allocs)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError 是一个字段违反校验规则的错误
+type FieldError struct {
+	Field   string // 字段路径，例如 Age 或 [2].Age
+	Rule    string // 违反的规则，例如 min=1
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Validate 按导出字段上的 `validate:"..."` 标签校验结构体（或结构体指针），返回全部违反规则的字段
+// 支持的规则，多个规则用逗号分隔：
+//
+//	required  字段不能是零值
+//	nonempty  字符串去掉首尾空白后不能为空
+//	hostport  字符串是 host:port，端口在 1 到 65535 之间
+//	min=N     数值不小于 N；字符串、切片、map 的长度不小于 N
+//	max=N     数值不大于 N；字符串、切片、map 的长度不大于 N
+//
+// Validate 只检查顶层字段，嵌套的结构体见 ValidateStruct
+func Validate(v interface{}) []error {
+	rv, err := structValue(v)
+	if err != nil {
+		return []error{err}
+	}
+	return validateFields(rv, "", false)
+}
+
+// ValidateStruct 和 Validate 使用相同的规则，同时递归校验结构体字段、结构体指针字段以及结构体切片中的每个元素，
+// 错误的字段路径形如 Etcd.Endpoint 或 Services[1].Addr。全部违反规则的字段合并成一个错误返回，没有错误时返回 nil
+// 可以在调用 NewEtcdRegistry 之前校验配置结构体
+func ValidateStruct(v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	return errors.Join(validateFields(rv, "", true)...)
+}
+
+// structValue 解开指针，要求 v 是结构体
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("validate: expected struct, got %T", v)
+	}
+	return rv, nil
+}
+
+// validateFields 校验结构体的导出字段，字段路径加上 prefix；nested 为 true 时递归进入结构体和结构体切片
+func validateFields(rv reflect.Value, prefix string, nested bool) []error {
+	var errs []error
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := prefix + field.Name
+		if tag, ok := field.Tag.Lookup("validate"); ok {
+			for _, rule := range strings.Split(tag, ",") {
+				if rule = strings.TrimSpace(rule); rule == "" {
+					continue
+				}
+				if msg := checkRule(rv.Field(i), rule); msg != "" {
+					errs = append(errs, &FieldError{Field: path, Rule: rule, Message: msg})
+				}
+			}
+		}
+		if nested {
+			errs = append(errs, validateNested(rv.Field(i), path)...)
+		}
+	}
+	return errs
+}
+
+// validateNested 递归校验结构体、非 nil 的结构体指针，以及切片和数组中的结构体元素
+func validateNested(v reflect.Value, path string) []error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateFields(v, path+".", true)
+	case reflect.Slice, reflect.Array:
+		var errs []error
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, validateNested(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	}
+	return nil
+}
+
+// ValidateSlice 对切片中的每个结构体元素执行 Validate，错误的字段路径带上元素下标，例如 [2].Age
+// 元素不是结构体（或结构体指针）时返回单个错误
+func ValidateSlice(slice interface{}) []error {
+	rv := reflect.ValueOf(slice)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []error{fmt.Errorf("validate: expected slice, got %T", slice)}
+	}
+	elemType := rv.Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return []error{fmt.Errorf("validate: slice elements must be structs, got %s", rv.Type().Elem())}
+	}
+	var errs []error
+	for i := 0; i < rv.Len(); i++ {
+		for _, err := range Validate(rv.Index(i).Interface()) {
+			if fe, ok := err.(*FieldError); ok {
+				fe.Field = fmt.Sprintf("[%d].%s", i, fe.Field)
+			} else {
+				err = fmt.Errorf("[%d]: %w", i, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// checkRule 检查单个规则，通过时返回空字符串，否则返回错误描述
+func checkRule(v reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if v.IsZero() {
+			return "is required"
+		}
+	case "nonempty":
+		if v.Kind() != reflect.String {
+			return fmt.Sprintf("rule %q not supported for %s", rule, v.Type())
+		}
+		if strings.TrimSpace(v.String()) == "" {
+			return "must not be empty"
+		}
+	case "hostport":
+		if v.Kind() != reflect.String {
+			return fmt.Sprintf("rule %q not supported for %s", rule, v.Type())
+		}
+		if !isHostPort(v.String()) {
+			return fmt.Sprintf("%q is not a valid host:port", v.String())
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Sprintf("invalid rule %q", rule)
+		}
+		n, isLen, ok := measure(v)
+		if !ok {
+			return fmt.Sprintf("rule %q not supported for %s", rule, v.Type())
+		}
+		what := "value"
+		if isLen {
+			what = "length"
+		}
+		if name == "min" && n < limit {
+			return fmt.Sprintf("%s must be >= %s", what, arg)
+		}
+		if name == "max" && n > limit {
+			return fmt.Sprintf("%s must be <= %s", what, arg)
+		}
+	default:
+		return fmt.Sprintf("unknown rule %q", rule)
+	}
+	return ""
+}
+
+// isHostPort 判断 s 是否是 host:port，host 不能为空，端口是 1 到 65535 之间的数字
+func isHostPort(s string) bool {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// measure 返回 min/max 比较用的数值：数值类型返回值本身，字符串和容器返回长度
+func measure(v reflect.Value) (n float64, isLen bool, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validatedPerson struct {
+	Name string `validate:"required"`
+	Age  int    `validate:"min=1,max=150"`
+}
+
+func TestValidate(t *testing.T) {
+	if errs := Validate(validatedPerson{Name: "Alice", Age: 30}); len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+	errs := Validate(&validatedPerson{Age: 200})
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	if fe, ok := errs[0].(*FieldError); !ok || fe.Field != "Name" || fe.Rule != "required" {
+		t.Errorf("Unexpected first error %v", errs[0])
+	}
+	if fe, ok := errs[1].(*FieldError); !ok || fe.Field != "Age" || fe.Rule != "max=150" {
+		t.Errorf("Unexpected second error %v", errs[1])
+	}
+}
+
+func TestValidateSlice(t *testing.T) {
+	people := []validatedPerson{
+		{Name: "Alice", Age: 30},
+		{Name: "Bob", Age: 0},
+		{Name: "Carol", Age: 40},
+	}
+	errs := ValidateSlice(people)
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "[1].Age") {
+		t.Errorf("Expected error prefixed with [1].Age, got %q", errs[0])
+	}
+	if errs := ValidateSlice([]int{1, 2}); len(errs) != 1 {
+		t.Errorf("Expected a single error for non-struct elements, got %v", errs)
+	}
+}
+
+type validatedEndpoint struct {
+	Addr string `validate:"hostport"`
+}
+
+type validatedConfig struct {
+	Name      string              `validate:"nonempty"`
+	Etcd      validatedEndpoint   // 嵌套结构体
+	Fallback  *validatedEndpoint  // nil 时跳过
+	Services  []validatedEndpoint `validate:"min=1"`
+	TTL       int64               `validate:"required,min=1"`
+	unchecked string              // 未导出字段不校验
+}
+
+func TestValidateStruct(t *testing.T) {
+	valid := validatedConfig{
+		Name:     "order",
+		Etcd:     validatedEndpoint{Addr: "localhost:2379"},
+		Services: []validatedEndpoint{{Addr: "10.0.0.1:8080"}, {Addr: "[::1]:8081"}},
+		TTL:      10,
+	}
+	if err := ValidateStruct(&valid); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	invalid := validatedConfig{
+		Name:     "  ",
+		Etcd:     validatedEndpoint{Addr: "localhost"},
+		Fallback: &validatedEndpoint{Addr: ":2379"},
+		Services: []validatedEndpoint{{Addr: "10.0.0.1:8080"}, {Addr: "10.0.0.2:70000"}},
+	}
+	err := ValidateStruct(invalid)
+	if err == nil {
+		t.Fatalf("Expected validation errors")
+	}
+	for _, field := range []string{"Name: must not be empty", "Etcd.Addr:", "Fallback.Addr:", "Services[1].Addr:", "TTL: is required"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected error for %q, got:\n%v", field, err)
+		}
+	}
+	if strings.Contains(err.Error(), "Services[0]") {
+		t.Errorf("Valid slice element reported:\n%v", err)
+	}
+
+	// 空切片违反 min=1，nil 指针不递归
+	err = ValidateStruct(validatedConfig{Name: "order", Etcd: validatedEndpoint{Addr: "localhost:2379"}, TTL: 10})
+	if err == nil || !strings.Contains(err.Error(), "Services: length must be >= 1") {
+		t.Errorf("Expected Services length error, got %v", err)
+	}
+	if err := ValidateStruct(42); err == nil {
+		t.Errorf("Expected error for non-struct value")
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		rule  string
+		ok    bool
+	}{
+		{"", "required", false},
+		{"x", "required", true},
+		{" \t", "nonempty", false},
+		{"x", "nonempty", true},
+		{3, "nonempty", false},
+		{"localhost:2379", "hostport", true},
+		{"[::1]:2379", "hostport", true},
+		{"localhost", "hostport", false},
+		{":2379", "hostport", false},
+		{"localhost:0", "hostport", false},
+		{"localhost:http", "hostport", false},
+	} {
+		msg := checkRule(reflect.ValueOf(tc.value), tc.rule)
+		if (msg == "") != tc.ok {
+			t.Errorf("checkRule(%#v, %q) = %q, want ok=%v", tc.value, tc.rule, msg, tc.ok)
+		}
+	}
+}
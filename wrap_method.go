@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// WrapMethod 把 obj 的方法 name 包装为统一的 (results, error) 形式，供通用的中间件链和分发器调用
+// 如果方法的最后一个返回值是 error，它会从 results 中分离出来作为第二个返回值
+// 参数个数或类型不匹配时闭包返回错误，而不是让 reflect.Call panic
+func WrapMethod(obj interface{}, name string) (func(args ...interface{}) ([]interface{}, error), error) {
+	if obj == nil {
+		return nil, fmt.Errorf("wrap method %s: nil object", name)
+	}
+	m := reflect.ValueOf(obj).MethodByName(name)
+	if !m.IsValid() {
+		return nil, fmt.Errorf("wrap method: %T has no method %s", obj, name)
+	}
+	mt := m.Type()
+	hasErr := mt.NumOut() > 0 && mt.Out(mt.NumOut()-1) == errorType
+	return func(args ...interface{}) ([]interface{}, error) {
+		in, err := buildArgs(mt, args)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: %w", name, err)
+		}
+		out := m.Call(in)
+		if hasErr {
+			last := out[len(out)-1]
+			out = out[:len(out)-1]
+			if !last.IsNil() {
+				return valuesToInterfaces(out), last.Interface().(error)
+			}
+		}
+		return valuesToInterfaces(out), nil
+	}, nil
+}
+
+// CallMethod 按名字调用 obj 的方法，参数个数和类型先用 buildArgs 检查，不匹配时返回错误而不是 panic
+// 变长参数按展开的形式传入，例如 CallMethod(obj, "Sum", 1, 2, 3)
+// 与 WrapMethod 不同，全部返回值（包括 error）原样放在结果中
+func CallMethod(obj interface{}, name string, args ...interface{}) ([]interface{}, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("call method %s: nil object", name)
+	}
+	m := reflect.ValueOf(obj).MethodByName(name)
+	if !m.IsValid() {
+		return nil, fmt.Errorf("call method: %T has no method %s", obj, name)
+	}
+	in, err := buildArgs(m.Type(), args)
+	if err != nil {
+		return nil, fmt.Errorf("call %s: %w", name, err)
+	}
+	return valuesToInterfaces(m.Call(in)), nil
+}
+
+// buildArgs 检查参数个数和类型后把参数转换为 reflect.Value，变长参数的多余实参会逐个检查元素类型
+// nil 实参会转换为参数类型的零值（仅限指针、接口、切片等可为 nil 的类型）
+func buildArgs(mt reflect.Type, args []interface{}) ([]reflect.Value, error) {
+	numIn := mt.NumIn()
+	if mt.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("want at least %d arguments, got %d", numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("want %d arguments, got %d", numIn, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var want reflect.Type
+		if mt.IsVariadic() && i >= numIn-1 {
+			want = mt.In(numIn - 1).Elem()
+		} else {
+			want = mt.In(i)
+		}
+		if arg == nil {
+			switch want.Kind() {
+			case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+				in[i] = reflect.Zero(want)
+				continue
+			}
+			return nil, fmt.Errorf("argument %d: nil is not assignable to %s", i, want)
+		}
+		v := reflect.ValueOf(arg)
+		if !v.Type().AssignableTo(want) {
+			return nil, fmt.Errorf("argument %d: %s is not assignable to %s", i, v.Type(), want)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+func valuesToInterfaces(values []reflect.Value) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v.Interface()
+	}
+	return out
+}
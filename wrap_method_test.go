@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type divider struct{}
+
+func (divider) Div(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func TestWrapMethod(t *testing.T) {
+	div, err := WrapMethod(divider{}, "Div")
+	if err != nil {
+		t.Fatalf("WrapMethod failed: %v", err)
+	}
+	results, err := div(7, 2)
+	if err != nil || !reflect.DeepEqual(results, []interface{}{3}) {
+		t.Errorf("Div(7, 2) = %v, %v, want [3], nil", results, err)
+	}
+	if _, err := div(1, 0); err == nil || err.Error() != "division by zero" {
+		t.Errorf("Div(1, 0) error = %v, want division by zero", err)
+	}
+
+	// 没有 error 返回值的方法
+	add, err := WrapMethod(&Calculator{}, "Add")
+	if err != nil {
+		t.Fatalf("WrapMethod failed: %v", err)
+	}
+	results, err = add(5, 3)
+	if err != nil || !reflect.DeepEqual(results, []interface{}{8}) {
+		t.Errorf("Add(5, 3) = %v, %v, want [8], nil", results, err)
+	}
+	if _, err := add(5); err == nil {
+		t.Errorf("Expected arity error")
+	}
+	if _, err := add(5, "3"); err == nil {
+		t.Errorf("Expected type error")
+	}
+	if _, err := WrapMethod(&Calculator{}, "Sub"); err == nil {
+		t.Errorf("Expected error for missing method")
+	}
+}
+
+type joiner struct{}
+
+func (joiner) Join(sep string, parts ...string) string {
+	return strings.Join(parts, sep)
+}
+
+func TestCallMethod(t *testing.T) {
+	results, err := CallMethod(&Calculator{}, "Add", 5, 3)
+	if err != nil || !reflect.DeepEqual(results, []interface{}{8}) {
+		t.Errorf("Add(5, 3) = %v, %v, want [8], nil", results, err)
+	}
+	// error 返回值原样保留在结果中
+	results, err = CallMethod(divider{}, "Div", 1, 0)
+	if err != nil || len(results) != 2 || results[1] == nil {
+		t.Errorf("Div(1, 0) = %v, %v, want the method's error in results", results, err)
+	}
+
+	for _, tc := range []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{","}, ""},
+		{[]interface{}{",", "a"}, "a"},
+		{[]interface{}{",", "a", "b", "c"}, "a,b,c"},
+	} {
+		results, err := CallMethod(joiner{}, "Join", tc.args...)
+		if err != nil || !reflect.DeepEqual(results, []interface{}{tc.want}) {
+			t.Errorf("Join%v = %v, %v, want %q", tc.args, results, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		obj  interface{}
+		name string
+		args []interface{}
+	}{
+		{&Calculator{}, "Add", []interface{}{5}},
+		{&Calculator{}, "Add", []interface{}{5, 3, 1}},
+		{&Calculator{}, "Add", []interface{}{5, "3"}},
+		{joiner{}, "Join", nil},
+		{joiner{}, "Join", []interface{}{",", "a", 1}},
+		{&Calculator{}, "Sub", nil},
+		{nil, "Add", nil},
+	} {
+		if _, err := CallMethod(tc.obj, tc.name, tc.args...); err == nil {
+			t.Errorf("CallMethod(%T, %s, %v) expected error", tc.obj, tc.name, tc.args)
+		}
+	}
+}